@@ -12,15 +12,21 @@ import (
 	"github.com/PlakarKorp/plakar/utils"
 )
 
-// Parse a URL parameter with the format "snapshotID:path".
+// Parse a URL parameter with the format "snapshotID:path", where
+// snapshotID may also be a reference expression such as "job=web@latest".
 func SnapshotPathParam(r *http.Request, repo *repository.Repository, param string) (objects.MAC, string, error) {
-	idstr, path := utils.ParseSnapshotID(r.PathValue(param))
+	value := r.PathValue(param)
+	idstr, path := locate.ParseSnapshotPath(value)
+	if len(idstr) < len(value) {
+		// the snapshot is followed by a path
+		_, path = utils.ParseSnapshotID(":" + path)
+	}
 
 	if idstr == "" {
 		return objects.MAC{}, "", parameterError(param, MissingArgument, ErrMissingField)
 	}
 
-	mac, err := locate.LocateSnapshotByPrefix(repo, idstr)
+	mac, err := locate.LocateSnapshot(repo, idstr)
 	if err != nil {
 		return objects.MAC{}, "", parameterError(param, InvalidArgument, err)
 	}
@@ -2,10 +2,12 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"path"
 	"slices"
 	"sort"
 	"strings"
@@ -17,6 +19,7 @@ import (
 	"github.com/PlakarKorp/kloset/snapshot/header"
 	"github.com/PlakarKorp/kloset/snapshot/vfs"
 	"github.com/PlakarKorp/kloset/storage"
+	"github.com/PlakarKorp/plakar/locate"
 )
 
 type RepositoryInfoSnapshots struct {
@@ -35,18 +38,17 @@ type RepositoryInfoResponse struct {
 
 func getNSnapshotsPerDay(repo *repository.Repository, ndays int) ([]int, error) {
 	nSnapshotsPerDay := make([]int, ndays)
-	for snapshotID := range repo.ListSnapshots() {
-		snap, err := snapshot.Load(repo, snapshotID)
-		if err != nil {
-			continue
-		}
-		if !snap.Header.Timestamp.Before(repo.Configuration().Timestamp.AddDate(0, 0, -ndays)) {
-			dayIndex := time.Since(snap.Header.Timestamp).Hours() / 24
+	headers, err := locate.Headers(repo, 1)
+	if err != nil {
+		return nil, err
+	}
+	for _, hdr := range headers {
+		if !hdr.Timestamp.Before(repo.Configuration().Timestamp.AddDate(0, 0, -ndays)) {
+			dayIndex := time.Since(hdr.Timestamp).Hours() / 24
 			if dayIndex < float64(ndays) {
 				nSnapshotsPerDay[(ndays-1)-int(dayIndex)]++
 			}
 		}
-		snap.Close()
 	}
 
 	return nSnapshotsPerDay, nil
@@ -132,32 +134,24 @@ func (ui *uiserver) repositorySnapshots(w http.ResponseWriter, r *http.Request)
 
 	ui.repository.RebuildState()
 
-	snapshotIDs, err := ui.repository.GetSnapshots()
+	cached, err := locate.Headers(ui.repository, 1)
 	if err != nil {
 		return err
 	}
 
 	totalSnapshots := int(0)
-	headers := make([]header.Header, 0, len(snapshotIDs))
-	for _, snapshotID := range snapshotIDs {
-		snap, err := snapshot.Load(ui.repository, snapshotID)
-		if err != nil {
-			return err
-		}
-
-		if importerType != "" && strings.ToLower(snap.Header.GetSource(0).Importer.Type) != strings.ToLower(importerType) {
-			snap.Close()
+	headers := make([]header.Header, 0, len(cached))
+	for _, hdr := range cached {
+		if importerType != "" && strings.ToLower(hdr.GetSource(0).Importer.Type) != strings.ToLower(importerType) {
 			continue
 		}
 
-		if since != "" && snap.Header.Timestamp.Before(sinceTime) {
-			snap.Close()
+		if since != "" && hdr.Timestamp.Before(sinceTime) {
 			continue
 		}
 
-		headers = append(headers, *snap.Header)
+		headers = append(headers, *hdr)
 		totalSnapshots++
-		snap.Close()
 	}
 
 	if limit == 0 {
@@ -221,18 +215,14 @@ func (ui *uiserver) repositoryState(w http.ResponseWriter, r *http.Request) erro
 func (ui *uiserver) repositoryImporterTypes(w http.ResponseWriter, r *http.Request) error {
 	ui.repository.RebuildState()
 
-	snapshotIDs, err := ui.repository.GetSnapshots()
+	headers, err := locate.Headers(ui.repository, 1)
 	if err != nil {
 		return err
 	}
 
 	importerTypesMap := make(map[string]struct{})
-	for _, snapshotID := range snapshotIDs {
-		snap, err := snapshot.Load(ui.repository, snapshotID)
-		if err != nil {
-			return err
-		}
-		importerTypesMap[strings.ToLower(snap.Header.GetSource(0).Importer.Type)] = struct{}{}
+	for _, hdr := range headers {
+		importerTypesMap[strings.ToLower(hdr.GetSource(0).Importer.Type)] = struct{}{}
 	}
 
 	importerTypes := make([]string, 0, len(importerTypesMap))
@@ -300,63 +290,52 @@ func (ui *uiserver) repositoryLocatePathname(w http.ResponseWriter, r *http.Requ
 
 	ui.repository.RebuildState()
 
-	snapshotIDs, err := ui.repository.GetSnapshots()
+	headers, err := locate.Headers(ui.repository, 1)
 	if err != nil {
 		return err
 	}
 
-	totalSnapshots := int(0)
-	locations := make([]TimelineLocation, 0, len(snapshotIDs))
-	for _, snapshotID := range snapshotIDs {
-		snap, err := snapshot.Load(ui.repository, snapshotID)
-		if err != nil {
-			return err
-		}
-
-		if importerType != "" && !strings.EqualFold(snap.Header.GetSource(0).Importer.Type, importerType) {
-			snap.Close()
+	candidates := make(map[objects.MAC]*header.Header, len(headers))
+	snapshotIDs := make([]objects.MAC, 0, len(headers))
+	for _, hdr := range headers {
+		if importerType != "" && !strings.EqualFold(hdr.GetSource(0).Importer.Type, importerType) {
 			continue
 		}
 
-		if importerOrigin != "" && !strings.EqualFold(snap.Header.GetSource(0).Importer.Origin, importerOrigin) {
-			snap.Close()
+		if importerOrigin != "" && !strings.EqualFold(hdr.GetSource(0).Importer.Origin, importerOrigin) {
 			continue
 		}
 
-		if importerDirectory != "" && !strings.EqualFold(snap.Header.GetSource(0).Importer.Directory, importerDirectory) {
-			snap.Close()
+		if importerDirectory != "" && !strings.EqualFold(hdr.GetSource(0).Importer.Directory, importerDirectory) {
 			continue
 		}
 
-		pvfs, err := snap.Filesystem()
-		if err != nil {
-			snap.Close()
-			continue
-		}
+		candidates[hdr.Identifier] = hdr
+		snapshotIDs = append(snapshotIDs, hdr.Identifier)
+	}
 
-		entry, err := pvfs.GetEntry(resource)
-		if err != nil {
-			snap.Close()
-			continue
-		}
+	// the index tells which snapshots hold the resource, only those
+	// returned are loaded to fetch its entry
+	found, err := lookupResource(ui.repository, path.Clean(resource), snapshotIDs)
+	if err != nil {
+		return err
+	}
 
-		locations = append(locations, TimelineLocation{
-			Snapshot: *snap.Header,
-			Entry:    *entry,
-		})
-		totalSnapshots++
-		snap.Close()
+	matches := make([]*header.Header, 0, len(found))
+	for _, loc := range found {
+		matches = append(matches, candidates[loc.SnapshotID])
 	}
+	totalSnapshots := len(matches)
 
 	if limit == 0 {
-		limit = uint32(len(locations))
+		limit = uint32(len(matches))
 	}
 
-	sortFunc := func(a, b TimelineLocation) int {
-		if a.Snapshot.Timestamp.Before(b.Snapshot.Timestamp) {
+	sortFunc := func(a, b *header.Header) int {
+		if a.Timestamp.Before(b.Timestamp) {
 			return -1
 		}
-		if a.Snapshot.Timestamp.After(b.Snapshot.Timestamp) {
+		if a.Timestamp.After(b.Timestamp) {
 			return 1
 		}
 		return 0
@@ -365,11 +344,11 @@ func (ui *uiserver) repositoryLocatePathname(w http.ResponseWriter, r *http.Requ
 	if len(sortKeys) > 0 {
 		switch sortKeys[0] {
 		case "-Timestamp":
-			sortFunc = func(a, b TimelineLocation) int {
-				if a.Snapshot.Timestamp.After(b.Snapshot.Timestamp) {
+			sortFunc = func(a, b *header.Header) int {
+				if a.Timestamp.After(b.Timestamp) {
 					return -1
 				}
-				if a.Snapshot.Timestamp.Before(b.Snapshot.Timestamp) {
+				if a.Timestamp.Before(b.Timestamp) {
 					return 1
 				}
 				return 0
@@ -377,14 +356,40 @@ func (ui *uiserver) repositoryLocatePathname(w http.ResponseWriter, r *http.Requ
 		}
 	}
 
-	slices.SortFunc(locations, sortFunc)
+	slices.SortFunc(matches, sortFunc)
 
-	if offset > uint32(len(locations)) {
-		locations = []TimelineLocation{}
-	} else if offset+limit > uint32(len(locations)) {
-		locations = locations[offset:]
+	if offset > uint32(len(matches)) {
+		matches = []*header.Header{}
+	} else if offset+limit > uint32(len(matches)) {
+		matches = matches[offset:]
 	} else {
-		locations = locations[offset : offset+limit]
+		matches = matches[offset : offset+limit]
+	}
+
+	locations := make([]TimelineLocation, 0, len(matches))
+	for _, hdr := range matches {
+		snap, err := snapshot.Load(ui.repository, hdr.Identifier)
+		if err != nil {
+			return err
+		}
+
+		pvfs, err := snap.Filesystem()
+		if err != nil {
+			snap.Close()
+			continue
+		}
+
+		entry, err := pvfs.GetEntry(resource)
+		if err != nil {
+			snap.Close()
+			continue
+		}
+
+		locations = append(locations, TimelineLocation{
+			Snapshot: *snap.Header,
+			Entry:    *entry,
+		})
+		snap.Close()
 	}
 
 	items := Items[TimelineLocation]{
@@ -395,3 +400,20 @@ func (ui *uiserver) repositoryLocatePathname(w http.ResponseWriter, r *http.Requ
 
 	return json.NewEncoder(w).Encode(items)
 }
+
+// lookupResource finds the snapshots holding pathname through the index,
+// or by looking into each of them if another process holds the index.
+func lookupResource(repo *repository.Repository, pathname string, snapshotIDs []objects.MAC) ([]locate.Location, error) {
+	index, err := locate.OpenIndex(repo)
+	if errors.Is(err, locate.ErrIndexBusy) {
+		return locate.WalkLookup(repo, pathname, snapshotIDs)
+	} else if err != nil {
+		return nil, err
+	}
+	defer index.Close()
+
+	if err := index.Update(repo, snapshotIDs); err != nil {
+		return nil, err
+	}
+	return index.Lookup(pathname, snapshotIDs)
+}
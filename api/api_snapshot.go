@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"path"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"time"
 
@@ -20,6 +21,7 @@ import (
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/kloset/snapshot/header"
 	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/locate"
 	"github.com/alecthomas/chroma/formatters"
 	"github.com/alecthomas/chroma/lexers"
 	"github.com/alecthomas/chroma/styles"
@@ -648,6 +650,105 @@ func (ui *uiserver) snapshotVFSErrors(w http.ResponseWriter, r *http.Request) er
 	return json.NewEncoder(w).Encode(items)
 }
 
+type HistoryVersion struct {
+	First     header.Header `json:"first"`
+	Last      header.Header `json:"last"`
+	Snapshots int           `json:"snapshots"`
+	Digest    objects.MAC   `json:"digest"`
+	Entry     vfs.Entry     `json:"vfs_entry"`
+}
+
+// snapshotVFSHistory lists the distinct versions of a path across the
+// snapshots taken by the same importer as the given one.
+func (ui *uiserver) snapshotVFSHistory(w http.ResponseWriter, r *http.Request) error {
+	snapshotID32, entrypath, err := SnapshotPathParam(r, ui.repository, "snapshot_path")
+	if err != nil {
+		return err
+	}
+
+	offset, err := QueryParamToInt64(r, "offset", 0, 0)
+	if err != nil {
+		return err
+	}
+
+	limit, err := QueryParamToInt64(r, "limit", 1, 50)
+	if err != nil {
+		return err
+	}
+
+	sortKeys, err := QueryParamToSortKeys(r, "sort", "Timestamp")
+	if err != nil {
+		return err
+	}
+
+	if entrypath == "" {
+		entrypath = "/"
+	}
+
+	snap, err := loadsnap(ui.repository, snapshotID32)
+	if err != nil {
+		return err
+	}
+	importer := snap.Header.GetSource(0).Importer
+
+	headers, err := locate.Headers(ui.repository, ui.repository.AppContext().MaxConcurrency)
+	if err != nil {
+		return err
+	}
+
+	candidates := make([]*header.Header, 0, len(headers))
+	snapshotIDs := make([]objects.MAC, 0, len(headers))
+	for _, hdr := range headers {
+		hdrImporter := hdr.GetSource(0).Importer
+		if hdrImporter.Type != importer.Type || hdrImporter.Origin != importer.Origin {
+			continue
+		}
+		candidates = append(candidates, hdr)
+		snapshotIDs = append(snapshotIDs, hdr.Identifier)
+	}
+
+	// only the snapshots holding the pathname are loaded
+	entrypath = path.Clean(entrypath)
+	found, err := lookupResource(ui.repository, entrypath, snapshotIDs)
+	if err != nil {
+		return err
+	}
+
+	opts := locate.NewDefaultLocateOptions()
+	opts.SortOrder = locate.LocateSortOrderAscending
+	versions, err := locate.HistoryOfLocations(ui.repository, locate.LocateHeaders(candidates, opts), entrypath, found)
+	if err != nil {
+		return err
+	}
+
+	if len(sortKeys) > 0 && sortKeys[0] == "-Timestamp" {
+		slices.Reverse(versions)
+	}
+
+	items := Items[HistoryVersion]{
+		Total: len(versions),
+		Items: []HistoryVersion{},
+	}
+	for i := offset; i < min(offset+limit, int64(len(versions))); i++ {
+		version := versions[i]
+
+		// These might be huge and we don't need them in this
+		// context in the UI.
+		if version.Entry.ResolvedObject != nil {
+			version.Entry.ResolvedObject.Chunks = nil
+		}
+
+		items.Items = append(items.Items, HistoryVersion{
+			First:     *version.First,
+			Last:      *version.Last,
+			Snapshots: version.Snapshots,
+			Digest:    version.Digest(),
+			Entry:     *version.Entry,
+		})
+	}
+	return json.NewEncoder(w).Encode(items)
+}
+
 type DownloadItem struct {
 	Pathname string `json:"pathname"`
 }
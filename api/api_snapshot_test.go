@@ -15,6 +15,7 @@ import (
 	"github.com/PlakarKorp/kloset/logging"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/kloset/storage"
 	"github.com/PlakarKorp/kloset/versioning"
 	"github.com/PlakarKorp/plakar/appcontext"
@@ -326,3 +327,49 @@ func _TestSnapshotSign(t *testing.T) {
 		})
 	}
 }
+
+func TestSnapshotVFSHistory(t *testing.T) {
+	repo, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+
+	var snaps []*snapshot.Snapshot
+	for _, content := range []string{"hello dummy", "hello dummy", "hello dummy, again"} {
+		snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+			ptesting.NewMockDir("subdir"),
+			ptesting.NewMockFile("subdir/dummy.txt", 0644, content),
+		})
+		defer snap.Close()
+		snaps = append(snaps, snap)
+	}
+
+	var noToken string
+	mux := http.NewServeMux()
+	SetupRoutes(mux, repo, ctx, noToken)
+
+	get := func(query string) Items[HistoryVersion] {
+		req, err := http.NewRequest("GET", fmt.Sprintf("/api/snapshot/vfs/history/%x:/subdir/dummy.txt%s", snaps[2].Header.Identifier, query), nil)
+		require.NoError(t, err, "creating request")
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var items Items[HistoryVersion]
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &items))
+		return items
+	}
+
+	items := get("")
+	require.Equal(t, 2, items.Total)
+	require.Len(t, items.Items, 2)
+	require.Equal(t, snaps[0].Header.Identifier, items.Items[0].First.Identifier)
+	require.Equal(t, snaps[1].Header.Identifier, items.Items[0].Last.Identifier)
+	require.Equal(t, 2, items.Items[0].Snapshots)
+	require.Equal(t, snaps[2].Header.Identifier, items.Items[1].First.Identifier)
+	require.Equal(t, int64(len("hello dummy, again")), items.Items[1].Entry.Size())
+	require.NotEqual(t, items.Items[0].Digest, items.Items[1].Digest)
+
+	items = get("?sort=-Timestamp&limit=1")
+	require.Equal(t, 2, items.Total)
+	require.Len(t, items.Items, 1)
+	require.Equal(t, snaps[2].Header.Identifier, items.Items[0].First.Identifier)
+}
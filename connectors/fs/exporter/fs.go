@@ -19,6 +19,7 @@ package fs
 import (
 	"context"
 	"io"
+	"io/fs"
 	"os"
 	"strings"
 
@@ -84,6 +85,14 @@ func (p *FSExporter) SetPermissions(pathname string, fileinfo *objects.FileInfo)
 	return nil
 }
 
+func (p *FSExporter) Stat(pathname string) (fs.FileInfo, error) {
+	return os.Lstat(pathname)
+}
+
+func (p *FSExporter) Open(pathname string) (io.ReadCloser, error) {
+	return os.Open(pathname)
+}
+
 func (p *FSExporter) Close() error {
 	return nil
 }
@@ -2,6 +2,7 @@ package fs
 
 import (
 	"io"
+	"io/fs"
 	"os"
 	"testing"
 
@@ -53,6 +54,27 @@ func TestExporter(t *testing.T) {
 
 	require.Equal(t, string(data), string(newContent))
 
+	// existing files can be inspected
+	inspector, ok := exporterInstance.(interface {
+		Stat(string) (fs.FileInfo, error)
+		Open(string) (io.ReadCloser, error)
+	})
+	require.True(t, ok)
+
+	info, err := inspector.Stat(tmpExportDir + "/dummy.txt")
+	require.NoError(t, err)
+	require.Equal(t, datalen, info.Size())
+
+	_, err = inspector.Stat(tmpExportDir + "/missing.txt")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	rd, err := inspector.Open(tmpExportDir + "/dummy.txt")
+	require.NoError(t, err)
+	content, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	require.NoError(t, rd.Close())
+	require.Equal(t, data, content)
+
 	err = exporterInstance.CreateDirectory(tmpExportDir + "/subdir")
 	require.NoError(t, err)
 
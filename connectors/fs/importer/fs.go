@@ -29,6 +29,7 @@ import (
 
 	"github.com/PlakarKorp/kloset/location"
 	"github.com/PlakarKorp/kloset/snapshot/importer"
+	"github.com/PlakarKorp/plakar/exclude"
 )
 
 type FSImporter struct {
@@ -43,6 +44,8 @@ type FSImporter struct {
 
 	nocrossfs bool
 	devno     uint64
+
+	excludes *exclude.Ruleset
 }
 
 func init() {
@@ -75,9 +78,16 @@ func NewFSImporter(appCtx context.Context, opts *importer.Options, name string,
 		gidToName: make(map[uint64]string),
 		nocrossfs: nocrossfs,
 		devno:     devno,
+		excludes:  exclude.NewRuleset(),
 	}, nil
 }
 
+// AddExcludes adds exclusion patterns relative to the root of the import,
+// they are applied during the walk so excluded directories are not visited.
+func (p *FSImporter) AddExcludes(patterns []string) error {
+	return p.excludes.Add(toslash(p.realpath), patterns)
+}
+
 func (p *FSImporter) Origin() string {
 	return p.opts.Hostname
 }
@@ -117,6 +127,13 @@ func (f *FSImporter) walkDir_walker(results chan<- *importer.ScanResult, numWork
 			return nil
 		}
 
+		if path != f.realpath && f.excludes.Match(toslash(path), d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
 		if d.IsDir() && f.nocrossfs {
 			same, err := isSameFs(f.devno, d)
 			if err != nil {
@@ -128,6 +145,13 @@ func (f *FSImporter) walkDir_walker(results chan<- *importer.ScanResult, numWork
 			}
 		}
 
+		if d.IsDir() {
+			ignoreFile := filepath.Join(path, exclude.IgnoreFile)
+			if err := f.excludes.AddFile(toslash(path), ignoreFile); err != nil && !os.IsNotExist(err) {
+				results <- importer.NewScanError(ignoreFile, err)
+			}
+		}
+
 		jobs <- path
 		return nil
 	})
@@ -61,3 +61,46 @@ func TestFSImporter(t *testing.T) {
 	err = importer.Close()
 	require.NoError(t, err)
 }
+
+func TestFSImporterExcludes(t *testing.T) {
+	tmpImportDir, err := os.MkdirTemp("/tmp", "tmp_import*")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		os.RemoveAll(tmpImportDir)
+	})
+
+	require.NoError(t, os.MkdirAll(tmpImportDir+"/web/node_modules/pkg", 0755))
+	require.NoError(t, os.MkdirAll(tmpImportDir+"/logs", 0755))
+	require.NoError(t, os.WriteFile(tmpImportDir+"/web/index.js", []byte("index"), 0644))
+	require.NoError(t, os.WriteFile(tmpImportDir+"/web/node_modules/pkg/lib.js", []byte("lib"), 0644))
+	require.NoError(t, os.WriteFile(tmpImportDir+"/web/.plakarignore", []byte("node_modules/\n"), 0644))
+	require.NoError(t, os.WriteFile(tmpImportDir+"/logs/app.log", []byte("log"), 0644))
+	require.NoError(t, os.WriteFile(tmpImportDir+"/logs/keep.log", []byte("log"), 0644))
+
+	ctx := appcontext.NewAppContext()
+
+	imp, err := NewFSImporter(ctx, ctx.ImporterOpts(), "fs", map[string]string{"location": tmpImportDir})
+	require.NoError(t, err)
+	require.NoError(t, imp.(*FSImporter).AddExcludes([]string{"*.log", "!keep.log"}))
+
+	scanChan, err := imp.Scan()
+	require.NoError(t, err)
+
+	paths := []string{}
+	for record := range scanChan {
+		require.Nil(t, record.Error)
+		if !record.Record.IsXattr {
+			paths = append(paths, record.Record.Pathname)
+		}
+		record.Record.Close()
+	}
+	expected := []string{"/", "/tmp", tmpImportDir,
+		tmpImportDir + "/logs",
+		tmpImportDir + "/logs/keep.log",
+		tmpImportDir + "/web",
+		tmpImportDir + "/web/.plakarignore",
+		tmpImportDir + "/web/index.js",
+	}
+	sort.Strings(paths)
+	require.Equal(t, expected, paths)
+}
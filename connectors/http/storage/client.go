@@ -19,10 +19,15 @@ package http
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 
 	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/storage"
@@ -33,6 +38,11 @@ type Store struct {
 	config     storage.Configuration
 	Repository string
 	location   string
+	token      string
+	client     *http.Client
+
+	// negotiated at Open
+	protocol int
 }
 
 func init() {
@@ -40,12 +50,88 @@ func init() {
 	storage.Register("https", 0, NewStore)
 }
 
+// NewStore connects to a plakar server.  The token option is sent as a
+// bearer token, tls_ca verifies the server against a custom CA, tls_cert
+// and tls_key authenticate the client with a certificate, and tls_insecure
+// disables the verification of the server certificate.
 func NewStore(ctx context.Context, proto string, storeConfig map[string]string) (storage.Store, error) {
+	token := storeConfig["token"]
+	if token == "" && storeConfig["token_file"] != "" {
+		data, err := os.ReadFile(storeConfig["token_file"])
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	tlsConfig, err := clientTLSConfig(storeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{}
+	if tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		client.Transport = transport
+	}
+
 	return &Store{
 		location: storeConfig["location"],
+		token:    token,
+		client:   client,
+		protocol: network.ProtocolV1,
 	}, nil
 }
 
+func clientTLSConfig(storeConfig map[string]string) (*tls.Config, error) {
+	caFile := storeConfig["tls_ca"]
+	certFile := storeConfig["tls_cert"]
+	keyFile := storeConfig["tls_key"]
+
+	insecure := false
+	if value, ok := storeConfig["tls_insecure"]; ok {
+		var err error
+		if insecure, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid tls_insecure value %q", value)
+		}
+	}
+
+	if caFile == "" && certFile == "" && keyFile == "" && !insecure {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: insecure,
+	}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificate found", caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("tls_cert and tls_key must be given together")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
 func (s *Store) Location() string {
 	return s.location
 }
@@ -60,8 +146,100 @@ func (s *Store) sendRequest(method string, requestType string, payload interface
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
-	return client.Do(req)
+	return s.do(req)
+}
+
+func (s *Store) do(req *http.Request) (*http.Response, error) {
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// the v1 protocol reports errors from the store in the responses, other
+	// errors such as a denied access come as a plain http error
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		defer res.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		if text := strings.TrimSpace(string(msg)); text != "" {
+			return nil, fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, text)
+		}
+		return nil, fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, res.Status)
+	}
+	return res, nil
+}
+
+// bodyReader closes the response body once it has been read through, and
+// reports a body shorter than announced as an unexpected EOF.
+type bodyReader struct {
+	rd       io.Reader
+	body     io.Closer
+	expected int64
+	n        int64
+}
+
+func newBodyReader(r *http.Response, limit int64) *bodyReader {
+	var rd io.Reader = r.Body
+	expected := r.ContentLength
+	if limit >= 0 {
+		rd = io.LimitReader(rd, limit)
+		expected = limit
+	}
+	return &bodyReader{rd: rd, body: r.Body, expected: expected}
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.rd.Read(p)
+	b.n += int64(n)
+	if err == io.EOF && b.expected >= 0 && b.n < b.expected {
+		err = fmt.Errorf("received %d of %d bytes: %w", b.n, b.expected, io.ErrUnexpectedEOF)
+	}
+	if err != nil {
+		b.body.Close()
+	}
+	return n, err
+}
+
+// countingReader counts the bytes streamed to the server.
+type countingReader struct {
+	rd io.Reader
+	n  int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.rd.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (s *Store) getStream(resource string, mac objects.MAC, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/v2/%s/%x", s.location, resource, mac), nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	return s.do(req)
+}
+
+func (s *Store) putStream(resource string, mac objects.MAC, rd io.Reader) (int64, error) {
+	counter := &countingReader{rd: rd}
+	req, err := http.NewRequest("PUT", fmt.Sprintf("%s/v2/%s/%x", s.location, resource, mac), counter)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	res, err := s.do(req)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+	return counter.n, nil
 }
 
 func (s *Store) Create(ctx context.Context, config []byte) error {
@@ -72,6 +250,7 @@ func (s *Store) Open(ctx context.Context) ([]byte, error) {
 	s.Repository = s.location
 	r, err := s.sendRequest("GET", "/", network.ReqOpen{
 		Repository: "",
+		Protocols:  []int{network.ProtocolV1, network.ProtocolV2},
 	})
 	if err != nil {
 		return nil, err
@@ -84,6 +263,11 @@ func (s *Store) Open(ctx context.Context) ([]byte, error) {
 	if resOpen.Err != "" {
 		return nil, fmt.Errorf("%s", resOpen.Err)
 	}
+
+	// older servers don't negotiate and only speak v1
+	if resOpen.Protocol == network.ProtocolV2 {
+		s.protocol = network.ProtocolV2
+	}
 	return resOpen.Configuration, nil
 }
 
@@ -122,6 +306,10 @@ func (s *Store) GetStates() ([]objects.MAC, error) {
 }
 
 func (s *Store) PutState(MAC objects.MAC, rd io.Reader) (int64, error) {
+	if s.protocol >= network.ProtocolV2 {
+		return s.putStream("states", MAC, rd)
+	}
+
 	data, err := io.ReadAll(rd)
 	if err != nil {
 		return 0, err
@@ -146,6 +334,14 @@ func (s *Store) PutState(MAC objects.MAC, rd io.Reader) (int64, error) {
 }
 
 func (s *Store) GetState(MAC objects.MAC) (io.Reader, error) {
+	if s.protocol >= network.ProtocolV2 {
+		r, err := s.getStream("states", MAC, nil)
+		if err != nil {
+			return nil, err
+		}
+		return newBodyReader(r, -1), nil
+	}
+
 	r, err := s.sendRequest("GET", "/state", network.ReqGetState{
 		MAC: MAC,
 	})
@@ -204,6 +400,10 @@ func (s *Store) GetPackfiles() ([]objects.MAC, error) {
 }
 
 func (s *Store) PutPackfile(MAC objects.MAC, rd io.Reader) (int64, error) {
+	if s.protocol >= network.ProtocolV2 {
+		return s.putStream("packfiles", MAC, rd)
+	}
+
 	data, err := io.ReadAll(rd)
 	if err != nil {
 		return 0, err
@@ -227,6 +427,14 @@ func (s *Store) PutPackfile(MAC objects.MAC, rd io.Reader) (int64, error) {
 }
 
 func (s *Store) GetPackfile(MAC objects.MAC) (io.Reader, error) {
+	if s.protocol >= network.ProtocolV2 {
+		r, err := s.getStream("packfiles", MAC, nil)
+		if err != nil {
+			return nil, err
+		}
+		return newBodyReader(r, -1), nil
+	}
+
 	r, err := s.sendRequest("GET", "/packfile", network.ReqGetPackfile{
 		MAC: MAC,
 	})
@@ -245,6 +453,23 @@ func (s *Store) GetPackfile(MAC objects.MAC) (io.Reader, error) {
 }
 
 func (s *Store) GetPackfileBlob(MAC objects.MAC, offset uint64, length uint32) (io.Reader, error) {
+	if s.protocol >= network.ProtocolV2 {
+		if length == 0 {
+			return bytes.NewReader(nil), nil
+		}
+		header := http.Header{}
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+uint64(length)-1))
+		r, err := s.getStream("packfiles", MAC, header)
+		if err != nil {
+			return nil, err
+		}
+		if r.StatusCode != http.StatusPartialContent {
+			r.Body.Close()
+			return nil, fmt.Errorf("server ignored the range request for packfile %x", MAC)
+		}
+		return newBodyReader(r, int64(length)), nil
+	}
+
 	r, err := s.sendRequest("GET", "/packfile/blob", network.ReqGetPackfileBlob{
 		MAC:    MAC,
 		Offset: offset,
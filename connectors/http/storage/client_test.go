@@ -317,3 +317,76 @@ func TestHttpBackend(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "test4", buf.String())
 }
+
+func TestHttpBackendAuth(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /states", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{}`))
+	})
+
+	ts := httptest.NewTLSServer(mux)
+	t.Cleanup(ts.Close)
+
+	ctx := appcontext.NewAppContext()
+	defer ctx.Close()
+
+	// the test server certificate is self-signed
+	repo, err := NewStore(ctx, "https", map[string]string{"location": ts.URL})
+	require.NoError(t, err)
+	_, err = repo.GetStates()
+	require.Error(t, err)
+
+	repo, err = NewStore(ctx, "https", map[string]string{"location": ts.URL, "tls_insecure": "true"})
+	require.NoError(t, err)
+	_, err = repo.GetStates()
+	require.ErrorContains(t, err, "invalid bearer token")
+
+	repo, err = NewStore(ctx, "https", map[string]string{"location": ts.URL, "tls_insecure": "true", "token": "secret"})
+	require.NoError(t, err)
+	_, err = repo.GetStates()
+	require.NoError(t, err)
+
+	_, err = NewStore(ctx, "https", map[string]string{"location": ts.URL, "tls_cert": "cert.pem"})
+	require.Error(t, err)
+}
+
+func TestHttpBackendTruncatedStream(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v2/states/{mac}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "10")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("short"))
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	})
+	mux.HandleFunc("GET /v2/packfiles/{mac}", func(w http.ResponseWriter, r *http.Request) {
+		// no Content-Length: the client must still check the range length
+		w.WriteHeader(http.StatusPartialContent)
+		w.(http.Flusher).Flush()
+		w.Write([]byte("short"))
+	})
+
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	ctx := appcontext.NewAppContext()
+	defer ctx.Close()
+
+	repo, err := NewStore(ctx, "http", map[string]string{"location": ts.URL})
+	require.NoError(t, err)
+	repo.(*Store).protocol = network.ProtocolV2
+
+	rd, err := repo.GetState(objects.MAC{0x10})
+	require.NoError(t, err)
+	_, err = io.ReadAll(rd)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	rd, err = repo.GetPackfileBlob(objects.MAC{0x10}, 0, 10)
+	require.NoError(t, err)
+	_, err = io.ReadAll(rd)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
@@ -19,6 +19,7 @@ package sftp
 import (
 	"context"
 	"io"
+	"io/fs"
 	"net/url"
 	"os"
 
@@ -99,6 +100,14 @@ func (p *SFTPExporter) SetPermissions(pathname string, fileinfo *objects.FileInf
 	return nil
 }
 
+func (p *SFTPExporter) Stat(pathname string) (fs.FileInfo, error) {
+	return p.client.Lstat(pathname)
+}
+
+func (p *SFTPExporter) Open(pathname string) (io.ReadCloser, error) {
+	return p.client.Open(pathname)
+}
+
 func (p *SFTPExporter) Close() error {
 	return p.client.Close()
 }
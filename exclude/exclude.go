@@ -0,0 +1,281 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+// Package exclude implements .gitignore-style exclusion rules.
+package exclude
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// IgnoreFile is the name of the per-directory files holding exclusion
+// rules for the directory they live in and everything below it.
+const IgnoreFile = ".plakarignore"
+
+// Excluder is implemented by importers that can apply exclusion rules
+// while walking, and thus skip excluded directories altogether.
+type Excluder interface {
+	AddExcludes(patterns []string) error
+}
+
+type rule struct {
+	pattern  string
+	base     string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+	re       *regexp.Regexp
+}
+
+// Ruleset is an ordered list of rules, each relative to the directory it
+// was defined for.  As with .gitignore, the last matching rule wins, and
+// rules read later from deeper directories override earlier ones.
+type Ruleset struct {
+	mu    sync.RWMutex
+	rules []rule
+}
+
+func NewRuleset() *Ruleset {
+	return &Ruleset{}
+}
+
+func (rs *Ruleset) Empty() bool {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return len(rs.rules) == 0
+}
+
+// Add parses patterns and appends them as rules relative to base.  Blank
+// lines and comments are skipped.
+func (rs *Ruleset) Add(base string, patterns []string) error {
+	var rules []rule
+	for _, pattern := range patterns {
+		r, ok, err := parseRule(base, pattern)
+		if err != nil {
+			return err
+		}
+		if ok {
+			rules = append(rules, r)
+		}
+	}
+
+	rs.mu.Lock()
+	rs.rules = append(rs.rules, rules...)
+	rs.mu.Unlock()
+	return nil
+}
+
+// AddFile reads the patterns of an ignore file and appends them as rules
+// relative to base.
+func (rs *Ruleset) AddFile(base, filename string) error {
+	patterns, err := ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if err := rs.Add(base, patterns); err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	return nil
+}
+
+// ReadFile returns the lines of an ignore file.
+func ReadFile(filename string) ([]string, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// Validate reports whether a pattern is well-formed.
+func Validate(pattern string) error {
+	_, _, err := parseRule("/", pattern)
+	return err
+}
+
+// Match tells whether pathname is excluded by the rules, without looking
+// at its parent directories.  It is meant for walkers that do not descend
+// into excluded directories.
+func (rs *Ruleset) Match(pathname string, isDir bool) bool {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	for i := len(rs.rules) - 1; i >= 0; i-- {
+		r := &rs.rules[i]
+		if r.match(pathname, isDir) {
+			return !r.negate
+		}
+	}
+	return false
+}
+
+// Excluded is like Match but also considers the parent directories of
+// pathname: as with git, nothing can be re-included below an excluded
+// directory.
+func (rs *Ruleset) Excluded(pathname string, isDir bool) bool {
+	for dir := path.Dir(pathname); dir != "/" && dir != "."; dir = path.Dir(dir) {
+		if rs.Match(dir, true) {
+			return true
+		}
+	}
+	return rs.Match(pathname, isDir)
+}
+
+func (r *rule) match(pathname string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+
+	var rel string
+	switch {
+	case r.base == "/":
+		rel = strings.TrimPrefix(pathname, "/")
+	case strings.HasPrefix(pathname, r.base+"/"):
+		rel = pathname[len(r.base)+1:]
+	default:
+		return false
+	}
+	if rel == "" {
+		return false
+	}
+
+	if !r.anchored {
+		rel = path.Base(rel)
+	}
+	return r.re.MatchString(rel)
+}
+
+func parseRule(base, pattern string) (rule, bool, error) {
+	r := rule{pattern: pattern, base: path.Clean(base)}
+
+	// trailing spaces are ignored unless escaped
+	for strings.HasSuffix(pattern, " ") && !strings.HasSuffix(pattern, "\\ ") {
+		pattern = pattern[:len(pattern)-1]
+	}
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return r, false, nil
+	}
+
+	if strings.HasPrefix(pattern, "!") {
+		r.negate = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, "\\!") || strings.HasPrefix(pattern, "\\#") {
+		pattern = pattern[1:]
+	}
+
+	if strings.HasSuffix(pattern, "/") {
+		r.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return r, false, fmt.Errorf("invalid pattern %q", r.pattern)
+	}
+
+	if strings.Contains(pattern, "/") {
+		r.anchored = true
+		// patterns used to match absolute pathnames, those spelling out
+		// the base are still accepted and made relative to it
+		if r.base != "/" && strings.HasPrefix(pattern, r.base+"/") {
+			pattern = pattern[len(r.base):]
+		}
+		pattern = strings.TrimPrefix(pattern, "/")
+	}
+
+	expr, err := translate(pattern)
+	if err != nil {
+		return r, false, fmt.Errorf("invalid pattern %q: %w", r.pattern, err)
+	}
+	r.re, err = regexp.Compile(expr)
+	if err != nil {
+		return r, false, fmt.Errorf("invalid pattern %q: %w", r.pattern, err)
+	}
+	return r, true, nil
+}
+
+// translate turns a gitignore pattern into an anchored regular expression
+// matched against slash-separated relative pathnames.
+func translate(pattern string) (string, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if strings.HasPrefix(pattern[i:], "**") &&
+				(i == 0 || pattern[i-1] == '/') &&
+				(i+2 == len(pattern) || pattern[i+2] == '/') {
+				switch {
+				case i+2 == len(pattern):
+					// trailing "/**" matches everything inside
+					sb.WriteString(".*")
+				default:
+					// leading "**/" or inner "/**/" match zero or more directories
+					sb.WriteString("(?:.*/)?")
+					i++
+				}
+				i++
+				continue
+			}
+			sb.WriteString("[^/]*")
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end == -1 {
+				return "", fmt.Errorf("unterminated character class")
+			}
+			class := pattern[i+1 : i+1+end]
+			if end == 0 {
+				// "[]...]" has a literal closing bracket first
+				next := strings.IndexByte(pattern[i+2:], ']')
+				if next == -1 {
+					return "", fmt.Errorf("unterminated character class")
+				}
+				class = pattern[i+1 : i+2+next]
+				end = next + 1
+			}
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, "\\", "\\\\") + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				sb.WriteString(regexp.QuoteMeta(string(pattern[i])))
+			}
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	sb.WriteString("$")
+	return sb.String(), nil
+}
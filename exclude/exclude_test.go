@@ -0,0 +1,88 @@
+package exclude
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRulesetMatch(t *testing.T) {
+	rs := NewRuleset()
+	require.True(t, rs.Empty())
+
+	err := rs.Add("/src", []string{
+		"# comment",
+		"",
+		"*.log",
+		"!keep.log",
+		"/build",
+		"cache/",
+		"docs/**/*.tmp",
+		"**/vendor/lib",
+		"\\!bang",
+		"/src/tmp/*.bak",
+	})
+	require.NoError(t, err)
+	require.False(t, rs.Empty())
+
+	for _, tc := range []struct {
+		pathname string
+		isDir    bool
+		excluded bool
+	}{
+		{"/src/app.log", false, true},
+		{"/src/deep/down/app.log", false, true},
+		{"/src/deep/keep.log", false, false},
+		{"/other/app.log", false, false},
+		{"/src/build", true, true},
+		{"/src/sub/build", true, false},
+		{"/src/cache", true, true},
+		{"/src/sub/cache", true, true},
+		{"/src/cache", false, false},
+		{"/src/docs/a.tmp", false, true},
+		{"/src/docs/a/b/c.tmp", false, true},
+		{"/src/x/docs/a.tmp", false, false},
+		{"/src/vendor/lib", true, true},
+		{"/src/a/b/vendor/lib", true, true},
+		{"/src/!bang", false, true},
+		{"/src/tmp/a.bak", false, true},
+		{"/src/src/tmp/a.bak", false, false},
+		{"/src", true, false},
+	} {
+		require.Equal(t, tc.excluded, rs.Match(tc.pathname, tc.isDir), tc.pathname)
+	}
+
+	// nothing is re-included below an excluded directory
+	require.True(t, rs.Excluded("/src/build/keep.log", false))
+	require.False(t, rs.Match("/src/build/keep.log", false))
+}
+
+func TestRulesetOverride(t *testing.T) {
+	rs := NewRuleset()
+	require.NoError(t, rs.Add("/src", []string{"*.dat"}))
+	require.NoError(t, rs.Add("/src/data", []string{"!*.dat", "[a-c]?.bin", "[!a-c].bin"}))
+
+	require.True(t, rs.Match("/src/x.dat", false))
+	require.False(t, rs.Match("/src/data/x.dat", false))
+	require.True(t, rs.Match("/src/data/b1.bin", false))
+	require.False(t, rs.Match("/src/data/d1.bin", false))
+	require.True(t, rs.Match("/src/data/d.bin", false))
+	require.False(t, rs.Match("/src/data/a.bin", false))
+}
+
+func TestRulesetAddFile(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, IgnoreFile)
+	require.NoError(t, os.WriteFile(filename, []byte("node_modules/\n*.o   \n"), 0644))
+
+	rs := NewRuleset()
+	require.NoError(t, rs.AddFile(dir, filename))
+	require.True(t, rs.Match(dir+"/web/node_modules", true))
+	require.True(t, rs.Match(dir+"/main.o", false))
+
+	require.Error(t, Validate("[abc"))
+	require.Error(t, Validate("/"))
+	require.NoError(t, Validate("**/*.go"))
+}
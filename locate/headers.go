@@ -0,0 +1,188 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package locate
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/header"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const HEADERS_CACHE_VERSION = "1.0.0"
+
+// cachedHeader is a serialized snapshot header along with its MAC, keyed
+// with the secret of the repository, so that a header altered on disk is
+// never trusted.
+type cachedHeader struct {
+	SnapshotID objects.MAC `msgpack:"snapshot_id"`
+	Data       []byte      `msgpack:"data"`
+	MAC        objects.MAC `msgpack:"mac"`
+}
+
+func headerMAC(repo *repository.Repository, snapshotID objects.MAC, data []byte) objects.MAC {
+	return repo.ComputeMAC(append(snapshotID[:], data...))
+}
+
+func headersCachePath(repo *repository.Repository) string {
+	cacheDir := repo.AppContext().CacheDir
+	if cacheDir == "" {
+		return ""
+	}
+	return filepath.Join(cacheDir, "headers", HEADERS_CACHE_VERSION,
+		repo.Configuration().RepositoryID.String())
+}
+
+func loadHeadersCache(repo *repository.Repository, path string) map[objects.MAC]*header.Header {
+	headers := make(map[objects.MAC]*header.Header)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return headers
+	}
+
+	var entries []cachedHeader
+	if err := msgpack.Unmarshal(data, &entries); err != nil {
+		repo.Logger().Warn("ignoring corrupted snapshot headers cache %s: %s", path, err)
+		return headers
+	}
+
+	for _, entry := range entries {
+		if headerMAC(repo, entry.SnapshotID, entry.Data) != entry.MAC {
+			repo.Logger().Warn("ignoring cached header of snapshot %x: MAC mismatch", entry.SnapshotID[:4])
+			continue
+		}
+		hdr, err := header.NewFromBytes(entry.Data)
+		if err != nil {
+			continue
+		}
+		headers[entry.SnapshotID] = hdr
+	}
+	return headers
+}
+
+func saveHeadersCache(repo *repository.Repository, path string, headers map[objects.MAC]*header.Header) error {
+	entries := make([]cachedHeader, 0, len(headers))
+	for snapshotID, hdr := range headers {
+		data, err := hdr.Serialize()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, cachedHeader{
+			SnapshotID: snapshotID,
+			Data:       data,
+			MAC:        headerMAC(repo, snapshotID, data),
+		})
+	}
+
+	data, err := msgpack.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	// other processes may update the cache at the same time, last one wins
+	fp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := fp.Write(data); err != nil {
+		fp.Close()
+		os.Remove(fp.Name())
+		return err
+	}
+	if err := fp.Close(); err != nil {
+		os.Remove(fp.Name())
+		return err
+	}
+	return os.Rename(fp.Name(), path)
+}
+
+// Headers returns the headers of the snapshots of repo.  They are kept in
+// the cache directory, per repository, so that only the snapshots created
+// since the last call are loaded from the repository, with up to
+// maxConcurrency of them at once.  Snapshots that cannot be loaded are
+// skipped.
+func Headers(repo *repository.Repository, maxConcurrency int) ([]*header.Header, error) {
+	path := headersCachePath(repo)
+
+	var cached map[objects.MAC]*header.Header
+	if path != "" {
+		cached = loadHeadersCache(repo, path)
+	} else {
+		cached = make(map[objects.MAC]*header.Header)
+	}
+
+	current := make(map[objects.MAC]*header.Header)
+	var missing []objects.MAC
+	for snapshotID := range repo.ListSnapshots() {
+		if hdr, ok := cached[snapshotID]; ok {
+			current[snapshotID] = hdr
+		} else {
+			missing = append(missing, snapshotID)
+		}
+	}
+	dirty := len(current) != len(cached)
+
+	if len(missing) != 0 {
+		mu := sync.Mutex{}
+		wg := sync.WaitGroup{}
+		sem := make(chan struct{}, max(maxConcurrency, 1))
+		for _, snapshotID := range missing {
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(snapshotID objects.MAC) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+
+				snap, err := snapshot.Load(repo, snapshotID)
+				if err != nil {
+					return
+				}
+				defer snap.Close()
+
+				mu.Lock()
+				current[snapshotID] = snap.Header
+				dirty = true
+				mu.Unlock()
+			}(snapshotID)
+		}
+		wg.Wait()
+	}
+
+	if dirty && path != "" {
+		if err := saveHeadersCache(repo, path, current); err != nil {
+			repo.Logger().Warn("could not update snapshot headers cache: %s", err)
+		}
+	}
+
+	headers := make([]*header.Header, 0, len(current))
+	for _, hdr := range current {
+		headers = append(headers, hdr)
+	}
+	return headers, nil
+}
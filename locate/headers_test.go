@@ -0,0 +1,84 @@
+package locate
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/snapshot/header"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func headerIDs(headers []*header.Header) []objects.MAC {
+	ids := make([]objects.MAC, 0, len(headers))
+	for _, hdr := range headers {
+		ids = append(ids, hdr.Identifier)
+	}
+	return ids
+}
+
+func TestHeaders(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, _ := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	repo.AppContext().CacheDir = t.TempDir()
+
+	snap1 := generateSnapshotWithMetadata(t, repo, ptesting.WithName("one"))
+	defer snap1.Close()
+	snap2 := generateSnapshotWithMetadata(t, repo, ptesting.WithName("two"))
+	defer snap2.Close()
+
+	headers, err := Headers(repo, 2)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []objects.MAC{snap1.Header.Identifier, snap2.Header.Identifier}, headerIDs(headers))
+
+	path := headersCachePath(repo)
+	cached := loadHeadersCache(repo, path)
+	require.Len(t, cached, 2)
+	require.Equal(t, "two", cached[snap2.Header.Identifier].Name)
+
+	// a header altered on disk is not trusted
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var entries []cachedHeader
+	require.NoError(t, msgpack.Unmarshal(data, &entries))
+	for i := range entries {
+		if entries[i].SnapshotID == snap2.Header.Identifier {
+			hdr, err := header.NewFromBytes(entries[i].Data)
+			require.NoError(t, err)
+			hdr.Name = "forged"
+			entries[i].Data, err = hdr.Serialize()
+			require.NoError(t, err)
+		}
+	}
+	data, err = msgpack.Marshal(entries)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0600))
+	require.Len(t, loadHeadersCache(repo, path), 1)
+
+	headers, err = Headers(repo, 1)
+	require.NoError(t, err)
+	for _, hdr := range headers {
+		require.NotEqual(t, "forged", hdr.Name)
+	}
+	require.Len(t, loadHeadersCache(repo, path), 2)
+
+	// deleted snapshots are dropped from the cache
+	require.NoError(t, repo.DeleteSnapshot(snap1.Header.Identifier))
+	require.NoError(t, repo.RebuildState())
+	headers, err = Headers(repo, 1)
+	require.NoError(t, err)
+	require.Equal(t, []objects.MAC{snap2.Header.Identifier}, headerIDs(headers))
+	require.Len(t, loadHeadersCache(repo, path), 1)
+
+	// a corrupted cache is rebuilt
+	require.NoError(t, os.WriteFile(path, []byte("garbage"), 0600))
+	headers, err = Headers(repo, 1)
+	require.NoError(t, err)
+	require.Equal(t, []objects.MAC{snap2.Header.Identifier}, headerIDs(headers))
+	require.Len(t, loadHeadersCache(repo, path), 1)
+}
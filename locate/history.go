@@ -0,0 +1,144 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package locate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot/header"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+)
+
+// Version is a distinct version of a pathname, held by consecutive
+// snapshots.
+type Version struct {
+	Pathname string
+	// Entry is the one found in the first snapshot
+	Entry     *vfs.Entry
+	First     *header.Header
+	Last      *header.Header
+	Snapshots int
+}
+
+// Digest returns the MAC of the content of the version in the repository,
+// which is the same for identical contents, or a zero MAC for entries
+// other than regular files.
+func (v *Version) Digest() objects.MAC {
+	if v.Entry.ResolvedObject == nil {
+		return objects.MAC{}
+	}
+	return v.Entry.ResolvedObject.ContentMAC
+}
+
+// sameVersion tells whether two entries of a pathname are the same version:
+// regular files are compared by object, so that only their content counts,
+// other entries as a whole.
+func sameVersion(a, b *vfs.Entry) bool {
+	if a.HasObject() || b.HasObject() {
+		return a.Object == b.Object
+	}
+	return a.MAC == b.MAC
+}
+
+// History returns the distinct versions of pathname in the snapshots
+// matching opts, oldest first whatever their sort order.
+func History(repo *repository.Repository, opts *LocateOptions, pathname string, source string) ([]*Version, error) {
+	historyOpts := *NewDefaultLocateOptions()
+	if opts != nil {
+		historyOpts = *opts
+	}
+	historyOpts.SortOrder = LocateSortOrderAscending
+	historyOpts.Latest = false
+
+	headers, err := Headers(repo, historyOpts.MaxConcurrency)
+	if err != nil {
+		return nil, err
+	}
+	return HistoryOf(repo, LocateHeaders(headers, &historyOpts), pathname, source)
+}
+
+// HistoryOf returns the distinct versions of pathname in the given
+// snapshots, which must be sorted from the oldest.  pathname is resolved in
+// each snapshot as with ResolvePathname.  Consecutive snapshots holding the
+// same version are collapsed, while a snapshot without the pathname ends
+// the version, snapshots without the given source are skipped.
+func HistoryOf(repo *repository.Repository, headers []*header.Header, pathname string, source string) ([]*Version, error) {
+	return historyOf(repo, headers, pathname, source, nil)
+}
+
+// HistoryOfLocations is like HistoryOf for an absolute pathname, but only
+// looks it up in the snapshots of locations, as found by Index.Lookup or
+// WalkLookup, the other snapshots ending the version.
+func HistoryOfLocations(repo *repository.Repository, headers []*header.Header, pathname string, locations []Location) ([]*Version, error) {
+	located := make(map[objects.MAC]struct{}, len(locations))
+	for _, loc := range locations {
+		located[loc.SnapshotID] = struct{}{}
+	}
+	return historyOf(repo, headers, pathname, "", located)
+}
+
+func historyOf(repo *repository.Repository, headers []*header.Header, pathname string, source string,
+	located map[objects.MAC]struct{}) ([]*Version, error) {
+	var versions []*Version
+	var current *Version
+	for _, hdr := range headers {
+		if err := repo.AppContext().Err(); err != nil {
+			return nil, err
+		}
+
+		snapPathname, err := ResolvePathname(hdr, pathname, source)
+		if err != nil {
+			continue
+		}
+
+		if located != nil {
+			if _, ok := located[hdr.Identifier]; !ok {
+				current = nil
+				continue
+			}
+		}
+
+		entry, err := lookupEntry(repo, hdr.Identifier, snapPathname)
+		if errors.Is(err, os.ErrNotExist) {
+			current = nil
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("snapshot %x: %w", hdr.GetIndexShortID(), err)
+		}
+
+		if current != nil && current.Pathname == snapPathname && sameVersion(current.Entry, entry) {
+			current.Last = hdr
+			current.Snapshots++
+			continue
+		}
+
+		current = &Version{
+			Pathname:  snapPathname,
+			Entry:     entry,
+			First:     hdr,
+			Last:      hdr,
+			Snapshots: 1,
+		}
+		versions = append(versions, current)
+	}
+	return versions, nil
+}
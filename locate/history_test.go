@@ -0,0 +1,118 @@
+package locate
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/snapshot"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, _ := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+
+	generate := func(content string) *snapshot.Snapshot {
+		files := []ptesting.MockFile{ptesting.NewMockDir("subdir")}
+		if content != "" {
+			files = append(files, ptesting.NewMockFile("subdir/dummy.txt", 0644, content))
+		}
+		snap := ptesting.GenerateSnapshot(t, repo, files)
+		t.Cleanup(func() { snap.Close() })
+		return snap
+	}
+
+	v1a := generate("hello dummy")
+	v1b := generate("hello dummy")
+	v2a := generate("hello dummy, again")
+	generate("")
+	v2b := generate("hello dummy, again")
+
+	versions, err := History(repo, nil, "/subdir/dummy.txt", "")
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+
+	expected := []struct {
+		first, last *snapshot.Snapshot
+		snapshots   int
+		size        int64
+	}{
+		{v1a, v1b, 2, int64(len("hello dummy"))},
+		{v2a, v2a, 1, int64(len("hello dummy, again"))},
+		{v2b, v2b, 1, int64(len("hello dummy, again"))},
+	}
+	for i, exp := range expected {
+		require.Equal(t, "/subdir/dummy.txt", versions[i].Pathname)
+		require.Equal(t, exp.first.Header.Identifier, versions[i].First.Identifier)
+		require.Equal(t, exp.last.Header.Identifier, versions[i].Last.Identifier)
+		require.Equal(t, exp.snapshots, versions[i].Snapshots)
+		require.Equal(t, exp.size, versions[i].Entry.Size())
+		require.NotEqual(t, objects.MAC{}, versions[i].Digest())
+	}
+	require.NotEqual(t, versions[0].Digest(), versions[1].Digest())
+	require.Equal(t, versions[1].Digest(), versions[2].Digest())
+
+	// the order of the options does not matter
+	opts := NewDefaultLocateOptions()
+	opts.SortOrder = LocateSortOrderDescending
+	opts.Before = v2a.Header.Timestamp
+	versions, err = History(repo, opts, "subdir/dummy.txt", "")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	require.Equal(t, v1a.Header.Identifier, versions[0].First.Identifier)
+	require.Equal(t, v2a.Header.Identifier, versions[1].First.Identifier)
+
+	versions, err = History(repo, nil, "/nonexistent", "")
+	require.NoError(t, err)
+	require.Empty(t, versions)
+}
+
+func TestHistoryOfLocations(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, _ := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+
+	generate := func(content string) *snapshot.Snapshot {
+		files := []ptesting.MockFile{ptesting.NewMockDir("subdir")}
+		if content != "" {
+			files = append(files, ptesting.NewMockFile("subdir/dummy.txt", 0644, content))
+		}
+		snap := ptesting.GenerateSnapshot(t, repo, files)
+		t.Cleanup(func() { snap.Close() })
+		return snap
+	}
+
+	v1 := generate("hello dummy")
+	generate("")
+	v2 := generate("hello dummy")
+
+	headers, err := Headers(repo, 1)
+	require.NoError(t, err)
+	opts := NewDefaultLocateOptions()
+	opts.SortOrder = LocateSortOrderAscending
+	headers = LocateHeaders(headers, opts)
+
+	snapshotIDs := make([]objects.MAC, 0, len(headers))
+	for _, hdr := range headers {
+		snapshotIDs = append(snapshotIDs, hdr.Identifier)
+	}
+	locations, err := WalkLookup(repo, "/subdir/dummy.txt", snapshotIDs)
+	require.NoError(t, err)
+	require.Len(t, locations, 2)
+
+	versions, err := HistoryOfLocations(repo, headers, "/subdir/dummy.txt", locations)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	require.Equal(t, v1.Header.Identifier, versions[0].First.Identifier)
+	require.Equal(t, v2.Header.Identifier, versions[1].First.Identifier)
+
+	// snapshots missing from the locations are not looked into
+	versions, err = HistoryOfLocations(repo, headers, "/subdir/dummy.txt", locations[:0])
+	require.NoError(t, err)
+	require.Empty(t, versions)
+}
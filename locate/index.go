@@ -0,0 +1,722 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package locate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/kloset/logging"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/cockroachdb/pebble/v2"
+	"github.com/cockroachdb/pebble/v2/vfs"
+)
+
+const INDEX_VERSION = "1.0.0"
+
+// The index is a pebble database with three key spaces:
+//
+//	's' SNAPSHOT                              snapshot is indexed
+//	'p' PATHNAME 0x00 SNAPSHOT                -> size, mtime, mode
+//	'b' lower(BASENAME) 0x00 PATHNAME 0x00 SNAPSHOT -> size, mtime, mode
+//
+// so that queries on pathnames or base names with a literal prefix only
+// scan the matching range of keys.
+const (
+	indexSnapshotPrefix = 's'
+	indexPathnamePrefix = 'p'
+	indexBasenamePrefix = 'b'
+)
+
+// entries written to the index before committing a batch
+const indexBatchSize = 4096
+
+// Location is a pathname found in a snapshot by the index.
+type Location struct {
+	SnapshotID objects.MAC
+	Pathname   string
+	Size       int64
+	ModTime    time.Time
+	Mode       fs.FileMode
+}
+
+// Index maps the pathnames of the snapshots of a repository to the
+// snapshots holding them.  It lives in the cache directory and is updated
+// incrementally, so that only new snapshots are walked.
+type Index struct {
+	path string
+	db   *pebble.DB
+	lock *pebble.Lock
+	refs int
+	mu   sync.Mutex
+}
+
+// ErrIndexBusy is returned by OpenIndex when another process, e.g. plakar
+// ui, holds the index.  Callers can still search the snapshots with
+// WalkSearch and WalkLookup.
+var ErrIndexBusy = errors.New("index is in use by another process")
+
+// pebble only lets a database be opened once, indexes are shared within
+// the process.
+var (
+	indexesMu sync.Mutex
+	indexes   = make(map[string]*Index)
+)
+
+type indexLogger struct {
+	logger *logging.Logger
+}
+
+func (l indexLogger) Infof(format string, args ...interface{}) {
+	l.logger.Trace("locate", format, args...)
+}
+
+func (l indexLogger) Errorf(format string, args ...interface{}) {
+	l.logger.Warn(format, args...)
+}
+
+func (l indexLogger) Fatalf(format string, args ...interface{}) {
+	l.logger.Error(format, args...)
+	panic(fmt.Sprintf(format, args...))
+}
+
+// OpenIndex opens the pathname index of repo, creating it if needed.  The
+// index is kept in memory if there is no cache directory.  It must be
+// released with Close.  The index only depends on the repository ID, so
+// the repository to index is passed to Update.
+func OpenIndex(repo *repository.Repository) (*Index, error) {
+	indexPath := ""
+	opts := &pebble.Options{Logger: indexLogger{repo.Logger()}}
+	if cacheDir := repo.AppContext().CacheDir; cacheDir != "" {
+		indexPath = filepath.Join(cacheDir, "locate", INDEX_VERSION,
+			repo.Configuration().RepositoryID.String())
+	} else {
+		opts.FS = vfs.NewMem()
+	}
+
+	indexesMu.Lock()
+	defer indexesMu.Unlock()
+
+	if indexPath != "" {
+		if idx, ok := indexes[indexPath]; ok {
+			idx.refs++
+			return idx, nil
+		}
+		if err := os.MkdirAll(indexPath, 0700); err != nil {
+			return nil, err
+		}
+
+		lock, err := pebble.LockDirectory(indexPath, vfs.Default)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrIndexBusy, err)
+		}
+		opts.Lock = lock
+	}
+
+	db, err := pebble.Open(indexPath, opts)
+	if err != nil {
+		if opts.Lock != nil {
+			opts.Lock.Close()
+		}
+		return nil, err
+	}
+
+	idx := &Index{path: indexPath, db: db, lock: opts.Lock, refs: 1}
+	if indexPath != "" {
+		indexes[indexPath] = idx
+	}
+	return idx, nil
+}
+
+// Close releases the index.
+func (idx *Index) Close() error {
+	indexesMu.Lock()
+	defer indexesMu.Unlock()
+
+	idx.refs--
+	if idx.refs > 0 {
+		return nil
+	}
+	if idx.path != "" {
+		delete(indexes, idx.path)
+	}
+	err := idx.db.Close()
+	if idx.lock != nil {
+		if lerr := idx.lock.Close(); err == nil {
+			err = lerr
+		}
+	}
+	return err
+}
+
+func snapshotKey(snapshotID objects.MAC) []byte {
+	return append([]byte{indexSnapshotPrefix}, snapshotID[:]...)
+}
+
+func pathnameKey(pathname string, snapshotID objects.MAC) []byte {
+	key := make([]byte, 0, 2+len(pathname)+len(snapshotID))
+	key = append(key, indexPathnamePrefix)
+	key = append(key, pathname...)
+	key = append(key, 0)
+	return append(key, snapshotID[:]...)
+}
+
+func basenameKey(pathname string, snapshotID objects.MAC) []byte {
+	basename := strings.ToLower(path.Base(pathname))
+	key := make([]byte, 0, 3+len(basename)+len(pathname)+len(snapshotID))
+	key = append(key, indexBasenamePrefix)
+	key = append(key, basename...)
+	key = append(key, 0)
+	key = append(key, pathname...)
+	key = append(key, 0)
+	return append(key, snapshotID[:]...)
+}
+
+// parseKey returns the pathname and snapshot of a pathname or base name key.
+func parseKey(key []byte) (string, objects.MAC, bool) {
+	var snapshotID objects.MAC
+	if len(key) < 2+len(snapshotID) || key[len(key)-len(snapshotID)-1] != 0 {
+		return "", snapshotID, false
+	}
+	copy(snapshotID[:], key[len(key)-len(snapshotID):])
+
+	rest := key[1 : len(key)-len(snapshotID)-1]
+	if key[0] == indexBasenamePrefix {
+		idx := bytes.IndexByte(rest, 0)
+		if idx == -1 {
+			return "", snapshotID, false
+		}
+		rest = rest[idx+1:]
+	}
+	return string(rest), snapshotID, true
+}
+
+func encodeValue(size int64, modTime time.Time, mode fs.FileMode) []byte {
+	value := make([]byte, 0, 4*binary.MaxVarintLen64)
+	value = binary.AppendVarint(value, size)
+	value = binary.AppendVarint(value, modTime.Unix())
+	value = binary.AppendUvarint(value, uint64(modTime.Nanosecond()))
+	return binary.AppendUvarint(value, uint64(mode))
+}
+
+func decodeValue(value []byte, loc *Location) error {
+	size, n := binary.Varint(value)
+	if n <= 0 {
+		return fmt.Errorf("invalid index value")
+	}
+	value = value[n:]
+	sec, n := binary.Varint(value)
+	if n <= 0 {
+		return fmt.Errorf("invalid index value")
+	}
+	value = value[n:]
+	nsec, n := binary.Uvarint(value)
+	if n <= 0 {
+		return fmt.Errorf("invalid index value")
+	}
+	value = value[n:]
+	mode, n := binary.Uvarint(value)
+	if n <= 0 {
+		return fmt.Errorf("invalid index value")
+	}
+	loc.Size = size
+	loc.ModTime = time.Unix(sec, int64(nsec))
+	loc.Mode = fs.FileMode(mode)
+	return nil
+}
+
+// upperBound returns the smallest key greater than all the keys starting
+// with prefix, nil if there is none.
+func upperBound(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+func (idx *Index) scan(prefix []byte, fn func(key, value []byte) error) error {
+	iter, err := idx.db.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: upperBound(prefix),
+	})
+	if err != nil {
+		return err
+	}
+	for iter.First(); iter.Valid(); iter.Next() {
+		if err := fn(iter.Key(), iter.Value()); err != nil {
+			iter.Close()
+			return err
+		}
+	}
+	return iter.Close()
+}
+
+func (idx *Index) indexed() (map[objects.MAC]struct{}, error) {
+	snapshots := make(map[objects.MAC]struct{})
+	err := idx.scan([]byte{indexSnapshotPrefix}, func(key, value []byte) error {
+		var snapshotID objects.MAC
+		if len(key) == 1+len(snapshotID) {
+			copy(snapshotID[:], key[1:])
+			snapshots[snapshotID] = struct{}{}
+		}
+		return nil
+	})
+	return snapshots, err
+}
+
+// Update indexes the given snapshots of repo if they are not already, and
+// drops the snapshots that were deleted from it.
+func (idx *Index) Update(repo *repository.Repository, snapshotIDs []objects.MAC) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	indexed, err := idx.indexed()
+	if err != nil {
+		return err
+	}
+
+	stale := make(map[objects.MAC]struct{})
+	for snapshotID := range indexed {
+		stale[snapshotID] = struct{}{}
+	}
+	for snapshotID := range repo.ListSnapshots() {
+		delete(stale, snapshotID)
+	}
+	if len(stale) != 0 {
+		if err := idx.purge(stale); err != nil {
+			return err
+		}
+	}
+
+	for _, snapshotID := range snapshotIDs {
+		if _, ok := indexed[snapshotID]; ok {
+			continue
+		}
+		if err := idx.add(repo, snapshotID); err != nil {
+			return err
+		}
+		indexed[snapshotID] = struct{}{}
+	}
+	return nil
+}
+
+func (idx *Index) add(repo *repository.Repository, snapshotID objects.MAC) error {
+	snap, err := snapshot.Load(repo, snapshotID)
+	if err != nil {
+		return fmt.Errorf("could not load snapshot %x: %w", snapshotID[:4], err)
+	}
+	defer snap.Close()
+
+	pvfs, err := snap.Filesystem()
+	if err != nil {
+		return fmt.Errorf("could not get filesystem of snapshot %x: %w", snapshotID[:4], err)
+	}
+
+	// a snapshot interrupted while being indexed is indexed again from
+	// scratch, its entries are simply overwritten
+	batch := idx.db.NewBatch()
+	defer func() { batch.Close() }()
+
+	for entry, err := range pvfs.Files("/") {
+		if err != nil {
+			return fmt.Errorf("could not walk snapshot %x: %w", snapshotID[:4], err)
+		}
+		if err := repo.AppContext().Err(); err != nil {
+			return err
+		}
+
+		pathname := entry.Path()
+		info := entry.Stat()
+		value := encodeValue(info.Size(), info.ModTime(), info.Mode())
+		if err := batch.Set(pathnameKey(pathname, snapshotID), value, nil); err != nil {
+			return err
+		}
+		if err := batch.Set(basenameKey(pathname, snapshotID), value, nil); err != nil {
+			return err
+		}
+
+		if batch.Count() >= 2*indexBatchSize {
+			if err := batch.Commit(pebble.NoSync); err != nil {
+				return err
+			}
+			batch.Close()
+			batch = idx.db.NewBatch()
+		}
+	}
+
+	if err := batch.Set(snapshotKey(snapshotID), nil, nil); err != nil {
+		return err
+	}
+	return batch.Commit(pebble.Sync)
+}
+
+func (idx *Index) purge(stale map[objects.MAC]struct{}) error {
+	batch := idx.db.NewBatch()
+	defer batch.Close()
+
+	err := idx.scan([]byte{indexPathnamePrefix}, func(key, value []byte) error {
+		pathname, snapshotID, ok := parseKey(key)
+		if !ok {
+			return nil
+		}
+		if _, ok := stale[snapshotID]; !ok {
+			return nil
+		}
+		if err := batch.Delete(key, nil); err != nil {
+			return err
+		}
+		return batch.Delete(basenameKey(pathname, snapshotID), nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	for snapshotID := range stale {
+		if err := batch.Delete(snapshotKey(snapshotID), nil); err != nil {
+			return err
+		}
+	}
+	return batch.Commit(pebble.Sync)
+}
+
+// PathnameQuery selects pathnames from the index.  Patterns are shell
+// patterns, or regular expressions if Regex is set, matched against the
+// base name of the pathnames, or the full pathname if FullPath is set.
+type PathnameQuery struct {
+	Patterns   []string
+	Regex      bool
+	IgnoreCase bool
+	FullPath   bool
+
+	// MinSize and MaxSize bound the size of the entries, MaxSize is
+	// ignored if negative
+	MinSize int64
+	MaxSize int64
+
+	// Newer and Older bound the modification time of the entries
+	Newer time.Time
+	Older time.Time
+}
+
+func NewDefaultPathnameQuery() *PathnameQuery {
+	return &PathnameQuery{
+		MaxSize: -1,
+	}
+}
+
+type pathnameMatcher struct {
+	prefix string
+	match  func(string) bool
+}
+
+func (q *PathnameQuery) matcher(pattern string) (*pathnameMatcher, error) {
+	if q.Regex {
+		expr := pattern
+		if q.IgnoreCase {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		return &pathnameMatcher{match: re.MatchString}, nil
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+
+	prefix := pattern
+	if idx := strings.IndexAny(pattern, `*?[\`); idx != -1 {
+		prefix = pattern[:idx]
+	}
+
+	if q.IgnoreCase {
+		pattern = strings.ToLower(pattern)
+		return &pathnameMatcher{
+			prefix: prefix,
+			match: func(name string) bool {
+				name = strings.ToLower(name)
+				matched, _ := path.Match(pattern, name)
+				return matched || name == pattern
+			},
+		}, nil
+	}
+	return &pathnameMatcher{
+		prefix: prefix,
+		match: func(name string) bool {
+			matched, _ := path.Match(pattern, name)
+			return matched || name == pattern
+		},
+	}, nil
+}
+
+func (q *PathnameQuery) filter(loc *Location) bool {
+	if loc.Size < q.MinSize {
+		return false
+	}
+	if q.MaxSize >= 0 && loc.Size > q.MaxSize {
+		return false
+	}
+	if !q.Newer.IsZero() && loc.ModTime.Before(q.Newer) {
+		return false
+	}
+	if !q.Older.IsZero() && loc.ModTime.After(q.Older) {
+		return false
+	}
+	return true
+}
+
+// keyPrefix returns the range of keys a pattern with the given literal
+// prefix can match.
+func (q *PathnameQuery) keyPrefix(literal string) []byte {
+	if q.FullPath {
+		if q.IgnoreCase {
+			return []byte{indexPathnamePrefix}
+		}
+		return append([]byte{indexPathnamePrefix}, literal...)
+	}
+	return append([]byte{indexBasenamePrefix}, strings.ToLower(literal)...)
+}
+
+// Search returns the locations matching the query within the given
+// snapshots, ordered as the snapshots then by pathname.  The snapshots
+// must have been indexed with Update.
+func (idx *Index) Search(q *PathnameQuery, snapshotIDs []objects.MAC) ([]Location, error) {
+	scans, err := q.scans()
+	if err != nil {
+		return nil, err
+	}
+	return idx.search(snapshotIDs, scans)
+}
+
+func (q *PathnameQuery) scans() ([]indexScan, error) {
+	matchers := make([]*pathnameMatcher, 0, len(q.Patterns))
+	fullScan := false
+	for _, pattern := range q.Patterns {
+		m, err := q.matcher(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if m.prefix == "" {
+			fullScan = true
+		}
+		matchers = append(matchers, m)
+	}
+
+	// a pattern without a literal prefix needs every key, and then all
+	// patterns are tried at once
+	match := func(matchers []*pathnameMatcher) func(*Location) bool {
+		return func(loc *Location) bool {
+			name := loc.Pathname
+			if !q.FullPath {
+				name = path.Base(name)
+			}
+			for _, m := range matchers {
+				if m.match(name) {
+					return q.filter(loc)
+				}
+			}
+			return false
+		}
+	}
+
+	var scans []indexScan
+	if fullScan {
+		scans = append(scans, indexScan{q.keyPrefix(""), match(matchers)})
+	} else {
+		for _, m := range matchers {
+			scans = append(scans, indexScan{q.keyPrefix(m.prefix), match([]*pathnameMatcher{m})})
+		}
+	}
+	return scans, nil
+}
+
+// Lookup returns the locations of pathname within the given snapshots,
+// ordered as the snapshots.  The snapshots must have been indexed with
+// Update.
+func (idx *Index) Lookup(pathname string, snapshotIDs []objects.MAC) ([]Location, error) {
+	prefix := append([]byte{indexPathnamePrefix}, pathname...)
+	prefix = append(prefix, 0)
+	return idx.search(snapshotIDs, []indexScan{{prefix, func(loc *Location) bool {
+		return loc.Pathname == pathname
+	}}})
+}
+
+// indexScan is a range of keys to scan and the filter of its locations.
+type indexScan struct {
+	prefix []byte
+	match  func(*Location) bool
+}
+
+func (idx *Index) search(snapshotIDs []objects.MAC, scans []indexScan) ([]Location, error) {
+	order := make(map[objects.MAC]int, len(snapshotIDs))
+	for i, snapshotID := range snapshotIDs {
+		order[snapshotID] = i
+	}
+
+	// overlapping scans may find the same location more than once
+	var seen map[string]struct{}
+	if len(scans) > 1 {
+		seen = make(map[string]struct{})
+	}
+
+	var locations []Location
+	for _, s := range scans {
+		err := idx.scan(s.prefix, func(key, value []byte) error {
+			pathname, snapshotID, ok := parseKey(key)
+			if !ok {
+				return nil
+			}
+			if _, ok := order[snapshotID]; !ok {
+				return nil
+			}
+
+			loc := Location{SnapshotID: snapshotID, Pathname: pathname}
+			if err := decodeValue(value, &loc); err != nil {
+				return err
+			}
+			if !s.match(&loc) {
+				return nil
+			}
+
+			if seen != nil {
+				id := string(pathnameKey(pathname, snapshotID))
+				if _, ok := seen[id]; ok {
+					return nil
+				}
+				seen[id] = struct{}{}
+			}
+			locations = append(locations, loc)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	slices.SortFunc(locations, func(a, b Location) int {
+		if n := order[a.SnapshotID] - order[b.SnapshotID]; n != 0 {
+			return n
+		}
+		return strings.Compare(a.Pathname, b.Pathname)
+	})
+	return locations, nil
+}
+
+// WalkSearch is Search for when the index is not available: it walks the
+// given snapshots of repo instead, which is much slower.
+func WalkSearch(repo *repository.Repository, q *PathnameQuery, snapshotIDs []objects.MAC) ([]Location, error) {
+	scans, err := q.scans()
+	if err != nil {
+		return nil, err
+	}
+
+	var locations []Location
+	for _, snapshotID := range snapshotIDs {
+		found, err := walkSnapshot(repo, snapshotID, scans)
+		if err != nil {
+			return nil, err
+		}
+		locations = append(locations, found...)
+	}
+	return locations, nil
+}
+
+func walkSnapshot(repo *repository.Repository, snapshotID objects.MAC, scans []indexScan) ([]Location, error) {
+	snap, err := snapshot.Load(repo, snapshotID)
+	if err != nil {
+		return nil, fmt.Errorf("could not load snapshot %x: %w", snapshotID[:4], err)
+	}
+	defer snap.Close()
+
+	pvfs, err := snap.Filesystem()
+	if err != nil {
+		return nil, fmt.Errorf("could not get filesystem of snapshot %x: %w", snapshotID[:4], err)
+	}
+
+	var locations []Location
+	for entry, err := range pvfs.Files("/") {
+		if err != nil {
+			return nil, fmt.Errorf("could not walk snapshot %x: %w", snapshotID[:4], err)
+		}
+		if err := repo.AppContext().Err(); err != nil {
+			return nil, err
+		}
+
+		info := entry.Stat()
+		loc := Location{
+			SnapshotID: snapshotID,
+			Pathname:   entry.Path(),
+			Size:       info.Size(),
+			ModTime:    info.ModTime(),
+			Mode:       info.Mode(),
+		}
+		for _, s := range scans {
+			if s.match(&loc) {
+				locations = append(locations, loc)
+				break
+			}
+		}
+	}
+
+	slices.SortFunc(locations, func(a, b Location) int {
+		return strings.Compare(a.Pathname, b.Pathname)
+	})
+	return locations, nil
+}
+
+// WalkLookup is Lookup for when the index is not available: it looks the
+// pathname up in each of the given snapshots of repo instead.
+func WalkLookup(repo *repository.Repository, pathname string, snapshotIDs []objects.MAC) ([]Location, error) {
+	var locations []Location
+	for _, snapshotID := range snapshotIDs {
+		entry, err := lookupEntry(repo, snapshotID, pathname)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("snapshot %x: %w", snapshotID[:4], err)
+		}
+
+		info := entry.Stat()
+		locations = append(locations, Location{
+			SnapshotID: snapshotID,
+			Pathname:   pathname,
+			Size:       info.Size(),
+			ModTime:    info.ModTime(),
+			Mode:       info.Mode(),
+		})
+	}
+	return locations, nil
+}
@@ -0,0 +1,183 @@
+package locate
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/cockroachdb/pebble/v2"
+	"github.com/cockroachdb/pebble/v2/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func locationPaths(locations []Location) []string {
+	paths := make([]string, 0, len(locations))
+	for _, loc := range locations {
+		paths = append(paths, loc.Pathname)
+	}
+	return paths
+}
+
+func TestIndex(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, _ := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	repo.AppContext().CacheDir = t.TempDir()
+
+	snap1 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy"),
+		ptesting.NewMockFile("subdir/README.md", 0644, "readme"),
+	})
+	defer snap1.Close()
+	snap2 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("other"),
+		ptesting.NewMockFile("other/dummy.txt", 0644, "a much longer dummy file"),
+		ptesting.NewMockFile("other/notes.txt", 0644, "notes"),
+	})
+	defer snap2.Close()
+	snapshotIDs := []objects.MAC{snap1.Header.Identifier, snap2.Header.Identifier}
+
+	index, err := OpenIndex(repo)
+	require.NoError(t, err)
+	require.NoError(t, index.Update(repo, snapshotIDs))
+
+	search := func(q *PathnameQuery, patterns ...string) []Location {
+		q.Patterns = patterns
+		locations, err := index.Search(q, snapshotIDs)
+		require.NoError(t, err)
+		return locations
+	}
+
+	locations := search(NewDefaultPathnameQuery(), "dummy.txt")
+	require.Len(t, locations, 2)
+	require.Equal(t, snap1.Header.Identifier, locations[0].SnapshotID)
+	require.Equal(t, "/subdir/dummy.txt", locations[0].Pathname)
+	require.Equal(t, int64(len("hello dummy")), locations[0].Size)
+	require.Equal(t, snap2.Header.Identifier, locations[1].SnapshotID)
+	require.Equal(t, "/other/dummy.txt", locations[1].Pathname)
+
+	require.Equal(t, []string{"/subdir/dummy.txt", "/other/dummy.txt", "/other/notes.txt"},
+		locationPaths(search(NewDefaultPathnameQuery(), "*.txt")))
+	require.Equal(t, []string{"/subdir/dummy.txt", "/other/dummy.txt", "/other/notes.txt"},
+		locationPaths(search(NewDefaultPathnameQuery(), "d*", "*.txt")))
+	require.Empty(t, search(NewDefaultPathnameQuery(), "readme.md"))
+
+	q := NewDefaultPathnameQuery()
+	q.IgnoreCase = true
+	require.Equal(t, []string{"/subdir/README.md"}, locationPaths(search(q, "readme.*")))
+
+	q = NewDefaultPathnameQuery()
+	q.Regex = true
+	require.Equal(t, []string{"/other/notes.txt"}, locationPaths(search(q, "^no.*s\\.txt$")))
+
+	q = NewDefaultPathnameQuery()
+	q.FullPath = true
+	require.Empty(t, search(q, "dummy.txt"))
+	locations = search(q, "/other/*.txt")
+	require.Equal(t, []string{"/other/dummy.txt", "/other/notes.txt"}, locationPaths(locations))
+
+	q = NewDefaultPathnameQuery()
+	q.MinSize = 10
+	require.Equal(t, []string{"/subdir/dummy.txt", "/other/dummy.txt"}, locationPaths(search(q, "*.txt")))
+	q.MaxSize = 12
+	require.Equal(t, []string{"/subdir/dummy.txt"}, locationPaths(search(q, "*.txt")))
+
+	q = NewDefaultPathnameQuery()
+	q.Newer = time.Now().Add(-time.Hour)
+	require.Empty(t, search(q, "*.txt"))
+	q = NewDefaultPathnameQuery()
+	q.Older = time.Now()
+	require.Len(t, search(q, "*.txt"), 3)
+
+	q = NewDefaultPathnameQuery()
+	q.Patterns = []string{"[a-"}
+	_, err = index.Search(q, snapshotIDs)
+	require.Error(t, err)
+
+	// only the given snapshots are searched
+	locations, err = index.Search(&PathnameQuery{Patterns: []string{"dummy.txt"}, MaxSize: -1}, snapshotIDs[1:])
+	require.NoError(t, err)
+	require.Len(t, locations, 1)
+
+	// exact pathnames
+	pathname := locations[0].Pathname
+	locations, err = index.Lookup(pathname, snapshotIDs)
+	require.NoError(t, err)
+	require.Len(t, locations, 1)
+	require.Equal(t, snap2.Header.Identifier, locations[0].SnapshotID)
+
+	// indexes are shared within the process and persist across opens
+	shared, err := OpenIndex(repo)
+	require.NoError(t, err)
+	require.Same(t, index, shared)
+	require.NoError(t, shared.Close())
+	require.NoError(t, index.Close())
+
+	index, err = OpenIndex(repo)
+	require.NoError(t, err)
+	defer index.Close()
+	indexed, err := index.indexed()
+	require.NoError(t, err)
+	require.Len(t, indexed, 2)
+
+	// deleted snapshots are dropped from the index
+	require.NoError(t, repo.DeleteSnapshot(snap2.Header.Identifier))
+	require.NoError(t, repo.RebuildState())
+	require.NoError(t, index.Update(repo, snapshotIDs[:1]))
+	indexed, err = index.indexed()
+	require.NoError(t, err)
+	require.Len(t, indexed, 1)
+	locations, err = index.Lookup(pathname, snapshotIDs)
+	require.NoError(t, err)
+	require.Empty(t, locations)
+}
+
+func TestIndexBusy(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, _ := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	repo.AppContext().CacheDir = t.TempDir()
+
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy"),
+		ptesting.NewMockFile("subdir/README.md", 0644, "readme"),
+	})
+	defer snap.Close()
+	snapshotIDs := []objects.MAC{snap.Header.Identifier}
+
+	// as if another process, e.g. plakar ui, had the index open
+	indexPath := filepath.Join(repo.AppContext().CacheDir, "locate", INDEX_VERSION,
+		repo.Configuration().RepositoryID.String())
+	require.NoError(t, os.MkdirAll(indexPath, 0700))
+	lock, err := pebble.LockDirectory(indexPath, vfs.Default)
+	require.NoError(t, err)
+	defer lock.Close()
+
+	_, err = OpenIndex(repo)
+	require.ErrorIs(t, err, ErrIndexBusy)
+
+	q := NewDefaultPathnameQuery()
+	q.Patterns = []string{"*.txt", "readme.*"}
+	q.IgnoreCase = true
+	locations, err := WalkSearch(repo, q, snapshotIDs)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/subdir/README.md", "/subdir/dummy.txt"}, locationPaths(locations))
+	require.Equal(t, int64(len("hello dummy")), locations[1].Size)
+
+	locations, err = WalkLookup(repo, "/subdir/dummy.txt", snapshotIDs)
+	require.NoError(t, err)
+	require.Len(t, locations, 1)
+	require.Equal(t, snap.Header.Identifier, locations[0].SnapshotID)
+
+	locations, err = WalkLookup(repo, "/subdir/missing.txt", snapshotIDs)
+	require.NoError(t, err)
+	require.Empty(t, locations)
+}
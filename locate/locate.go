@@ -22,13 +22,14 @@ import (
 	"fmt"
 	"path"
 	"sort"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/header"
 	"github.com/PlakarKorp/plakar/utils"
 )
 
@@ -96,136 +97,122 @@ func (lo *LocateOptions) InstallFlags(flags *flag.FlagSet) {
 	flags.Var(utils.NewTimeFlag(&lo.Since), "since", "filter by date")
 }
 
-func LocateSnapshotIDs(repo *repository.Repository, opts *LocateOptions) ([]objects.MAC, error) {
-	type result struct {
-		snapshotID objects.MAC
-		timestamp  time.Time
+// Match tells whether a snapshot passes the filters of the options.
+func (lo *LocateOptions) Match(snapshotID objects.MAC, hdr *header.Header) bool {
+	if lo.Prefix != "" {
+		if !strings.HasPrefix(hex.EncodeToString(snapshotID[:]), lo.Prefix) {
+			return false
+		}
 	}
 
-	workSet := make([]result, 0)
-	workSetMutex := sync.Mutex{}
+	if lo.Name != "" {
+		if hdr.Name != lo.Name {
+			return false
+		}
+	}
 
-	if opts == nil {
-		opts = NewDefaultLocateOptions()
+	if lo.Category != "" {
+		if hdr.Category != lo.Category {
+			return false
+		}
 	}
 
-	wg := sync.WaitGroup{}
-	maxConcurrency := make(chan struct{}, opts.MaxConcurrency)
-	for snapshotID := range repo.ListSnapshots() {
-		maxConcurrency <- struct{}{}
-		wg.Add(1)
-		go func(snapshotID objects.MAC) {
-			defer func() {
-				<-maxConcurrency
-				wg.Done()
-			}()
-
-			snap, err := snapshot.Load(repo, snapshotID)
-			if err != nil {
-				return
-			}
-			defer snap.Close()
-
-			if opts.Prefix != "" {
-				if !strings.HasPrefix(hex.EncodeToString(snapshotID[:]), opts.Prefix) {
-					return
-				}
-			}
-
-			if opts.Name != "" {
-				if snap.Header.Name != opts.Name {
-					return
-				}
-			}
-
-			if opts.Category != "" {
-				if snap.Header.Category != opts.Category {
-					return
-				}
-			}
-
-			if opts.Environment != "" {
-				if snap.Header.Environment != opts.Environment {
-					return
-				}
-			}
-
-			if opts.Perimeter != "" {
-				if snap.Header.Perimeter != opts.Perimeter {
-					return
-				}
-			}
-
-			if opts.Job != "" {
-				if snap.Header.Job != opts.Job {
-					return
-				}
-			}
-
-			if opts.Tag != "" {
-				if !snap.Header.HasTag(opts.Tag) {
-					return
-				}
-			}
-
-			if !opts.Before.IsZero() {
-				if snap.Header.Timestamp.After(opts.Before) {
-					return
-				}
-			}
-
-			if !opts.Since.IsZero() {
-				if snap.Header.Timestamp.Before(opts.Since) {
-					return
-				}
-			}
-
-			workSetMutex.Lock()
-			workSet = append(workSet, result{
-				snapshotID: snapshotID,
-				timestamp:  snap.Header.Timestamp,
-			})
-			workSetMutex.Unlock()
-		}(snapshotID)
+	if lo.Environment != "" {
+		if hdr.Environment != lo.Environment {
+			return false
+		}
 	}
-	wg.Wait()
-
-	if opts.SortOrder != LocateSortOrderNone {
-		if opts.SortOrder == LocateSortOrderAscending {
-			sort.SliceStable(workSet, func(i, j int) bool {
-				return workSet[i].timestamp.Before(workSet[j].timestamp)
-			})
-		} else {
-			sort.SliceStable(workSet, func(i, j int) bool {
-				return workSet[i].timestamp.After(workSet[j].timestamp)
-			})
+
+	if lo.Perimeter != "" {
+		if hdr.Perimeter != lo.Perimeter {
+			return false
+		}
+	}
+
+	if lo.Job != "" {
+		if hdr.Job != lo.Job {
+			return false
 		}
 	}
 
-	if opts.Latest {
-		if len(workSet) >= 1 {
-			workSet = workSet[:1]
+	if lo.Tag != "" {
+		if !hdr.HasTag(lo.Tag) {
+			return false
 		}
 	}
 
-	resultSet := make([]objects.MAC, 0, len(workSet))
-	for _, result := range workSet {
-		resultSet = append(resultSet, result.snapshotID)
+	if !lo.Before.IsZero() {
+		if hdr.Timestamp.After(lo.Before) {
+			return false
+		}
+	}
+
+	if !lo.Since.IsZero() {
+		if hdr.Timestamp.Before(lo.Since) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// LocateHeaders is LocateSnapshotIDs for snapshot headers that were already
+// loaded.
+func LocateHeaders(headers []*header.Header, opts *LocateOptions) []*header.Header {
+	if opts == nil {
+		opts = NewDefaultLocateOptions()
+	}
+
+	resultSet := make([]*header.Header, 0, len(headers))
+	for _, hdr := range headers {
+		if opts.Match(hdr.Identifier, hdr) {
+			resultSet = append(resultSet, hdr)
+		}
+	}
+
+	switch opts.SortOrder {
+	case LocateSortOrderAscending:
+		sort.SliceStable(resultSet, func(i, j int) bool {
+			return resultSet[i].Timestamp.Before(resultSet[j].Timestamp)
+		})
+	case LocateSortOrderDescending:
+		sort.SliceStable(resultSet, func(i, j int) bool {
+			return resultSet[i].Timestamp.After(resultSet[j].Timestamp)
+		})
+	}
+
+	if opts.Latest && len(resultSet) > 1 {
+		resultSet = resultSet[:1]
+	}
+	return resultSet
+}
+
+func LocateSnapshotIDs(repo *repository.Repository, opts *LocateOptions) ([]objects.MAC, error) {
+	if opts == nil {
+		opts = NewDefaultLocateOptions()
+	}
+
+	headers, err := Headers(repo, opts.MaxConcurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	headers = LocateHeaders(headers, opts)
+	resultSet := make([]objects.MAC, 0, len(headers))
+	for _, hdr := range headers {
+		resultSet = append(resultSet, hdr.Identifier)
 	}
 
 	return resultSet, nil
 }
 
+// ParseSnapshotPath splits SNAPSHOT[:PATH], where SNAPSHOT is either a
+// snapshot ID prefix or a reference expression, see Reference.
 func ParseSnapshotPath(snapshotPath string) (string, string) {
 	if strings.HasPrefix(snapshotPath, "/") {
 		return "", snapshotPath
 	}
-	tmp := strings.SplitN(snapshotPath, ":", 2)
-	prefix := snapshotPath
-	pattern := ""
-	if len(tmp) == 2 {
-		prefix, pattern = tmp[0], tmp[1]
-	}
+	prefix, pattern, _ := splitSnapshotPath(snapshotPath)
 	return prefix, pattern
 }
 
@@ -250,10 +237,50 @@ func LocateSnapshotByPrefix(repo *repository.Repository, prefix string) (objects
 	return snapshots[0], nil
 }
 
+// Sources returns the roots backed up in a snapshot.  Snapshots spanning
+// several paths describe each of them as a source following the first one,
+// which covers the snapshot as a whole.  Others only have the first one.
+func Sources(hdr *header.Header) []string {
+	if len(hdr.Sources) <= 1 {
+		return []string{hdr.GetSource(0).Importer.Directory}
+	}
+
+	sources := make([]string, 0, len(hdr.Sources)-1)
+	for _, source := range hdr.Sources[1:] {
+		sources = append(sources, source.Importer.Directory)
+	}
+	return sources
+}
+
+// SourceRoot resolves a source, given either as its index or as its root
+// path, to the root path of that source within the snapshot.
+func SourceRoot(hdr *header.Header, source string) (string, error) {
+	sources := Sources(hdr)
+	if idx, err := strconv.Atoi(source); err == nil {
+		if idx < 0 || idx >= len(sources) {
+			return "", fmt.Errorf("snapshot has no source %d", idx)
+		}
+		return sources[idx], nil
+	}
+	for _, root := range sources {
+		if root == path.Clean(source) {
+			return root, nil
+		}
+	}
+	return "", fmt.Errorf("snapshot has no source %s", source)
+}
+
 func OpenSnapshotByPath(repo *repository.Repository, snapshotPath string) (*snapshot.Snapshot, string, error) {
+	return OpenSnapshotByPathInSource(repo, snapshotPath, "")
+}
+
+// OpenSnapshotByPathInSource is like OpenSnapshotByPath, but relative
+// pathnames are resolved against the root of the given source, and absolute
+// ones must be within it.
+func OpenSnapshotByPathInSource(repo *repository.Repository, snapshotPath string, source string) (*snapshot.Snapshot, string, error) {
 	prefix, pathname := ParseSnapshotPath(snapshotPath)
 
-	snapshotID, err := LocateSnapshotByPrefix(repo, prefix)
+	snapshotID, err := LocateSnapshot(repo, prefix)
 	if err != nil {
 		return nil, "", err
 	}
@@ -263,11 +290,38 @@ func OpenSnapshotByPath(repo *repository.Repository, snapshotPath string) (*snap
 		return nil, "", err
 	}
 
+	snapRoot, err := ResolvePathname(snap.Header, pathname, source)
+	if err != nil {
+		snap.Close()
+		return nil, "", err
+	}
+	return snap, snapRoot, nil
+}
+
+// ResolvePathname turns pathname into an absolute pathname of the snapshot.
+// Relative pathnames are resolved against the root of the given source, or
+// of the importer if source is empty, and absolute ones must be within the
+// source.
+func ResolvePathname(hdr *header.Header, pathname string, source string) (string, error) {
+	root := hdr.GetSource(0).Importer.Directory
+	if source != "" {
+		var err error
+		root, err = SourceRoot(hdr, source)
+		if err != nil {
+			return "", err
+		}
+	}
+
 	var snapRoot string
 	if strings.HasPrefix(pathname, "/") {
 		snapRoot = pathname
 	} else {
-		snapRoot = path.Clean(path.Join(snap.Header.GetSource(0).Importer.Directory, pathname))
+		snapRoot = path.Clean(path.Join(root, pathname))
+	}
+	snapRoot = path.Clean(snapRoot)
+
+	if source != "" && snapRoot != root && root != "/" && !strings.HasPrefix(snapRoot, root+"/") {
+		return "", fmt.Errorf("%s is not within source %s", snapRoot, root)
 	}
-	return snap, path.Clean(snapRoot), err
+	return snapRoot, nil
 }
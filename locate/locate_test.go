@@ -7,9 +7,12 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/header"
 	ptesting "github.com/PlakarKorp/plakar/testing"
 	"github.com/stretchr/testify/require"
 )
@@ -153,3 +156,32 @@ func TestLocateSnapshotIDs(t *testing.T) {
 	require.Len(t, results2, 1)
 	require.Contains(t, results2, snap3.Header.Identifier)
 }
+
+func TestLocateHeaders(t *testing.T) {
+	mk := func(id byte, job string, tags []string, ts string) *header.Header {
+		hdr := header.NewHeader("default", objects.MAC{id})
+		hdr.Job = job
+		hdr.Tags = tags
+		hdr.Timestamp, _ = time.Parse(time.RFC3339, ts)
+		return hdr
+	}
+	headers := []*header.Header{
+		mk(1, "web", []string{"prod"}, "2026-10-15T10:00:00Z"),
+		mk(2, "db", []string{"prod"}, "2026-10-16T10:00:00Z"),
+		mk(3, "web", nil, "2026-10-17T10:00:00Z"),
+	}
+
+	opts := NewDefaultLocateOptions()
+	opts.Job = "web"
+	opts.SortOrder = LocateSortOrderDescending
+	results := LocateHeaders(headers, opts)
+	require.Equal(t, []*header.Header{headers[2], headers[0]}, results)
+
+	opts.Latest = true
+	require.Equal(t, []*header.Header{headers[2]}, LocateHeaders(headers, opts))
+
+	opts = NewDefaultLocateOptions()
+	opts.Tag = "prod"
+	opts.Since = headers[1].Timestamp
+	require.Equal(t, []*header.Header{headers[1]}, LocateHeaders(headers, opts))
+}
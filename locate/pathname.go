@@ -0,0 +1,96 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package locate
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"os"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/header"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+)
+
+// PathnameVersion is a pathname as found in one of the snapshots.
+type PathnameVersion struct {
+	Snapshot *header.Header
+	Pathname string
+	Entry    *vfs.Entry
+}
+
+// LocatePathname yields the versions of pathname held by the snapshots
+// matching opts, in their order.  pathname is resolved in each snapshot as
+// with ResolvePathname, and the snapshots without that pathname, or without
+// the given source, are skipped.
+func LocatePathname(repo *repository.Repository, opts *LocateOptions, pathname string, source string) iter.Seq2[*PathnameVersion, error] {
+	return func(yield func(*PathnameVersion, error) bool) {
+		if opts == nil {
+			opts = NewDefaultLocateOptions()
+		}
+
+		headers, err := Headers(repo, opts.MaxConcurrency)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		for _, hdr := range LocateHeaders(headers, opts) {
+			snapPathname, err := ResolvePathname(hdr, pathname, source)
+			if err != nil {
+				continue
+			}
+
+			entry, err := lookupEntry(repo, hdr.Identifier, snapPathname)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				if !yield(nil, fmt.Errorf("snapshot %x: %w", hdr.GetIndexShortID(), err)) {
+					return
+				}
+				continue
+			}
+
+			version := &PathnameVersion{
+				Snapshot: hdr,
+				Pathname: snapPathname,
+				Entry:    entry,
+			}
+			if !yield(version, nil) {
+				return
+			}
+		}
+	}
+}
+
+func lookupEntry(repo *repository.Repository, snapshotID objects.MAC, pathname string) (*vfs.Entry, error) {
+	snap, err := snapshot.Load(repo, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Close()
+
+	fs, err := snap.Filesystem()
+	if err != nil {
+		return nil, err
+	}
+	return fs.GetEntry(pathname)
+}
@@ -0,0 +1,67 @@
+package locate
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocatePathname(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, _ := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+
+	snap1 := generateSnapshotWithMetadata(t, repo, ptesting.WithJob("web"))
+	defer snap1.Close()
+	snap2 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+	}, ptesting.WithJob("web"))
+	defer snap2.Close()
+	snap3 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy, again"),
+	}, ptesting.WithJob("web"))
+	defer snap3.Close()
+
+	versions := func(opts *LocateOptions, pathname string) ([]objects.MAC, []*PathnameVersion) {
+		var ids []objects.MAC
+		var found []*PathnameVersion
+		for version, err := range LocatePathname(repo, opts, pathname, "") {
+			require.NoError(t, err)
+			ids = append(ids, version.Snapshot.Identifier)
+			found = append(found, version)
+		}
+		return ids, found
+	}
+
+	opts := NewDefaultLocateOptions()
+	opts.SortOrder = LocateSortOrderDescending
+	ids, found := versions(opts, "/subdir/dummy.txt")
+	require.Equal(t, []objects.MAC{snap3.Header.Identifier, snap1.Header.Identifier}, ids)
+	require.Equal(t, "/subdir/dummy.txt", found[0].Pathname)
+	require.Equal(t, int64(len("hello dummy, again")), found[0].Entry.Size())
+	require.Equal(t, int64(len("hello dummy")), found[1].Entry.Size())
+
+	// relative pathnames are resolved against the importer root
+	ids, _ = versions(opts, "subdir/dummy.txt")
+	require.Equal(t, []objects.MAC{snap3.Header.Identifier, snap1.Header.Identifier}, ids)
+
+	opts.Before = snap2.Header.Timestamp
+	ids, _ = versions(opts, "/subdir/dummy.txt")
+	require.Equal(t, []objects.MAC{snap1.Header.Identifier}, ids)
+
+	ids, _ = versions(opts, "/subdir")
+	require.Equal(t, []objects.MAC{snap2.Header.Identifier, snap1.Header.Identifier}, ids)
+
+	ids, _ = versions(opts, "/nonexistent")
+	require.Empty(t, ids)
+
+	// snapshots without the source are skipped
+	for range LocatePathname(repo, nil, "/subdir", "/elsewhere") {
+		t.Fatal("unexpected version")
+	}
+}
@@ -0,0 +1,195 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package locate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+// Reference is a snapshot designated by an expression rather than by its
+// ID:
+//
+//	[FILTER[,FILTER...]@]WHEN[~N]
+//
+// where FILTER is one of name=, category=, environment=, perimeter=, job=
+// or tag= followed by a value, WHEN is either "latest" or a date, and N
+// counts the snapshots to go back from there, one if omitted.  The filters
+// alone stand for their latest snapshot.  For instance "latest~2",
+// "job=web@latest~1", "tag=db@2026-10-01" or "name=etc,tag=daily@3d".
+type Reference struct {
+	Name        string
+	Category    string
+	Environment string
+	Perimeter   string
+	Job         string
+	Tag         string
+
+	// Before is the latest date of the snapshot, zero for the latest one
+	Before time.Time
+
+	// Offset is the number of matching snapshots to go back from Before
+	Offset int
+}
+
+// IsReference tells whether ref is a reference expression rather than a
+// snapshot ID prefix.
+func IsReference(ref string) bool {
+	return ref == "latest" || strings.ContainsAny(ref, "=@~")
+}
+
+// ParseReference parses a snapshot reference expression.
+func ParseReference(ref string) (*Reference, error) {
+	r := &Reference{}
+	expr := ref
+
+	if idx := strings.LastIndexByte(expr, '~'); idx != -1 {
+		r.Offset = 1
+		if n := expr[idx+1:]; n != "" {
+			offset, err := strconv.Atoi(n)
+			if err != nil || offset < 0 {
+				return nil, fmt.Errorf("invalid snapshot reference %q: bad offset %q", ref, n)
+			}
+			r.Offset = offset
+		}
+		expr = expr[:idx]
+	}
+
+	filters, when := "", expr
+	if idx := strings.LastIndexByte(expr, '@'); idx != -1 {
+		filters, when = expr[:idx], expr[idx+1:]
+	} else if expr != "latest" {
+		filters, when = expr, "latest"
+	}
+
+	if filters != "" {
+		for _, filter := range strings.Split(filters, ",") {
+			key, value, found := strings.Cut(filter, "=")
+			if !found || value == "" {
+				return nil, fmt.Errorf("invalid snapshot reference %q: bad filter %q", ref, filter)
+			}
+			switch key {
+			case "name":
+				r.Name = value
+			case "category":
+				r.Category = value
+			case "environment":
+				r.Environment = value
+			case "perimeter":
+				r.Perimeter = value
+			case "job":
+				r.Job = value
+			case "tag":
+				r.Tag = value
+			default:
+				return nil, fmt.Errorf("invalid snapshot reference %q: unknown filter %q", ref, key)
+			}
+		}
+	}
+
+	if when != "latest" {
+		before, err := utils.ParseTimeFlag(when)
+		if err != nil || before.IsZero() {
+			return nil, fmt.Errorf("invalid snapshot reference %q: bad date %q", ref, when)
+		}
+		// a day includes all the snapshots taken that day
+		if isDay(when) {
+			before = before.Add(24*time.Hour - time.Nanosecond)
+		}
+		r.Before = before
+	}
+
+	return r, nil
+}
+
+func isDay(s string) bool {
+	for _, layout := range []string{"2006-01-02", "2006/01/02"} {
+		if _, err := time.Parse(layout, s); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// LocateOptions returns the options locating the snapshots the reference
+// is picked from, most recent first.
+func (r *Reference) LocateOptions() *LocateOptions {
+	opts := NewDefaultLocateOptions()
+	opts.SortOrder = LocateSortOrderDescending
+	opts.Name = r.Name
+	opts.Category = r.Category
+	opts.Environment = r.Environment
+	opts.Perimeter = r.Perimeter
+	opts.Job = r.Job
+	opts.Tag = r.Tag
+	opts.Before = r.Before
+	return opts
+}
+
+// LocateSnapshot resolves ref, either a snapshot ID prefix or a reference
+// expression, to the ID of a snapshot.
+func LocateSnapshot(repo *repository.Repository, ref string) (objects.MAC, error) {
+	if !IsReference(ref) {
+		return LocateSnapshotByPrefix(repo, ref)
+	}
+
+	r, err := ParseReference(ref)
+	if err != nil {
+		return objects.MAC{}, err
+	}
+
+	snapshotIDs, err := LocateSnapshotIDs(repo, r.LocateOptions())
+	if err != nil {
+		return objects.MAC{}, err
+	}
+	if r.Offset >= len(snapshotIDs) {
+		if len(snapshotIDs) == 0 {
+			return objects.MAC{}, fmt.Errorf("no snapshot matches: %s", ref)
+		}
+		return objects.MAC{}, fmt.Errorf("no snapshot matches: %s (only %d snapshots)", ref, len(snapshotIDs))
+	}
+	return snapshotIDs[r.Offset], nil
+}
+
+// splitSnapshotPath splits SNAPSHOT:PATH on the first colon, unless it is
+// part of the date of a reference expression, e.g. in
+// "job=web@2026-10-01T12:00:00Z:/etc".
+func splitSnapshotPath(snapshotPath string) (string, string, bool) {
+	ref, pathname, found := strings.Cut(snapshotPath, ":")
+	if !found || !strings.Contains(ref, "@") {
+		return ref, pathname, found
+	}
+
+	for i := len(snapshotPath); i > len(ref); i-- {
+		if i != len(snapshotPath) && snapshotPath[i] != ':' {
+			continue
+		}
+		if _, err := ParseReference(snapshotPath[:i]); err == nil {
+			if i == len(snapshotPath) {
+				return snapshotPath, "", false
+			}
+			return snapshotPath[:i], snapshotPath[i+1:], true
+		}
+	}
+	return ref, pathname, found
+}
@@ -0,0 +1,121 @@
+package locate
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsReference(t *testing.T) {
+	require.False(t, IsReference(""))
+	require.False(t, IsReference("0a1b2c"))
+	require.True(t, IsReference("latest"))
+	require.True(t, IsReference("latest~1"))
+	require.True(t, IsReference("job=web"))
+}
+
+func TestParseReference(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.Parse("2006-01-02", s)
+		require.NoError(t, err)
+		return d.Add(24*time.Hour - time.Nanosecond)
+	}
+
+	for ref, expected := range map[string]Reference{
+		"latest":                          {},
+		"latest~2":                        {Offset: 2},
+		"latest~":                         {Offset: 1},
+		"job=web":                         {Job: "web"},
+		"job=web~1":                       {Job: "web", Offset: 1},
+		"job=web@latest~1":                {Job: "web", Offset: 1},
+		"tag=db@2026-10-01":               {Tag: "db", Before: day("2026-10-01")},
+		"name=etc,category=system@latest": {Name: "etc", Category: "system"},
+		"environment=prod,perimeter=eu@2026-10-01T12:00:00Z": {
+			Environment: "prod",
+			Perimeter:   "eu",
+			Before:      time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC),
+		},
+	} {
+		r, err := ParseReference(ref)
+		require.NoError(t, err, ref)
+		require.Equal(t, expected, *r, ref)
+	}
+
+	r, err := ParseReference("job=web@3d")
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(-72*time.Hour), r.Before, time.Minute)
+
+	for _, ref := range []string{
+		"lastest",
+		"latest~x",
+		"latest~-1",
+		"job=",
+		"owner=me",
+		"job=web@yesterday-ish",
+		"job=web,tag@latest",
+	} {
+		_, err := ParseReference(ref)
+		require.Error(t, err, ref)
+	}
+}
+
+func TestParseSnapshotPathReference(t *testing.T) {
+	for input, expected := range map[string][2]string{
+		"latest:/etc":                      {"latest", "/etc"},
+		"job=web@latest~1:etc/passwd":      {"job=web@latest~1", "etc/passwd"},
+		"tag=db@2026-10-01:/var/lib":       {"tag=db@2026-10-01", "/var/lib"},
+		"tag=db@2026-10-01T12:00:00Z:/a:b": {"tag=db@2026-10-01T12:00:00Z", "/a:b"},
+		"tag=db@2026-10-01T12:00:00Z":      {"tag=db@2026-10-01T12:00:00Z", ""},
+	} {
+		prefix, pathname := ParseSnapshotPath(input)
+		require.Equal(t, expected[0], prefix, input)
+		require.Equal(t, expected[1], pathname, input)
+	}
+}
+
+func TestLocateSnapshot(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, _ := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+
+	web1 := generateSnapshotWithMetadata(t, repo, ptesting.WithJob("web"))
+	defer web1.Close()
+	db := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+	}, ptesting.WithJob("db"), ptesting.WithTags("daily"))
+	defer db.Close()
+	web2 := generateSnapshotWithMetadata(t, repo, ptesting.WithJob("web"))
+	defer web2.Close()
+
+	for ref, expected := range map[string][32]byte{
+		"latest":           web2.Header.Identifier,
+		"latest~2":         web1.Header.Identifier,
+		"job=web":          web2.Header.Identifier,
+		"job=web@latest~1": web1.Header.Identifier,
+		"tag=daily":        db.Header.Identifier,
+		"job=db,tag=daily": db.Header.Identifier,
+	} {
+		snapshotID, err := LocateSnapshot(repo, ref)
+		require.NoError(t, err, ref)
+		require.Equal(t, expected, [32]byte(snapshotID), ref)
+	}
+
+	_, err := LocateSnapshot(repo, "job=web~2")
+	require.ErrorContains(t, err, "only 2 snapshots")
+
+	_, err = LocateSnapshot(repo, "job=mail")
+	require.ErrorContains(t, err, "no snapshot matches: job=mail")
+
+	_, err = LocateSnapshot(repo, "job=web@2000-01-01")
+	require.ErrorContains(t, err, "no snapshot matches")
+
+	snap, pathname, err := OpenSnapshotByPath(repo, "job=web@latest~1:subdir")
+	require.NoError(t, err)
+	defer snap.Close()
+	require.Equal(t, web1.Header.Identifier, snap.Header.Identifier)
+	require.Equal(t, "subdir", pathname[len(pathname)-len("subdir"):])
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/PlakarKorp/plakar/agent"
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/cookies"
+	"github.com/PlakarKorp/plakar/output"
 	"github.com/PlakarKorp/plakar/plugins"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/task"
@@ -46,6 +47,7 @@ import (
 	_ "github.com/PlakarKorp/plakar/subcommands/diff"
 	_ "github.com/PlakarKorp/plakar/subcommands/digest"
 	_ "github.com/PlakarKorp/plakar/subcommands/help"
+	_ "github.com/PlakarKorp/plakar/subcommands/history"
 	_ "github.com/PlakarKorp/plakar/subcommands/info"
 	_ "github.com/PlakarKorp/plakar/subcommands/locate"
 	_ "github.com/PlakarKorp/plakar/subcommands/login"
@@ -53,6 +55,7 @@ import (
 	_ "github.com/PlakarKorp/plakar/subcommands/maintenance"
 	_ "github.com/PlakarKorp/plakar/subcommands/mount"
 	_ "github.com/PlakarKorp/plakar/subcommands/pkg"
+	_ "github.com/PlakarKorp/plakar/subcommands/prune"
 	_ "github.com/PlakarKorp/plakar/subcommands/ptar"
 	_ "github.com/PlakarKorp/plakar/subcommands/restore"
 	_ "github.com/PlakarKorp/plakar/subcommands/rm"
@@ -122,6 +125,8 @@ func entryPoint() int {
 	var opt_time bool
 	var opt_trace string
 	var opt_quiet bool
+	var opt_json bool
+	var opt_ndjson bool
 	var opt_keyfile string
 	var opt_agentless bool
 	var opt_enableSecurityCheck bool
@@ -136,6 +141,8 @@ func entryPoint() int {
 	flag.BoolVar(&opt_time, "time", false, "display command execution time")
 	flag.StringVar(&opt_trace, "trace", "", "display trace logs, comma-separated (all, trace, repository, snapshot, server)")
 	flag.BoolVar(&opt_quiet, "quiet", false, "no output except errors")
+	flag.BoolVar(&opt_json, "json", false, "output results as JSON")
+	flag.BoolVar(&opt_ndjson, "ndjson", false, "output results as newline-delimited JSON")
 	flag.StringVar(&opt_keyfile, "keyfile", "", "use passphrase from key file when prompted")
 	flag.BoolVar(&opt_agentless, "no-agent", false, "run without agent")
 	flag.BoolVar(&opt_enableSecurityCheck, "enable-security-check", false, "enable update check")
@@ -228,6 +235,16 @@ func entryPoint() int {
 	}
 	runtime.GOMAXPROCS(opt_cpuCount)
 
+	outputFormat := output.Text
+	if opt_json && opt_ndjson {
+		fmt.Fprintf(os.Stderr, "%s: -json and -ndjson are mutually exclusive\n", flag.CommandLine.Name())
+		return 1
+	} else if opt_json {
+		outputFormat = output.JSON
+	} else if opt_ndjson {
+		outputFormat = output.NDJSON
+	}
+
 	if opt_cpuProfile != "" {
 		f, err := os.Create(opt_cpuProfile)
 		if err != nil {
@@ -270,8 +287,8 @@ func entryPoint() int {
 
 	logger := logging.NewLogger(os.Stdout, os.Stderr)
 
-	// start logging
-	if !opt_quiet {
+	// start logging, info messages would get mixed with JSON on stdout
+	if !opt_quiet && outputFormat == output.Text {
 		logger.EnableInfo()
 	}
 	if opt_trace != "" {
@@ -403,6 +420,7 @@ func entryPoint() int {
 
 	cmd.SetCWD(ctx.CWD)
 	cmd.SetCommandLine(ctx.CommandLine)
+	cmd.SetOutputFormat(outputFormat)
 
 	c := make(chan os.Signal, 1)
 	go func() {
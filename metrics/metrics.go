@@ -0,0 +1,149 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+// Package metrics holds the Prometheus collectors exported by the agent on
+// its -prometheus interface.
+//
+// Scheduler tasks are labelled by taskset, task and type, e.g. "db",
+// "db/backup" and "backup", while commands run through the agent are
+// labelled by command.  Statuses are those of the reports: OK, WARNING or
+// FAILURE.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "plakar"
+
+var taskLabels = []string{"taskset", "task", "type"}
+
+// durations from a second to a day
+var durationBuckets = prometheus.ExponentialBuckets(1, 2, 17)
+
+var (
+	TaskRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "task_runs_total",
+		Help:      "Runs of the scheduler tasks, by status.",
+	}, append(taskLabels, "status"))
+
+	TaskDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "task_duration_seconds",
+		Help:      "Duration of the runs of the scheduler tasks.",
+		Buckets:   durationBuckets,
+	}, taskLabels)
+
+	TaskLastRun = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "task_last_run_timestamp_seconds",
+		Help:      "Time at which the last run of the scheduler tasks completed.",
+	}, taskLabels)
+
+	TaskLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "task_last_success_timestamp_seconds",
+		Help:      "Time at which the last successful run of the scheduler tasks completed.",
+	}, taskLabels)
+
+	TaskRunning = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "task_running",
+		Help:      "Whether the scheduler tasks are running.",
+	}, taskLabels)
+
+	TaskFiles = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "task_files_total",
+		Help:      "Files processed by the scheduler tasks.",
+	}, taskLabels)
+
+	TaskDirectories = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "task_directories_total",
+		Help:      "Directories processed by the scheduler tasks.",
+	}, taskLabels)
+
+	TaskErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "task_errors_total",
+		Help:      "Errors, missing and corrupted entries met by the scheduler tasks.",
+	}, taskLabels)
+
+	TaskReadBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "task_read_bytes_total",
+		Help:      "Bytes read from the repository by the scheduler tasks.",
+	}, taskLabels)
+
+	TaskWrittenBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "task_written_bytes_total",
+		Help:      "Bytes written to the repository by the scheduler tasks.",
+	}, taskLabels)
+
+	RepositorySize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "repository_size_bytes",
+		Help:      "Storage size of the repositories, as of the last task run on them.",
+	}, []string{"repository"})
+
+	CommandRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "command_runs_total",
+		Help:      "Commands run through the agent, by status.",
+	}, []string{"command", "status"})
+
+	CommandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "command_duration_seconds",
+		Help:      "Duration of the commands run through the agent.",
+		Buckets:   durationBuckets,
+	}, []string{"command"})
+
+	CommandReadBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "command_read_bytes_total",
+		Help:      "Bytes read from repositories by the commands run through the agent.",
+	}, []string{"command"})
+
+	CommandWrittenBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "command_written_bytes_total",
+		Help:      "Bytes written to repositories by the commands run through the agent.",
+	}, []string{"command"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		TaskRuns,
+		TaskDuration,
+		TaskLastRun,
+		TaskLastSuccess,
+		TaskRunning,
+		TaskFiles,
+		TaskDirectories,
+		TaskErrors,
+		TaskReadBytes,
+		TaskWrittenBytes,
+		RepositorySize,
+		CommandRuns,
+		CommandDuration,
+		CommandReadBytes,
+		CommandWrittenBytes,
+	)
+}
@@ -20,13 +20,26 @@ type ResCreate struct {
 	Err string
 }
 
+// Versions of the HTTP storage protocol.  The first one carries everything
+// as JSON, the second one streams packfiles and states as raw bodies.
+const (
+	ProtocolV1 = 1
+	ProtocolV2 = 2
+)
+
 type ReqOpen struct {
 	Repository string
+
+	// protocol versions supported by the client, none means ProtocolV1
+	Protocols []int `json:",omitempty"`
 }
 
 type ResOpen struct {
 	Configuration []byte
 	Err           string
+
+	// protocol version picked by the server, none means ProtocolV1
+	Protocol int `json:",omitempty"`
 }
 
 // states
@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Output formats, the zero value is the human-readable text output.
+const (
+	Text   = ""
+	JSON   = "json"
+	NDJSON = "ndjson"
+)
+
+// Encoder writes a list of records, either as a single JSON array or as
+// one JSON object per line so that consumers can process them as they
+// come.  It is safe for concurrent use.
+type Encoder struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format string
+	count  int
+	closed bool
+}
+
+func NewEncoder(w io.Writer, format string) *Encoder {
+	return &Encoder{
+		w:      w,
+		format: format,
+	}
+}
+
+func (e *Encoder) Encode(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return fmt.Errorf("encoder is closed")
+	}
+
+	var prefix string
+	if e.format == JSON {
+		if e.count == 0 {
+			prefix = "[\n"
+		} else {
+			prefix = ",\n"
+		}
+	}
+	e.count++
+
+	if _, err := io.WriteString(e.w, prefix); err != nil {
+		return err
+	}
+	if _, err := e.w.Write(data); err != nil {
+		return err
+	}
+	if e.format == NDJSON {
+		_, err = io.WriteString(e.w, "\n")
+	}
+	return err
+}
+
+// Close terminates the JSON array, an empty list is still written as [].
+func (e *Encoder) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed || e.format != JSON {
+		e.closed = true
+		return nil
+	}
+	e.closed = true
+
+	if e.count == 0 {
+		_, err := io.WriteString(e.w, "[]\n")
+		return err
+	}
+	_, err := io.WriteString(e.w, "\n]\n")
+	return err
+}
+
+// Write outputs a single record, indented unless streaming.
+func Write(w io.Writer, format string, v any) error {
+	var data []byte
+	var err error
+	if format == NDJSON {
+		data, err = json.Marshal(v)
+	} else {
+		data, err = json.MarshalIndent(v, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	_, err = w.Write(data)
+	return err
+}
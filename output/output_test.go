@@ -0,0 +1,54 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type record struct {
+	Name  string `json:"name"`
+	Value int    `json:"value"`
+}
+
+func TestEncoderJSON(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf, JSON)
+	require.NoError(t, enc.Close())
+	require.Equal(t, "[]\n", buf.String())
+
+	buf.Reset()
+	enc = NewEncoder(buf, JSON)
+	require.NoError(t, enc.Encode(record{Name: "a", Value: 1}))
+	require.NoError(t, enc.Encode(record{Name: "b", Value: 2}))
+	require.NoError(t, enc.Close())
+	require.Error(t, enc.Encode(record{}))
+
+	var records []record
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &records))
+	require.Equal(t, []record{{"a", 1}, {"b", 2}}, records)
+}
+
+func TestEncoderNDJSON(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf, NDJSON)
+	require.NoError(t, enc.Encode(record{Name: "a", Value: 1}))
+	require.NoError(t, enc.Encode(record{Name: "b", Value: 2}))
+	require.NoError(t, enc.Close())
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Equal(t, []string{`{"name":"a","value":1}`, `{"name":"b","value":2}`}, lines)
+}
+
+func TestWrite(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, Write(buf, NDJSON, record{Name: "a", Value: 1}))
+	require.Equal(t, "{\"name\":\"a\",\"value\":1}\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, Write(buf, JSON, record{Name: "a", Value: 1}))
+	require.Equal(t, "{\n  \"name\": \"a\",\n  \"value\": 1\n}\n", buf.String())
+}
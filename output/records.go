@@ -0,0 +1,136 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package output
+
+import (
+	"io/fs"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/kloset/storage"
+)
+
+// The snapshot headers and VFS entries are emitted with the same schema as
+// the one served by the UI API, the records below wrap them with what a
+// consumer needs to know where they come from.
+
+// Repository is the configuration and usage of a repository.
+type Repository struct {
+	Location      string                `json:"location"`
+	Configuration storage.Configuration `json:"configuration"`
+	Snapshots     int                   `json:"snapshots"`
+	StorageSize   int64                 `json:"storage_size"`
+	LogicalSize   int64                 `json:"logical_size"`
+}
+
+// Entry is a VFS entry of a snapshot, with its full objects.FileInfo.
+type Entry struct {
+	Snapshot objects.MAC `json:"snapshot"`
+	Path     string      `json:"path"`
+	Entry    *vfs.Entry  `json:"entry"`
+}
+
+// Match is a pathname found by locate.
+type Match struct {
+	Snapshot objects.MAC `json:"snapshot"`
+	Path     string      `json:"path"`
+}
+
+// Digest is the digest of a regular file of a snapshot.
+type Digest struct {
+	Snapshot  objects.MAC `json:"snapshot"`
+	Path      string      `json:"path"`
+	Algorithm string      `json:"algorithm"`
+	Digest    string      `json:"digest"`
+}
+
+// Version is a distinct version of a path, held by the snapshots from
+// First to Last.  Digest is the MAC of the content of regular files in the
+// repository.
+type Version struct {
+	Path      string       `json:"path"`
+	Entry     *vfs.Entry   `json:"entry"`
+	Digest    *objects.MAC `json:"digest,omitempty"`
+	First     objects.MAC  `json:"first"`
+	Last      objects.MAC  `json:"last"`
+	Snapshots int          `json:"snapshots"`
+}
+
+// Check record types and statuses.  A check emits one record per problem
+// found, plus per path verified unless quiet, and ends each snapshot with
+// a record of type "snapshot" summing it up.
+const (
+	CheckSnapshot  = "snapshot"
+	CheckDirectory = "directory"
+	CheckFile      = "file"
+	CheckObject    = "object"
+	CheckChunk     = "chunk"
+
+	StatusOK        = "ok"
+	StatusMissing   = "missing"
+	StatusCorrupted = "corrupted"
+	StatusError     = "error"
+	StatusFailed    = "failed"
+
+	SignatureVerified = "verified"
+	SignatureFailed   = "failed"
+	SignatureError    = "error"
+	SignatureUnsigned = "unsigned"
+	SignatureSkipped  = "skipped"
+)
+
+type CheckRecord struct {
+	Snapshot  objects.MAC  `json:"snapshot"`
+	Type      string       `json:"type"`
+	Path      string       `json:"path,omitempty"`
+	MAC       *objects.MAC `json:"mac,omitempty"`
+	Status    string       `json:"status"`
+	Signature string       `json:"signature,omitempty"`
+	Message   string       `json:"message,omitempty"`
+}
+
+// Diff changes, paths only present in the first tree are removed and
+// those only present in the second are added.  Permission changes are only
+// reported by the summary, when the content did not change.
+const (
+	DiffAdded             = "added"
+	DiffRemoved           = "removed"
+	DiffModified          = "modified"
+	DiffTypeChanged       = "type_changed"
+	DiffPermissionChanged = "permission_changed"
+)
+
+type DiffRecord struct {
+	Change string            `json:"change"`
+	Path   string            `json:"path"`
+	Old    *objects.FileInfo `json:"old,omitempty"`
+	New    *objects.FileInfo `json:"new,omitempty"`
+	Diff   string            `json:"diff,omitempty"`
+}
+
+// FileInfo returns the objects.FileInfo of fi, synthesizing what it can
+// when it does not come from a snapshot.
+func FileInfo(fi fs.FileInfo) *objects.FileInfo {
+	if fi == nil {
+		return nil
+	}
+	if finfo, ok := fi.Sys().(objects.FileInfo); ok {
+		return &finfo
+	}
+	finfo := objects.NewFileInfo(fi.Name(), fi.Size(), fi.Mode(), fi.ModTime(), 0, 0, 0, 0, 1)
+	return &finfo
+}
@@ -0,0 +1,73 @@
+package plakarfs
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/PlakarKorp/kloset/objects"
+)
+
+// DefaultChunkCacheSize is the default amount of chunk data kept in memory
+// by a mount.
+const DefaultChunkCacheSize = 64 << 20
+
+type cachedChunk struct {
+	mac  objects.MAC
+	data []byte
+}
+
+// chunkCache is a LRU cache of decoded chunks bounded by the total size of
+// the data it holds, so that sequential and nearby reads of a file don't
+// fetch and decode the same chunk over and over.
+type chunkCache struct {
+	mu      sync.Mutex
+	maxSize int64
+	size    int64
+	lru     *list.List
+	entries map[objects.MAC]*list.Element
+}
+
+func newChunkCache(maxSize int64) *chunkCache {
+	return &chunkCache{
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[objects.MAC]*list.Element),
+	}
+}
+
+func (c *chunkCache) Get(mac objects.MAC) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[mac]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*cachedChunk).data, true
+}
+
+func (c *chunkCache) Put(mac objects.MAC, data []byte) {
+	if int64(len(data)) > c.maxSize {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[mac]; ok {
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	c.entries[mac] = c.lru.PushFront(&cachedChunk{mac: mac, data: data})
+	c.size += int64(len(data))
+
+	for c.size > c.maxSize {
+		elem := c.lru.Back()
+		chunk := elem.Value.(*cachedChunk)
+		c.lru.Remove(elem)
+		delete(c.entries, chunk.mac)
+		c.size -= int64(len(chunk.data))
+	}
+}
@@ -4,11 +4,11 @@ package plakarfs
 
 import (
 	"context"
-	"encoding/hex"
 	"fmt"
 	"math/rand/v2"
 	"os"
 	"path/filepath"
+	"sync"
 	"syscall"
 
 	"github.com/PlakarKorp/kloset/objects"
@@ -19,44 +19,97 @@ import (
 	"github.com/anacrolix/fuse/fs"
 )
 
+// Dir implements Node for the directories of a snapshot.  The root of a
+// snapshot has no parent and opens the snapshot on first access.
 type Dir struct {
 	parent   *Dir
 	name     string
 	fullpath string
 	repo     *repository.Repository
-	snap     *snapshot.Snapshot
-	vfs      *vfs.Filesystem
+	fsys     *FS
+
+	// set on snapshot roots that are not opened yet
+	snapshotID objects.MAC
+
+	mu sync.Mutex
+
+	// set at lookup time, snapshots being immutable, roots set and
+	// reset them under mu as they are opened and closed
+	snap  *snapshot.Snapshot
+	vfs   *vfs.Filesystem
+	entry *vfs.Entry
+
+	children map[string]fs.Node
+}
+
+// open returns the snapshot, filesystem and entry of the directory, loading
+// the snapshot of a root directory first.
+func (d *Dir) open() (*snapshot.Snapshot, *vfs.Filesystem, *vfs.Entry, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.vfs != nil {
+		return d.snap, d.vfs, d.entry, nil
+	}
+
+	snap, err := snapshot.Load(d.repo, d.snapshotID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	snapfs, err := snap.Filesystem()
+	if err != nil {
+		snap.Close()
+		return nil, nil, nil, err
+	}
+	entry, err := snapfs.GetEntry(d.fullpath)
+	if err != nil {
+		snap.Close()
+		return nil, nil, nil, err
+	}
+
+	d.snap = snap
+	d.vfs = snapfs
+	d.entry = entry
+	return snap, snapfs, entry, nil
+}
+
+// close releases the snapshot of a root directory.
+func (d *Dir) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.snap != nil {
+		d.snap.Close()
+	}
+	d.snap = nil
+	d.vfs = nil
+	d.entry = nil
+	d.children = nil
 }
 
 func (d *Dir) Attr(ctx context.Context, a *fuse.Attr) error {
-	if d.name == "/" {
-		d.fullpath = d.name
-		a.Inode = 1
-		a.Mode = os.ModeDir | 0o700
-		a.Uid = uint32(os.Geteuid())
-		a.Gid = uint32(os.Getgid())
-	} else if d.parent.name == "/" {
-		snapshotID, err := hex.DecodeString(d.name)
-		if err != nil {
-			return err
-		}
-		if len(snapshotID) != 32 {
-			return fmt.Errorf("invalid snapshot id length %d", len(snapshotID))
-		}
-		snap, err := snapshot.Load(d.repo, objects.MAC(snapshotID))
-		if err != nil {
-			return err
-		}
-		snapfs, err := snap.Filesystem()
-		if err != nil {
-			return err
-		}
+	snap, _, fi, err := d.open()
+	if err != nil {
+		return err
+	}
 
-		d.snap = snap
-		d.repo = d.parent.repo
-		d.vfs = snapfs
-		d.fullpath = "/"
+	if !fi.Stat().IsDir() {
+		panic(fmt.Sprintf("unexpected type %T", fi))
+	}
 
+	a.Valid = attrValidity
+	a.Rdev = uint32(fi.Stat().Dev())
+	a.Inode = fi.Stat().Ino()
+	a.Mode = fi.Stat().Mode()
+	a.Uid = uint32(fi.Stat().Uid())
+	a.Gid = uint32(fi.Stat().Gid())
+	a.Ctime = fi.Stat().ModTime()
+	a.Mtime = fi.Stat().ModTime()
+	a.Size = uint64(fi.Stat().Size())
+
+	if d.parent == nil && d.fullpath == "/" {
+		// the root of a snapshot shows up under several names in the
+		// tree, it is dated and sized after the snapshot itself
 		a.Inode = rand.Uint64()
 		a.Mode = os.ModeDir | 0o700
 		a.Uid = uint32(os.Geteuid())
@@ -65,75 +118,71 @@ func (d *Dir) Attr(ctx context.Context, a *fuse.Attr) error {
 		a.Mtime = snap.Header.Timestamp
 		a.Atime = snap.Header.Timestamp
 		a.Size = snap.Header.GetSource(0).Summary.Directory.Size + snap.Header.GetSource(0).Summary.Below.Size
-	} else {
-		d.snap = d.parent.snap
-		d.repo = d.parent.repo
-		d.vfs = d.parent.vfs
-		d.fullpath = d.parent.fullpath + "/" + d.name
-
-		d.fullpath = filepath.Clean(d.fullpath)
-
-		fi, err := d.vfs.GetEntry(d.fullpath)
-		if err != nil {
-			return syscall.ENOENT
-		}
-
-		if !fi.Stat().IsDir() {
-			panic(fmt.Sprintf("unexpected type %T", fi))
-		}
-
-		a.Rdev = uint32(fi.Stat().Dev())
-		a.Inode = fi.Stat().Ino()
-		a.Mode = fi.Stat().Mode()
-		a.Uid = uint32(fi.Stat().Uid())
-		a.Gid = uint32(fi.Stat().Gid())
-		a.Ctime = fi.Stat().ModTime()
-		a.Mtime = fi.Stat().ModTime()
-		a.Size = uint64(fi.Stat().Size())
 	}
 	return nil
 }
 
 func (d *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
-	if d.name == "/" {
-		return &Dir{parent: d, name: name, repo: d.repo}, nil
-	} else if d.parent.name == "/" {
-		return &Dir{parent: d, name: name}, nil
+	snap, snapfs, _, err := d.open()
+	if err != nil {
+		return nil, err
+	}
+
+	// nodes are kept so their entries and attributes are resolved once
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if node, ok := d.children[name]; ok {
+		return node, nil
+	}
+
+	cleanpath := filepath.Clean(d.fullpath + "/" + name)
+	entry, err := snapfs.GetEntry(cleanpath)
+	if err != nil {
+		return nil, syscall.ENOENT
+	}
+
+	var node fs.Node
+	if entry.Stat().IsDir() {
+		node = &Dir{parent: d, name: name, fullpath: cleanpath, repo: d.repo,
+			snap: snap, vfs: snapfs, fsys: d.fsys, entry: entry}
 	} else {
-		cleanpath := filepath.Clean(d.fullpath + "/" + name)
-		entry, err := d.vfs.GetEntry(cleanpath)
-		if err != nil {
-			return nil, err
-		}
+		node = &File{parent: d, name: name, fullpath: cleanpath, repo: d.repo,
+			vfs: snapfs, entry: entry}
+	}
 
-		if entry.Stat().IsDir() {
-			return &Dir{parent: d, name: name}, nil
-		}
-		return &File{parent: d, name: name}, nil
+	if d.children == nil {
+		d.children = make(map[string]fs.Node)
 	}
+	d.children[name] = node
+	return node, nil
 }
 
-func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
-	if d.name == "/" {
+// Forget drops the node from the cache of its parent once the kernel no
+// longer references it, the next lookup resolves it again.  Snapshot roots
+// close their snapshot instead.
+func (d *Dir) Forget() {
+	if d.parent != nil {
+		d.parent.forget(d.name, d)
+	} else if d.snapshotID != (objects.MAC{}) {
+		d.fsys.forgetSnapshot(d)
+	}
+}
 
-		d.repo.RebuildState()
+func (d *Dir) forget(name string, node fs.Node) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.children[name] == node {
+		delete(d.children, name)
+	}
+}
 
-		snapshotIDs, err := d.repo.GetSnapshots()
-		if err != nil {
-			return nil, err
-		}
-		dirDirs := make([]fuse.Dirent, 0)
-		for idx, snapshotID := range snapshotIDs {
-			dirDirs = append(dirDirs, fuse.Dirent{
-				Inode: uint64(idx),
-				Name:  fmt.Sprintf("%x", snapshotID),
-				Type:  fuse.DT_Dir,
-			})
-		}
-		return dirDirs, nil
+func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	_, snapfs, _, err := d.open()
+	if err != nil {
+		return nil, err
 	}
 
-	children, err := d.vfs.Children(d.fullpath)
+	children, err := snapfs.Children(d.fullpath)
 	if err != nil {
 		return nil, err
 	}
@@ -5,40 +5,43 @@ package plakarfs
 import (
 	"context"
 	"fmt"
-	"io"
-	"path/filepath"
+	"sort"
 	"syscall"
 
+	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/resources"
 	"github.com/PlakarKorp/kloset/snapshot/vfs"
 	"github.com/anacrolix/fuse"
+	"github.com/anacrolix/fuse/fs"
 )
 
-// File implements both Node and Handle for the hello file.
+// File implements Node for regular files, reads are served by the
+// fileHandle returned by Open.
 type File struct {
 	parent   *Dir
 	name     string
 	fullpath string
 	repo     *repository.Repository
 	vfs      *vfs.Filesystem
-}
 
-func (f *File) Attr(ctx context.Context, a *fuse.Attr) error {
-	f.repo = f.parent.repo
-	f.vfs = f.parent.vfs
-	f.fullpath = f.parent.fullpath + "/" + f.name
+	// set at lookup time, snapshots being immutable
+	entry *vfs.Entry
+}
 
-	f.fullpath = filepath.Clean(f.fullpath)
+// Forget drops the node from the cache of its parent.
+func (f *File) Forget() {
+	f.parent.forget(f.name, f)
+}
 
-	entry, err := f.vfs.GetEntry(f.fullpath)
-	if err != nil {
-		return syscall.ENOENT
-	}
+func (f *File) Attr(ctx context.Context, a *fuse.Attr) error {
+	entry := f.entry
 
 	if entry.Stat().IsDir() {
 		panic(fmt.Sprintf("unexpected type %T", entry))
 	}
 
+	a.Valid = attrValidity
 	a.Rdev = uint32(entry.Stat().Dev())
 	a.Inode = entry.Stat().Ino()
 	a.Mode = entry.Stat().Mode()
@@ -51,10 +54,91 @@ func (f *File) Attr(ctx context.Context, a *fuse.Attr) error {
 	return nil
 }
 
-func (f *File) ReadAll(ctx context.Context) ([]byte, error) {
-	rd, err := f.parent.snap.NewReader(f.fullpath)
+func (f *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	var a fuse.Attr
+	if err := f.Attr(ctx, &a); err != nil {
+		return nil, err
+	}
+
+	// the content of a snapshot never changes
+	resp.Flags |= fuse.OpenKeepCache
+
+	h := &fileHandle{
+		repo:  f.repo,
+		cache: f.parent.fsys.chunks,
+	}
+	if f.entry.ResolvedObject != nil {
+		h.chunks = f.entry.ResolvedObject.Chunks
+		h.offsets = make([]int64, len(h.chunks)+1)
+		for i, chunk := range h.chunks {
+			h.offsets[i+1] = h.offsets[i] + int64(chunk.Length)
+		}
+	}
+	return h, nil
+}
+
+// fileHandle serves reads at arbitrary offsets by only fetching the chunks
+// covering the requested range.
+type fileHandle struct {
+	repo    *repository.Repository
+	cache   *chunkCache
+	chunks  []objects.Chunk
+	offsets []int64 // offsets[i] is where chunks[i] starts, the last one is the size
+}
+
+func (h *fileHandle) size() int64 {
+	if len(h.offsets) == 0 {
+		return 0
+	}
+	return h.offsets[len(h.offsets)-1]
+}
+
+func (h *fileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	off, end := req.Offset, req.Offset+int64(req.Size)
+	if end > h.size() {
+		end = h.size()
+	}
+	if off >= end {
+		resp.Data = resp.Data[:0]
+		return nil
+	}
+
+	data := make([]byte, 0, end-off)
+
+	// first chunk starting after off, the one before contains it
+	idx := sort.Search(len(h.chunks), func(i int) bool { return h.offsets[i+1] > off })
+	for ; idx < len(h.chunks) && h.offsets[idx] < end; idx++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		chunk, err := h.chunk(idx)
+		if err != nil {
+			return err
+		}
+
+		from := max(off, h.offsets[idx]) - h.offsets[idx]
+		to := min(end, h.offsets[idx+1]) - h.offsets[idx]
+		if to > int64(len(chunk)) {
+			return syscall.EIO
+		}
+		data = append(data, chunk[from:to]...)
+	}
+
+	resp.Data = data
+	return nil
+}
+
+func (h *fileHandle) chunk(idx int) ([]byte, error) {
+	mac := h.chunks[idx].ContentMAC
+	if data, ok := h.cache.Get(mac); ok {
+		return data, nil
+	}
+
+	data, err := h.repo.GetBlobBytes(resources.RT_CHUNK, mac)
 	if err != nil {
 		return nil, err
 	}
-	return io.ReadAll(rd)
+	h.cache.Put(mac, data)
+	return data, nil
 }
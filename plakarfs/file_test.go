@@ -0,0 +1,104 @@
+//go:build linux || darwin
+
+package plakarfs
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/anacrolix/fuse"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkCache(t *testing.T) {
+	cache := newChunkCache(10)
+
+	cache.Put(objects.MAC{1}, []byte("abcd"))
+	cache.Put(objects.MAC{2}, []byte("efgh"))
+	_, ok := cache.Get(objects.MAC{1})
+	require.True(t, ok)
+
+	// evicts the least recently used chunk, 2
+	cache.Put(objects.MAC{3}, []byte("ijkl"))
+	_, ok = cache.Get(objects.MAC{2})
+	require.False(t, ok)
+	data, ok := cache.Get(objects.MAC{1})
+	require.True(t, ok)
+	require.Equal(t, []byte("abcd"), data)
+
+	// too large to be cached at all
+	cache.Put(objects.MAC{4}, make([]byte, 11))
+	_, ok = cache.Get(objects.MAC{4})
+	require.False(t, ok)
+}
+
+func TestFileHandleRead(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	content := make([]byte, 4<<20)
+	rand.New(rand.NewSource(42)).Read(content)
+
+	repo, _ := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("big.bin", 0644, string(content)),
+	})
+	defer snap.Close()
+
+	pvfs, err := snap.Filesystem()
+	require.NoError(t, err)
+
+	var pathname string
+	for p, err := range pvfs.Pathnames() {
+		require.NoError(t, err)
+		if len(p) >= 8 && p[len(p)-8:] == "/big.bin" {
+			pathname = p
+		}
+	}
+	require.NotEmpty(t, pathname)
+
+	entry, err := pvfs.GetEntry(pathname)
+	require.NoError(t, err)
+	require.Greater(t, len(entry.ResolvedObject.Chunks), 1)
+
+	root := &Dir{name: "/", fsys: NewFS(repo, "")}
+	file := &File{
+		parent:   &Dir{parent: root, fsys: root.fsys, repo: repo, vfs: pvfs, fullpath: "/"},
+		name:     pathname[1:],
+		fullpath: pathname,
+		repo:     repo,
+		vfs:      pvfs,
+		entry:    entry,
+	}
+
+	var openResp fuse.OpenResponse
+	handle, err := file.Open(context.Background(), &fuse.OpenRequest{}, &openResp)
+	require.NoError(t, err)
+	reader := handle.(*fileHandle)
+
+	for _, tc := range []struct {
+		offset int64
+		size   int
+	}{
+		{0, 4096},
+		{int64(entry.ResolvedObject.Chunks[0].Length) - 10, 20}, // across a chunk boundary
+		{1 << 20, 128 << 10},
+		{int64(len(content)) - 100, 4096}, // short read at the end
+		{int64(len(content)) + 10, 4096},  // past the end
+	} {
+		var resp fuse.ReadResponse
+		err := reader.Read(context.Background(), &fuse.ReadRequest{Offset: tc.offset, Size: tc.size}, &resp)
+		require.NoError(t, err)
+
+		end := min(tc.offset+int64(tc.size), int64(len(content)))
+		if tc.offset >= end {
+			require.Empty(t, resp.Data)
+			continue
+		}
+		require.Equal(t, content[tc.offset:end], resp.Data)
+	}
+}
@@ -3,21 +3,201 @@
 package plakarfs
 
 import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/header"
+	"github.com/PlakarKorp/plakar/locate"
 	"github.com/anacrolix/fuse/fs"
 )
 
+// how long the kernel may cache the attributes of snapshot entries, which
+// never change
+const attrValidity = time.Hour
+
+// how long the list of snapshots is trusted before the repository state is
+// rebuilt to pick up new ones
+const catalogValidity = 10 * time.Second
+
 type FS struct {
-	repo *repository.Repository
+	repo   *repository.Repository
+	chunks *chunkCache
+
+	// snapshots shown in the tree, nil for all of them
+	filter *locate.LocateOptions
+
+	// mount a single snapshot, or a directory within it, as root
+	snapshotPath string
+	root         fs.Node
+
+	mu        sync.Mutex
+	headers   []*header.Header
+	loadedAt  time.Time
+	snapshots map[objects.MAC]*Dir
 }
 
 func NewFS(repo *repository.Repository, mountpoint string) *FS {
 	fs := &FS{
-		repo: repo,
+		repo:      repo,
+		chunks:    newChunkCache(DefaultChunkCacheSize),
+		snapshots: make(map[objects.MAC]*Dir),
 	}
 	return fs
 }
 
+// SetChunkCacheSize bounds the amount of chunk data kept in memory.
+func (f *FS) SetChunkCacheSize(size int64) {
+	f.chunks = newChunkCache(size)
+}
+
+// SetFilter restricts the tree to the snapshots matching opts.
+func (f *FS) SetFilter(opts *locate.LocateOptions) {
+	f.filter = opts
+}
+
+// SetSnapshotPath mounts the SNAPSHOT[:PATH] directory as root instead of
+// the whole repository tree.
+func (f *FS) SetSnapshotPath(snapshotPath string) {
+	f.snapshotPath = snapshotPath
+}
+
+// Root resolves the root of the mount once, it can be called ahead of
+// mounting to report an invalid snapshot path early.
 func (f *FS) Root() (fs.Node, error) {
-	return &Dir{name: "/", repo: f.repo}, nil
+	if f.root != nil {
+		return f.root, nil
+	}
+
+	if f.snapshotPath == "" {
+		f.root = f.rootDir()
+		return f.root, nil
+	}
+
+	snap, pathname, err := locate.OpenSnapshotByPath(f.repo, f.snapshotPath)
+	if err != nil {
+		return nil, err
+	}
+	snapfs, err := snap.Filesystem()
+	if err != nil {
+		snap.Close()
+		return nil, err
+	}
+	entry, err := snapfs.GetEntry(pathname)
+	if err != nil {
+		snap.Close()
+		return nil, err
+	}
+	if !entry.Stat().IsDir() {
+		snap.Close()
+		return nil, fmt.Errorf("%s: not a directory", pathname)
+	}
+
+	f.root = &Dir{
+		fullpath: pathname,
+		repo:     f.repo,
+		snap:     snap,
+		vfs:      snapfs,
+		fsys:     f,
+		entry:    entry,
+	}
+	return f.root, nil
+}
+
+// catalog returns the headers of the snapshots shown in the tree.
+func (f *FS) catalog() ([]*header.Header, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.headers != nil && time.Since(f.loadedAt) < catalogValidity {
+		return f.headers, nil
+	}
+
+	if err := f.repo.RebuildState(); err != nil {
+		return nil, err
+	}
+
+	snapshotIDs, err := f.repo.GetSnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[objects.MAC]*header.Header, len(f.headers))
+	for _, hdr := range f.headers {
+		known[hdr.Identifier] = hdr
+	}
+
+	headers := make([]*header.Header, 0, len(snapshotIDs))
+	for _, snapshotID := range snapshotIDs {
+		if hdr, ok := known[snapshotID]; ok {
+			headers = append(headers, hdr)
+			continue
+		}
+		snap, err := snapshot.Load(f.repo, snapshotID)
+		if err != nil {
+			// being deleted, or not fully written yet
+			continue
+		}
+		headers = append(headers, snap.Header)
+		snap.Close()
+	}
+
+	if f.filter != nil {
+		headers = locate.LocateHeaders(headers, f.filter)
+	}
+
+	f.headers = headers
+	f.loadedAt = time.Now()
+	return headers, nil
+}
+
+// snapshotDir returns the node for the root of a snapshot, shared by all the
+// places of the tree it appears in.
+func (f *FS) snapshotDir(snapshotID objects.MAC) *Dir {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if d, ok := f.snapshots[snapshotID]; ok {
+		return d
+	}
+	d := &Dir{
+		snapshotID: snapshotID,
+		fullpath:   "/",
+		repo:       f.repo,
+		fsys:       f,
+	}
+	f.snapshots[snapshotID] = d
+	return d
+}
+
+// forgetSnapshot closes the snapshot of a root the kernel no longer
+// references, the next lookup opens it again.
+func (f *FS) forgetSnapshot(d *Dir) {
+	f.mu.Lock()
+	if f.snapshots[d.snapshotID] == d {
+		delete(f.snapshots, d.snapshotID)
+	}
+	f.mu.Unlock()
+
+	d.close()
+}
+
+// Close releases the snapshots opened by the mount, it is called once the
+// filesystem is unmounted.
+func (f *FS) Close() error {
+	f.mu.Lock()
+	snapshots := f.snapshots
+	f.snapshots = make(map[objects.MAC]*Dir)
+	f.mu.Unlock()
+
+	for _, d := range snapshots {
+		d.close()
+	}
+	if root, ok := f.root.(*Dir); ok {
+		root.close()
+	}
+	return nil
 }
@@ -0,0 +1,261 @@
+//go:build linux || darwin
+
+package plakarfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/PlakarKorp/kloset/snapshot/header"
+	"github.com/PlakarKorp/plakar/locate"
+	"github.com/anacrolix/fuse"
+	"github.com/anacrolix/fuse/fs"
+)
+
+// The repository is mounted as a tree of virtual directories leading to the
+// snapshots, each of them reachable from several places:
+//
+//	snapshots/<snapshot id>/
+//	by-date/YYYY/MM/DD/<short id>/
+//	by-tag/<tag>/<short id>/
+//	by-job/<job>/<short id>/
+//	by-job/<job>/latest -> <short id>
+//
+// The layout is computed from the snapshot headers on every access, so new
+// snapshots show up as the catalog is refreshed.
+
+type virtualEntry struct {
+	name string
+	node fs.Node
+	typ  fuse.DirentType
+}
+
+// headerFilter selects the snapshots below a virtual directory.
+type headerFilter func(*header.Header) bool
+
+// virtualDir is a read-only directory whose entries are produced on demand
+// from the snapshots matching its filter.
+type virtualDir struct {
+	fsys    *FS
+	filter  headerFilter
+	entries func(filter headerFilter, headers []*header.Header) []virtualEntry
+}
+
+func (v *virtualDir) list() ([]virtualEntry, error) {
+	catalog, err := v.fsys.catalog()
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make([]*header.Header, 0, len(catalog))
+	for _, hdr := range catalog {
+		if v.filter == nil || v.filter(hdr) {
+			headers = append(headers, hdr)
+		}
+	}
+	return v.entries(v.filter, headers), nil
+}
+
+func (v *virtualDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = os.ModeDir | 0o700
+	a.Uid = uint32(os.Geteuid())
+	a.Gid = uint32(os.Getgid())
+	return nil
+}
+
+func (v *virtualDir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	entries, err := v.list()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.name == name {
+			return entry.node, nil
+		}
+	}
+	return nil, syscall.ENOENT
+}
+
+func (v *virtualDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	entries, err := v.list()
+	if err != nil {
+		return nil, err
+	}
+	dirents := make([]fuse.Dirent, 0, len(entries))
+	for _, entry := range entries {
+		dirents = append(dirents, fuse.Dirent{Name: entry.name, Type: entry.typ})
+	}
+	return dirents, nil
+}
+
+// symlink is a relative link between two places of the virtual tree.
+type symlink struct {
+	target string
+}
+
+func (s *symlink) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = os.ModeSymlink | 0o777
+	a.Uid = uint32(os.Geteuid())
+	a.Gid = uint32(os.Getgid())
+	a.Size = uint64(len(s.target))
+	return nil
+}
+
+func (s *symlink) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (string, error) {
+	return s.target, nil
+}
+
+func (f *FS) rootDir() *virtualDir {
+	return f.staticDir(
+		virtualEntry{name: "snapshots", node: f.virtualDir(f.snapshotsEntries), typ: fuse.DT_Dir},
+		virtualEntry{name: "by-date", node: f.virtualDir(f.yearEntries), typ: fuse.DT_Dir},
+		virtualEntry{name: "by-tag", node: f.virtualDir(f.tagEntries), typ: fuse.DT_Dir},
+		virtualEntry{name: "by-job", node: f.virtualDir(f.jobEntries), typ: fuse.DT_Dir},
+	)
+}
+
+func (f *FS) virtualDir(entries func(headerFilter, []*header.Header) []virtualEntry) *virtualDir {
+	return &virtualDir{fsys: f, entries: entries}
+}
+
+func (f *FS) staticDir(entries ...virtualEntry) *virtualDir {
+	return f.virtualDir(func(headerFilter, []*header.Header) []virtualEntry { return entries })
+}
+
+func shortID(hdr *header.Header) string {
+	return fmt.Sprintf("%x", hdr.GetIndexShortID())
+}
+
+// validName tells whether a tag or job name can be used as a directory name.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
+}
+
+func (f *FS) snapshotsEntries(_ headerFilter, headers []*header.Header) []virtualEntry {
+	entries := make([]virtualEntry, 0, len(headers))
+	for _, hdr := range headers {
+		entries = append(entries, virtualEntry{
+			name: fmt.Sprintf("%x", hdr.Identifier),
+			node: f.snapshotDir(hdr.Identifier),
+			typ:  fuse.DT_Dir,
+		})
+	}
+	return entries
+}
+
+// snapshotEntries lists headers by short id, oldest first.
+func (f *FS) snapshotEntries(headers []*header.Header) []virtualEntry {
+	headers = append([]*header.Header(nil), headers...)
+	sort.SliceStable(headers, func(i, j int) bool {
+		return headers[i].Timestamp.Before(headers[j].Timestamp)
+	})
+
+	entries := make([]virtualEntry, 0, len(headers))
+	for _, hdr := range headers {
+		entries = append(entries, virtualEntry{
+			name: shortID(hdr),
+			node: f.snapshotDir(hdr.Identifier),
+			typ:  fuse.DT_Dir,
+		})
+	}
+	return entries
+}
+
+// groupEntries builds one directory per distinct key, keys are sorted and
+// each directory lists the snapshots having that key on top of filter.
+func (f *FS) groupEntries(filter headerFilter, headers []*header.Header, keys func(*header.Header) []string,
+	contents func(key string, filter headerFilter, headers []*header.Header) []virtualEntry) []virtualEntry {
+	groups := make(map[string]bool)
+	for _, hdr := range headers {
+		for _, key := range keys(hdr) {
+			groups[key] = true
+		}
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	entries := make([]virtualEntry, 0, len(names))
+	for _, name := range names {
+		dir := f.virtualDir(func(filter headerFilter, headers []*header.Header) []virtualEntry {
+			return contents(name, filter, headers)
+		})
+		dir.filter = func(hdr *header.Header) bool {
+			if filter != nil && !filter(hdr) {
+				return false
+			}
+			for _, key := range keys(hdr) {
+				if key == name {
+					return true
+				}
+			}
+			return false
+		}
+		entries = append(entries, virtualEntry{name: name, node: dir, typ: fuse.DT_Dir})
+	}
+	return entries
+}
+
+func dateKey(format string) func(*header.Header) []string {
+	return func(hdr *header.Header) []string {
+		return []string{hdr.Timestamp.Local().Format(format)}
+	}
+}
+
+func (f *FS) yearEntries(filter headerFilter, headers []*header.Header) []virtualEntry {
+	return f.groupEntries(filter, headers, dateKey("2006"), func(_ string, filter headerFilter, headers []*header.Header) []virtualEntry {
+		return f.groupEntries(filter, headers, dateKey("01"), func(_ string, filter headerFilter, headers []*header.Header) []virtualEntry {
+			return f.groupEntries(filter, headers, dateKey("02"), func(_ string, _ headerFilter, headers []*header.Header) []virtualEntry {
+				return f.snapshotEntries(headers)
+			})
+		})
+	})
+}
+
+func (f *FS) tagEntries(filter headerFilter, headers []*header.Header) []virtualEntry {
+	tags := func(hdr *header.Header) []string {
+		keys := make([]string, 0, len(hdr.Tags))
+		for _, tag := range hdr.Tags {
+			if validName(tag) {
+				keys = append(keys, tag)
+			}
+		}
+		return keys
+	}
+	return f.groupEntries(filter, headers, tags, func(_ string, _ headerFilter, headers []*header.Header) []virtualEntry {
+		return f.snapshotEntries(headers)
+	})
+}
+
+func (f *FS) jobEntries(filter headerFilter, headers []*header.Header) []virtualEntry {
+	jobs := func(hdr *header.Header) []string {
+		if !validName(hdr.Job) {
+			return nil
+		}
+		return []string{hdr.Job}
+	}
+	return f.groupEntries(filter, headers, jobs, func(job string, _ headerFilter, headers []*header.Header) []virtualEntry {
+		entries := f.snapshotEntries(headers)
+
+		latest := locate.LocateHeaders(headers, &locate.LocateOptions{
+			Job:       job,
+			Latest:    true,
+			SortOrder: locate.LocateSortOrderDescending,
+		})
+		if len(latest) == 1 {
+			entries = append(entries, virtualEntry{
+				name: "latest",
+				node: &symlink{target: shortID(latest[0])},
+				typ:  fuse.DT_Link,
+			})
+		}
+		return entries
+	})
+}
@@ -0,0 +1,175 @@
+//go:build linux || darwin
+
+package plakarfs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/PlakarKorp/plakar/locate"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/anacrolix/fuse"
+	"github.com/anacrolix/fuse/fs"
+	"github.com/stretchr/testify/require"
+)
+
+func lookupPath(t *testing.T, node fs.Node, names ...string) fs.Node {
+	t.Helper()
+	for _, name := range names {
+		lookuper, ok := node.(fs.NodeStringLookuper)
+		require.True(t, ok, "%s: not a directory", name)
+		child, err := lookuper.Lookup(context.Background(), name)
+		require.NoError(t, err, name)
+		node = child
+	}
+	return node
+}
+
+func readDirNames(t *testing.T, node fs.Node) []string {
+	t.Helper()
+	dirents, err := node.(fs.HandleReadDirAller).ReadDirAll(context.Background())
+	require.NoError(t, err)
+	names := make([]string, 0, len(dirents))
+	for _, dirent := range dirents {
+		names = append(names, dirent.Name)
+	}
+	return names
+}
+
+func TestTree(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, _ := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	files := []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy"),
+	}
+	snap1 := ptesting.GenerateSnapshot(t, repo, files, ptesting.WithJob("daily"), ptesting.WithTags("foo"))
+	defer snap1.Close()
+	snap2 := ptesting.GenerateSnapshot(t, repo, files, ptesting.WithJob("daily"), ptesting.WithTags("foo", "bar"))
+	defer snap2.Close()
+	snap3 := ptesting.GenerateSnapshot(t, repo, files)
+	defer snap3.Close()
+
+	short := func(id []byte) string { return fmt.Sprintf("%x", id) }
+	id1, id2, id3 := short(snap1.Header.GetIndexShortID()), short(snap2.Header.GetIndexShortID()), short(snap3.Header.GetIndexShortID())
+
+	root, err := NewFS(repo, "").Root()
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"snapshots", "by-date", "by-tag", "by-job"}, readDirNames(t, root))
+	require.Len(t, readDirNames(t, lookupPath(t, root, "snapshots")), 3)
+
+	day := snap1.Header.Timestamp.Local()
+	byDate := lookupPath(t, root, "by-date", day.Format("2006"), day.Format("01"), day.Format("02"))
+	require.ElementsMatch(t, []string{id1, id2, id3}, readDirNames(t, byDate))
+
+	require.Equal(t, []string{"bar", "foo"}, readDirNames(t, lookupPath(t, root, "by-tag")))
+	require.Equal(t, []string{id2}, readDirNames(t, lookupPath(t, root, "by-tag", "bar")))
+	require.ElementsMatch(t, []string{id1, id2}, readDirNames(t, lookupPath(t, root, "by-tag", "foo")))
+
+	require.Equal(t, []string{"daily"}, readDirNames(t, lookupPath(t, root, "by-job")))
+	require.ElementsMatch(t, []string{id1, id2, "latest"}, readDirNames(t, lookupPath(t, root, "by-job", "daily")))
+
+	latest := lookupPath(t, root, "by-job", "daily", "latest")
+	target, err := latest.(fs.NodeReadlinker).Readlink(context.Background(), &fuse.ReadlinkRequest{})
+	require.NoError(t, err)
+	require.Equal(t, id2, target)
+
+	// the same snapshot root is shared by all the places it appears in
+	backupDir := snap2.Header.GetSource(0).Importer.Directory
+	dummy := lookupPath(t, root, "by-job", "daily", id2)
+	require.Same(t, lookupPath(t, root, "by-tag", "bar", id2), dummy)
+
+	var a fuse.Attr
+	dummy = lookupPath(t, dummy, backupDir[1:], "subdir", "dummy.txt")
+	require.NoError(t, dummy.Attr(context.Background(), &a))
+	require.Equal(t, uint64(len("hello dummy")), a.Size)
+
+	// nodes are cached until the kernel forgets them
+	subdir := lookupPath(t, root, "by-job", "daily", id2, backupDir[1:], "subdir")
+	require.Same(t, dummy, lookupPath(t, subdir, "dummy.txt"))
+	dummy.(fs.NodeForgetter).Forget()
+	require.Empty(t, subdir.(*Dir).children)
+	require.NotSame(t, dummy, lookupPath(t, subdir, "dummy.txt"))
+}
+
+func TestTreeFilter(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, _ := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	files := []ptesting.MockFile{
+		ptesting.NewMockFile("dummy.txt", 0644, "hello dummy"),
+	}
+	snap1 := ptesting.GenerateSnapshot(t, repo, files, ptesting.WithJob("daily"))
+	defer snap1.Close()
+	snap2 := ptesting.GenerateSnapshot(t, repo, files, ptesting.WithJob("weekly"))
+	defer snap2.Close()
+
+	fsys := NewFS(repo, "")
+	fsys.SetFilter(&locate.LocateOptions{Job: "weekly"})
+	root, err := fsys.Root()
+	require.NoError(t, err)
+
+	require.Equal(t, []string{fmt.Sprintf("%x", snap2.Header.Identifier)}, readDirNames(t, lookupPath(t, root, "snapshots")))
+	require.Equal(t, []string{"weekly"}, readDirNames(t, lookupPath(t, root, "by-job")))
+}
+
+func TestSnapshotPathRoot(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, _ := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy"),
+	})
+	defer snap.Close()
+
+	backupDir := snap.Header.GetSource(0).Importer.Directory
+	fsys := NewFS(repo, "")
+	fsys.SetSnapshotPath(fmt.Sprintf("%x:%s/subdir", snap.Header.GetIndexShortID(), backupDir))
+	root, err := fsys.Root()
+	require.NoError(t, err)
+	require.Equal(t, []string{"dummy.txt"}, readDirNames(t, root))
+
+	fsys = NewFS(repo, "")
+	fsys.SetSnapshotPath(fmt.Sprintf("%x:%s/subdir/dummy.txt", snap.Header.GetIndexShortID(), backupDir))
+	_, err = fsys.Root()
+	require.Error(t, err)
+}
+
+func TestSnapshotForget(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, _ := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("dummy.txt", 0644, "hello dummy"),
+	})
+	defer snap.Close()
+
+	id := fmt.Sprintf("%x", snap.Header.Identifier)
+	fsys := NewFS(repo, "")
+	root, err := fsys.Root()
+	require.NoError(t, err)
+
+	snapRoot := lookupPath(t, root, "snapshots", id).(*Dir)
+	require.Len(t, readDirNames(t, snapRoot), 1)
+	require.NotNil(t, snapRoot.snap)
+
+	// a forgotten root closes its snapshot and is opened again on lookup
+	snapRoot.Forget()
+	require.Nil(t, snapRoot.snap)
+	reopened := lookupPath(t, root, "snapshots", id).(*Dir)
+	require.NotSame(t, snapRoot, reopened)
+	require.Len(t, readDirNames(t, reopened), 1)
+
+	require.NoError(t, fsys.Close())
+	require.Nil(t, reopened.snap)
+	require.Empty(t, fsys.snapshots)
+}
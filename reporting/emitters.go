@@ -0,0 +1,145 @@
+package reporting
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/PlakarKorp/kloset/logging"
+)
+
+// EmitterConfig describes an emitter the reports are sent to, on top of the
+// hosted plakar API.  Which fields apply depends on the type:
+//
+//   - file: Path, the reports are appended to it one JSON object per line
+//   - webhook: URL, Method, Headers and Template, the payload, the report
+//     as JSON by default
+//   - smtp: Host, Username, Password, From, To, Subject and Template, the
+//     body of the mail
+//   - syslog: Network, Address and Tag, the local syslog by default
+//
+// Status restricts the reports sent to those of the given statuses, e.g.
+// [FAILURE, WARNING].  ID names the emitter in the outbox, see Name.
+type EmitterConfig struct {
+	Type   string `validate:"required,oneof=file webhook smtp syslog"`
+	ID     string
+	Status []string
+
+	Path string `validate:"required_if=Type file"`
+
+	URL      string `validate:"required_if=Type webhook"`
+	Method   string
+	Headers  map[string]string
+	Template string
+
+	Host     string `validate:"required_if=Type smtp"`
+	Username string
+	Password string
+	From     string   `validate:"required_if=Type smtp"`
+	To       []string `validate:"required_if=Type smtp"`
+	Subject  string
+
+	Network string
+	Address string
+	Tag     string
+}
+
+const (
+	defaultSubject = `plakar: {{.Task.Type}} {{.Task.Name}} {{.Task.Status}}`
+	defaultBody    = `Task:       {{.Task.Type}} {{.Task.Name}}
+Status:     {{.Task.Status}}{{with .Task.ErrorMessage}}
+Error:      {{.}}{{end}}
+Started:    {{.Task.StartTime.UTC.Format "2006-01-02T15:04:05Z07:00"}}
+Duration:   {{.Task.Duration}}{{with .Repository}}
+Repository: {{.Name}}{{end}}{{with .Snapshot}}
+Snapshot:   {{printf "%x" .Identifier}}{{end}}
+`
+)
+
+var templateFuncs = template.FuncMap{
+	// json lets templated JSON payloads embed values safely, e.g.
+	// {"text": {{json .Task.ErrorMessage}}}
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+func parseTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s template: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+func render(tmpl *template.Template, report Report) (string, error) {
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, report); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// Name identifies the emitter in the outbox, by its ID if set or else by its
+// position in the configuration, so that the reports queued for it are not
+// left behind when its settings change.
+func (config EmitterConfig) Name(position int) string {
+	if config.ID != "" {
+		return "id-" + config.ID
+	}
+	return fmt.Sprintf("%d-%s", position, config.Type)
+}
+
+// NewEmitter creates the emitter described by config.
+func NewEmitter(config EmitterConfig) (Emitter, error) {
+	var emitter Emitter
+	var err error
+
+	switch config.Type {
+	case "file":
+		emitter = NewFileEmitter(config.Path)
+	case "webhook":
+		emitter, err = NewWebhookEmitter(config.URL, config.Method, config.Headers, config.Template)
+	case "smtp":
+		emitter, err = NewSMTPEmitter(config.Host, config.Username, config.Password, config.From, config.To, config.Subject, config.Template)
+	case "syslog":
+		emitter, err = NewSyslogEmitter(config.Network, config.Address, config.Tag)
+	default:
+		err = fmt.Errorf("unknown emitter type %q", config.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(config.Status) != 0 {
+		statuses := make(map[TaskStatus]struct{})
+		for _, status := range config.Status {
+			status := TaskStatus(strings.ToUpper(status))
+			switch status {
+			case StatusOK, StatusWarning, StatusFailed:
+				statuses[status] = struct{}{}
+			default:
+				return nil, fmt.Errorf("invalid status %q; must be one of: %s, %s, %s",
+					status, StatusOK, StatusWarning, StatusFailed)
+			}
+		}
+		emitter = &filterEmitter{emitter: emitter, statuses: statuses}
+	}
+	return emitter, nil
+}
+
+type filterEmitter struct {
+	emitter  Emitter
+	statuses map[TaskStatus]struct{}
+}
+
+func (emitter *filterEmitter) Emit(report Report, logger *logging.Logger) {
+	if report.Task != nil {
+		if _, ok := emitter.statuses[report.Task.Status]; !ok {
+			return
+		}
+	}
+	emitter.emitter.Emit(report, logger)
+}
@@ -0,0 +1,162 @@
+package reporting
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/logging"
+	"github.com/stretchr/testify/require"
+)
+
+func testReport(status TaskStatus, message string) Report {
+	return Report{
+		Timestamp: time.Now(),
+		Task: &ReportTask{
+			Type:         "backup",
+			Name:         "db",
+			StartTime:    time.Date(2025, 7, 3, 12, 0, 0, 0, time.UTC),
+			Duration:     time.Minute,
+			Status:       status,
+			ErrorMessage: message,
+		},
+		Repository: &ReportRepository{Name: "/var/backups"},
+	}
+}
+
+func TestFileEmitter(t *testing.T) {
+	logger := logging.NewLogger(io.Discard, io.Discard)
+	path := filepath.Join(t.TempDir(), "reports.jsonl")
+
+	emitter, err := NewEmitter(EmitterConfig{Type: "file", Path: path})
+	require.NoError(t, err)
+
+	emitter.Emit(testReport(StatusOK, ""), logger)
+	emitter.Emit(testReport(StatusFailed, "boom"), logger)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+
+	var report Report
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &report))
+	require.Equal(t, StatusFailed, report.Task.Status)
+	require.Equal(t, "boom", report.Task.ErrorMessage)
+}
+
+func TestWebhookEmitter(t *testing.T) {
+	logger := logging.NewLogger(io.Discard, io.Discard)
+
+	var requests []*http.Request
+	var bodies []string
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, r)
+		bodies = append(bodies, string(body))
+	}))
+	defer server.Close()
+
+	emitter, err := NewWebhookEmitter(server.URL, "", map[string]string{"X-Token": "secret"},
+		`{"text": {{json (printf "%s %s: %s" .Task.Name .Task.Status .Task.ErrorMessage)}}}`)
+	require.NoError(t, err)
+	emitter.backoff = time.Millisecond
+
+	emitter.Emit(testReport(StatusFailed, `disk "full"`), logger)
+
+	require.Equal(t, 2, attempts)
+	require.Len(t, requests, 1)
+	require.Equal(t, http.MethodPost, requests[0].Method)
+	require.Equal(t, "secret", requests[0].Header.Get("X-Token"))
+	require.JSONEq(t, `{"text": "db FAILURE: disk \"full\""}`, bodies[0])
+}
+
+func TestWebhookEmitterJSON(t *testing.T) {
+	logger := logging.NewLogger(io.Discard, io.Discard)
+
+	var report Report
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&report)
+	}))
+	defer server.Close()
+
+	emitter, err := NewWebhookEmitter(server.URL, http.MethodPut, nil, "")
+	require.NoError(t, err)
+
+	emitter.Emit(testReport(StatusWarning, "skipped files"), logger)
+	require.Equal(t, StatusWarning, report.Task.Status)
+	require.Equal(t, "/var/backups", report.Repository.Name)
+}
+
+func TestSMTPEmitter(t *testing.T) {
+	logger := logging.NewLogger(io.Discard, io.Discard)
+
+	emitter, err := NewSMTPEmitter("mail.example.com", "alice", "pass", "plakar@example.com",
+		[]string{"ops@example.com", "oncall@example.com"}, "", "")
+	require.NoError(t, err)
+	require.Equal(t, "mail.example.com:587", emitter.addr)
+	require.NotNil(t, emitter.auth)
+
+	var addr, from string
+	var to []string
+	var msg []byte
+	emitter.sendMail = func(a string, _ smtp.Auth, f string, t []string, m []byte) error {
+		addr, from, to, msg = a, f, t, m
+		return nil
+	}
+
+	emitter.Emit(testReport(StatusFailed, "boom"), logger)
+
+	require.Equal(t, "mail.example.com:587", addr)
+	require.Equal(t, "plakar@example.com", from)
+	require.Equal(t, []string{"ops@example.com", "oncall@example.com"}, to)
+	require.Contains(t, string(msg), "Subject: plakar: backup db FAILURE\r\n")
+	require.Contains(t, string(msg), "To: ops@example.com, oncall@example.com\r\n")
+	require.Contains(t, string(msg), "Error:      boom\r\n")
+	require.Contains(t, string(msg), "Repository: /var/backups\r\n")
+}
+
+func TestFilterEmitter(t *testing.T) {
+	logger := logging.NewLogger(io.Discard, io.Discard)
+	path := filepath.Join(t.TempDir(), "reports.jsonl")
+
+	emitter, err := NewEmitter(EmitterConfig{Type: "file", Path: path, Status: []string{"failure", "WARNING"}})
+	require.NoError(t, err)
+
+	emitter.Emit(testReport(StatusOK, ""), logger)
+	emitter.Emit(testReport(StatusWarning, "w"), logger)
+	emitter.Emit(testReport(StatusFailed, "f"), logger)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, 2, bytes.Count(data, []byte("\n")))
+}
+
+func TestNewEmitterErrors(t *testing.T) {
+	_, err := NewEmitter(EmitterConfig{Type: "file", Path: "/tmp/x", Status: []string{"broken"}})
+	require.ErrorContains(t, err, `invalid status "BROKEN"`)
+
+	_, err = NewEmitter(EmitterConfig{Type: "webhook", URL: "http://localhost", Template: "{{.Task"})
+	require.ErrorContains(t, err, "invalid payload template")
+
+	_, err = NewEmitter(EmitterConfig{Type: "smtp", Host: "localhost", From: "a@b", To: []string{"c@d"}, Subject: "{{"})
+	require.ErrorContains(t, err, "invalid subject template")
+
+	_, err = NewEmitter(EmitterConfig{Type: "pigeon"})
+	require.ErrorContains(t, err, `unknown emitter type "pigeon"`)
+}
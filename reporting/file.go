@@ -0,0 +1,46 @@
+package reporting
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/PlakarKorp/kloset/logging"
+)
+
+// FileEmitter appends the reports to a file, one JSON object per line.
+type FileEmitter struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileEmitter(path string) *FileEmitter {
+	return &FileEmitter{path: path}
+}
+
+func (emitter *FileEmitter) Emit(report Report, logger *logging.Logger) {
+	if err := emitter.Send(report); err != nil {
+		logger.Error("failed to emit report: %s", err)
+	}
+}
+
+func (emitter *FileEmitter) Send(report Report) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	emitter.mu.Lock()
+	defer emitter.mu.Unlock()
+
+	fp, err := os.OpenFile(emitter.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fp.Write(data); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
 	"runtime"
 	"time"
 
@@ -18,16 +19,25 @@ type HttpEmitter struct {
 	retry uint8
 }
 
-func (emitter *HttpEmitter) Emit(report Report, logger *logging.Logger) {
-	data, err := json.Marshal(report)
-	if err != nil {
-		logger.Error("failed to encode report: %s", err)
-		return
+// NewHttpEmitter creates an emitter sending the reports to the plakar API,
+// at $PLAKAR_API_URL if set.
+func NewHttpEmitter(token string) *HttpEmitter {
+	url := os.Getenv("PLAKAR_API_URL")
+	if url == "" {
+		url = PLAKAR_API_URL
 	}
 
+	return &HttpEmitter{
+		url:   url,
+		token: token,
+		retry: 3,
+	}
+}
+
+func (emitter *HttpEmitter) Emit(report Report, logger *logging.Logger) {
 	backoffUnit := time.Minute
 	for i := range emitter.retry {
-		err := emitter.tryEmit(data)
+		err := emitter.Send(report)
 		if err == nil {
 			return
 		}
@@ -37,7 +47,12 @@ func (emitter *HttpEmitter) Emit(report Report, logger *logging.Logger) {
 	logger.Error("failed to emit report after %d attempts", emitter.retry)
 }
 
-func (reporter *HttpEmitter) tryEmit(data []byte) error {
+func (reporter *HttpEmitter) Send(report Report) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
 	req, err := http.NewRequest("POST", reporter.url, bytes.NewReader(data))
 	if err != nil {
 		return err
@@ -47,8 +62,11 @@ func (reporter *HttpEmitter) tryEmit(data []byte) error {
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", reporter.token))
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if report.ID != "" {
+		req.Header.Set("Idempotency-Key", report.ID)
+	}
 
-	client := http.Client{}
+	client := http.Client{Timeout: time.Minute}
 	res, err := client.Do(req)
 	if err != nil {
 		return err
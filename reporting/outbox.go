@@ -0,0 +1,301 @@
+package reporting
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/kloset/logging"
+	"github.com/google/uuid"
+)
+
+const OUTBOX_VERSION = "1.0.0"
+
+// Sender is an emitter that tells whether a report was delivered, so that
+// an Outbox can retry it later.
+type Sender interface {
+	Send(report Report) error
+}
+
+// queue is implemented by the emitters that persist the reports instead of
+// sending them, the reporter waits for them.
+type queue interface {
+	Enqueue(report Report) error
+}
+
+// the identifiers are time-ordered so that the outbox replays the reports
+// in the order they were produced
+func newReportID() string {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return uuid.NewString()
+	}
+	return id.String()
+}
+
+type outboxEntry struct {
+	Report      Report    `json:"report"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// Outbox keeps the reports on disk until they are delivered, one file per
+// report and per destination.  Failed deliveries are retried with an
+// exponential backoff, and the reports still pending when the agent exits
+// are replayed when it starts again.  Reports are identified by their ID so
+// that one is never queued twice for a destination, and receivers can use
+// it to discard a report delivered twice.
+type Outbox struct {
+	ctx    context.Context
+	dir    string
+	logger *logging.Logger
+
+	backoff    time.Duration
+	maxBackoff time.Duration
+	maxAge     time.Duration
+
+	mu           sync.Mutex
+	destinations map[string]*destination
+}
+
+type destination struct {
+	name   string
+	dir    string
+	wakeup chan struct{}
+
+	mu     sync.Mutex
+	sender Sender
+}
+
+func (d *destination) getSender() Sender {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.sender
+}
+
+// NewOutbox creates the outbox in cacheDir, deliveries stop when ctx is
+// done.
+func NewOutbox(ctx context.Context, cacheDir string, logger *logging.Logger) (*Outbox, error) {
+	dir := filepath.Join(cacheDir, "reporting", OUTBOX_VERSION, "outbox")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("cannot create outbox directory: %w", err)
+	}
+	return &Outbox{
+		ctx:          ctx,
+		dir:          dir,
+		logger:       logger,
+		backoff:      time.Minute,
+		maxBackoff:   time.Hour,
+		maxAge:       7 * 24 * time.Hour,
+		destinations: make(map[string]*destination),
+	}, nil
+}
+
+// Emitter returns an emitter queueing the reports in the outbox for the
+// destination identified by name, from which they are sent by emitter.
+// The reports left pending for that destination by a previous run are
+// replayed.  Emitters that cannot tell whether a report was delivered are
+// returned as is.
+func (outbox *Outbox) Emitter(name string, emitter Emitter) Emitter {
+	var statuses map[TaskStatus]struct{}
+	if filter, ok := emitter.(*filterEmitter); ok {
+		statuses = filter.statuses
+		emitter = filter.emitter
+	}
+
+	sender, ok := emitter.(Sender)
+	if !ok {
+		if statuses != nil {
+			return &filterEmitter{emitter: emitter, statuses: statuses}
+		}
+		return emitter
+	}
+
+	outbox.mu.Lock()
+	defer outbox.mu.Unlock()
+
+	d, ok := outbox.destinations[name]
+	if ok {
+		// e.g. the auth token changed
+		d.mu.Lock()
+		d.sender = sender
+		d.mu.Unlock()
+	} else {
+		d = &destination{
+			name:   name,
+			dir:    filepath.Join(outbox.dir, name),
+			wakeup: make(chan struct{}, 1),
+			sender: sender,
+		}
+		if err := os.MkdirAll(d.dir, 0700); err != nil {
+			outbox.logger.Warn("outbox: cannot create directory for %s, reports will not be retried: %s", name, err)
+			return emitter
+		}
+		outbox.destinations[name] = d
+		go outbox.deliver(d)
+	}
+
+	return &outboxEmitter{
+		outbox:      outbox,
+		destination: d,
+		statuses:    statuses,
+	}
+}
+
+type outboxEmitter struct {
+	outbox      *Outbox
+	destination *destination
+	statuses    map[TaskStatus]struct{}
+}
+
+func (emitter *outboxEmitter) Emit(report Report, logger *logging.Logger) {
+	if err := emitter.Enqueue(report); err != nil {
+		logger.Error("failed to queue report for %s: %s", emitter.destination.name, err)
+	}
+}
+
+func (emitter *outboxEmitter) Enqueue(report Report) error {
+	if emitter.statuses != nil && report.Task != nil {
+		if _, ok := emitter.statuses[report.Task.Status]; !ok {
+			return nil
+		}
+	}
+	if report.ID == "" {
+		report.ID = newReportID()
+	}
+
+	d := emitter.destination
+	path := filepath.Join(d.dir, report.ID+".json")
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	entry := outboxEntry{Report: report, NextAttempt: time.Now()}
+	if err := writeEntry(path, &entry); err != nil {
+		return err
+	}
+
+	select {
+	case d.wakeup <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func writeEntry(path string, entry *outboxEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func readEntry(path string) (*outboxEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entry outboxEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (outbox *Outbox) delay(attempts int) time.Duration {
+	delay := outbox.backoff
+	for i := 1; i < attempts && delay < outbox.maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, outbox.maxBackoff)
+}
+
+func (outbox *Outbox) deliver(d *destination) {
+	for {
+		var timer <-chan time.Time
+		if next := outbox.flush(d); !next.IsZero() {
+			timer = time.After(time.Until(next))
+		}
+
+		select {
+		case <-outbox.ctx.Done():
+			return
+		case <-d.wakeup:
+		case <-timer:
+		}
+	}
+}
+
+// flush sends the reports of d that are due, oldest first, and returns when
+// the next pending one is.
+func (outbox *Outbox) flush(d *destination) time.Time {
+	dirents, err := os.ReadDir(d.dir)
+	if err != nil {
+		outbox.logger.Warn("outbox: cannot read reports for %s: %s", d.name, err)
+		return time.Now().Add(outbox.backoff)
+	}
+
+	var next time.Time
+	for _, dirent := range dirents {
+		if outbox.ctx.Err() != nil {
+			return time.Time{}
+		}
+		if !strings.HasSuffix(dirent.Name(), ".json") {
+			continue
+		}
+
+		path := filepath.Join(d.dir, dirent.Name())
+		entry, err := readEntry(path)
+		if err != nil {
+			outbox.logger.Warn("outbox: dropping unreadable report %s: %s", path, err)
+			os.Remove(path)
+			continue
+		}
+
+		if time.Now().Before(entry.NextAttempt) {
+			if next.IsZero() || entry.NextAttempt.Before(next) {
+				next = entry.NextAttempt
+			}
+			continue
+		}
+
+		err = d.getSender().Send(entry.Report)
+		if err == nil {
+			if err := os.Remove(path); err != nil {
+				outbox.logger.Warn("outbox: cannot remove delivered report %s: %s", path, err)
+			}
+			continue
+		}
+
+		entry.Attempts++
+		entry.LastError = err.Error()
+		if time.Since(entry.Report.Timestamp) > outbox.maxAge {
+			outbox.logger.Error("outbox: dropping report %s for %s after %d attempts: %s",
+				entry.Report.ID, d.name, entry.Attempts, err)
+			os.Remove(path)
+			continue
+		}
+
+		entry.NextAttempt = time.Now().Add(outbox.delay(entry.Attempts))
+		outbox.logger.Warn("outbox: failed to emit report %s to %s, attempt %d, retrying at %s: %s",
+			entry.Report.ID, d.name, entry.Attempts, entry.NextAttempt.Format(time.RFC3339), err)
+		if err := writeEntry(path, entry); err != nil {
+			outbox.logger.Warn("outbox: cannot update report %s: %s", path, err)
+		}
+		if next.IsZero() || entry.NextAttempt.Before(next) {
+			next = entry.NextAttempt
+		}
+	}
+	return next
+}
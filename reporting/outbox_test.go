@@ -0,0 +1,165 @@
+package reporting
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/logging"
+	"github.com/stretchr/testify/require"
+)
+
+type testSender struct {
+	mu      sync.Mutex
+	fail    int
+	block   chan struct{}
+	reports []Report
+}
+
+func (sender *testSender) Emit(report Report, logger *logging.Logger) {
+	sender.Send(report)
+}
+
+func (sender *testSender) Send(report Report) error {
+	if sender.block != nil {
+		<-sender.block
+	}
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+	if sender.fail > 0 {
+		sender.fail--
+		return errors.New("connection refused")
+	}
+	sender.reports = append(sender.reports, report)
+	return nil
+}
+
+func (sender *testSender) delivered() []Report {
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+	return append([]Report(nil), sender.reports...)
+}
+
+func newTestOutbox(t *testing.T, ctx context.Context, cacheDir string) *Outbox {
+	outbox, err := NewOutbox(ctx, cacheDir, logging.NewLogger(io.Discard, io.Discard))
+	require.NoError(t, err)
+	outbox.backoff = time.Millisecond
+	outbox.maxBackoff = 10 * time.Millisecond
+	return outbox
+}
+
+func pending(t *testing.T, outbox *Outbox, name string) int {
+	matches, err := filepath.Glob(filepath.Join(outbox.dir, name, "*.json"))
+	require.NoError(t, err)
+	return len(matches)
+}
+
+func TestOutboxRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger := logging.NewLogger(io.Discard, io.Discard)
+	outbox := newTestOutbox(t, ctx, t.TempDir())
+	sender := &testSender{fail: 3}
+	emitter := outbox.Emitter("test", sender)
+
+	report := testReport(StatusFailed, "boom")
+	report.ID = newReportID()
+	emitter.Emit(report, logger)
+	// queued twice, delivered once
+	emitter.Emit(report, logger)
+
+	require.Eventually(t, func() bool { return len(sender.delivered()) == 1 }, 5*time.Second, time.Millisecond)
+	require.Equal(t, report.ID, sender.delivered()[0].ID)
+	require.Eventually(t, func() bool { return pending(t, outbox, "test") == 0 }, 5*time.Second, time.Millisecond)
+}
+
+func TestOutboxReplay(t *testing.T) {
+	cacheDir := t.TempDir()
+	logger := logging.NewLogger(io.Discard, io.Discard)
+
+	// the agent exits while the reports cannot be delivered
+	ctx, cancel := context.WithCancel(context.Background())
+	outbox := newTestOutbox(t, ctx, cacheDir)
+	emitter := outbox.Emitter("test", &testSender{fail: 1000})
+	emitter.Emit(testReport(StatusFailed, "first"), logger)
+	emitter.Emit(testReport(StatusOK, ""), logger)
+	require.Equal(t, 2, pending(t, outbox, "test"))
+	cancel()
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	outbox = newTestOutbox(t, ctx, cacheDir)
+	sender := &testSender{}
+	outbox.Emitter("test", sender)
+
+	require.Eventually(t, func() bool { return len(sender.delivered()) == 2 }, 5*time.Second, time.Millisecond)
+	require.Equal(t, "first", sender.delivered()[0].Task.ErrorMessage)
+}
+
+func TestOutboxReporter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger := logging.NewLogger(io.Discard, io.Discard)
+	outbox := newTestOutbox(t, ctx, t.TempDir())
+	sender := &testSender{block: make(chan struct{})}
+	defer close(sender.block)
+
+	reporter := NewReporter(nil, false, nil, logger)
+	reporter.AddEmitter(outbox.Emitter("test", sender))
+	reporter.TaskStart("backup", "db")
+	reporter.TaskFailed(1, "boom")
+
+	// on disk as soon as the task ends
+	require.Equal(t, 1, pending(t, outbox, "test"))
+}
+
+func TestOutboxFilter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger := logging.NewLogger(io.Discard, io.Discard)
+	outbox := newTestOutbox(t, ctx, t.TempDir())
+	sender := &testSender{}
+	emitter := outbox.Emitter("test", &filterEmitter{
+		emitter:  sender,
+		statuses: map[TaskStatus]struct{}{StatusFailed: {}},
+	})
+
+	emitter.Emit(testReport(StatusOK, ""), logger)
+	emitter.Emit(testReport(StatusFailed, "boom"), logger)
+
+	require.Eventually(t, func() bool { return len(sender.delivered()) == 1 }, 5*time.Second, time.Millisecond)
+	require.Equal(t, StatusFailed, sender.delivered()[0].Task.Status)
+}
+
+func TestOutboxExpire(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger := logging.NewLogger(io.Discard, io.Discard)
+	outbox := newTestOutbox(t, ctx, t.TempDir())
+	outbox.maxAge = time.Hour
+	sender := &testSender{fail: 1}
+
+	report := testReport(StatusFailed, "boom")
+	report.Timestamp = time.Now().Add(-2 * time.Hour)
+	outbox.Emitter("test", sender).Emit(report, logger)
+
+	require.Eventually(t, func() bool { return pending(t, outbox, "test") == 0 }, 5*time.Second, time.Millisecond)
+	require.Empty(t, sender.delivered())
+}
+
+func TestOutboxDelay(t *testing.T) {
+	outbox := &Outbox{backoff: time.Minute, maxBackoff: time.Hour}
+	require.Equal(t, time.Minute, outbox.delay(1))
+	require.Equal(t, 2*time.Minute, outbox.delay(2))
+	require.Equal(t, 32*time.Minute, outbox.delay(6))
+	require.Equal(t, time.Hour, outbox.delay(7))
+	require.Equal(t, time.Hour, outbox.delay(1000))
+}
@@ -33,9 +33,20 @@ type ReportTask struct {
 	Status       TaskStatus    `json:"status"`
 	ErrorCode    TaskErrorCode `json:"error_code"`
 	ErrorMessage string        `json:"error_message"`
+	Hooks        []ReportHook  `json:"hooks,omitempty"`
+}
+
+type ReportHook struct {
+	Name     string        `json:"name"`
+	Command  string        `json:"command"`
+	ExitCode int           `json:"exit_code"`
+	Duration time.Duration `json:"duration"`
+	Output   string        `json:"output"`
+	Error    string        `json:"error,omitempty"`
 }
 
 type Report struct {
+	ID         string            `json:"id"`
 	Timestamp  time.Time         `json:"timestamp"`
 	Task       *ReportTask       `json:"report_task,omitempty"`
 	Repository *ReportRepository `json:"report_repository,omitempty"`
@@ -21,7 +21,7 @@ type Emitter interface {
 type Reporter struct {
 	repository        *repository.Repository
 	logger            *logging.Logger
-	emitter           Emitter
+	emitters          []Emitter
 	currentTask       *ReportTask
 	currentRepository *ReportRepository
 	currentSnapshot   *ReportSnapshot
@@ -37,33 +37,26 @@ func NewReporter(ctx *appcontext.AppContext, reporting bool, repository *reposit
 	if !reporting {
 		emitter = &NullEmitter{}
 	} else {
-
-		url := os.Getenv("PLAKAR_API_URL")
-		if url == "" {
-			url = PLAKAR_API_URL
-		}
-
-		var token string
-
 		token, err := ctx.GetCookies().GetAuthToken()
 		if err != nil {
 			logger.Warn("cannot get auth token")
 		}
 
-		emitter = &HttpEmitter{
-			url:   url,
-			token: token,
-			retry: 3,
-		}
+		emitter = NewHttpEmitter(token)
 	}
 
 	return &Reporter{
 		repository: repository,
 		logger:     logger,
-		emitter:    emitter,
+		emitters:   []Emitter{emitter},
 	}
 }
 
+// AddEmitter makes the reporter send its reports to emitter as well.
+func (reporter *Reporter) AddEmitter(emitter Emitter) {
+	reporter.emitters = append(reporter.emitters, emitter)
+}
+
 func (reporter *Reporter) TaskStart(kind string, name string) {
 	if reporter.currentTask != nil {
 		reporter.logger.Warn("already in a task")
@@ -110,6 +103,14 @@ func (reporter *Reporter) WithSnapshot(snapshot *snapshot.Snapshot) {
 	}
 }
 
+func (reporter *Reporter) WithHook(hook ReportHook) {
+	if reporter.currentTask == nil {
+		reporter.logger.Warn("not in a task")
+		return
+	}
+	reporter.currentTask.Hooks = append(reporter.currentTask.Hooks, hook)
+}
+
 func (reporter *Reporter) TaskDone() {
 	reporter.taskEnd(StatusOK, 0, "")
 }
@@ -133,6 +134,7 @@ func (reporter *Reporter) taskEnd(status TaskStatus, errorCode TaskErrorCode, er
 	reporter.currentTask.Duration = time.Since(reporter.currentTask.StartTime)
 
 	report := Report{
+		ID:         newReportID(),
 		Timestamp:  time.Now(),
 		Task:       reporter.currentTask,
 		Repository: reporter.currentRepository,
@@ -142,5 +144,12 @@ func (reporter *Reporter) taskEnd(status TaskStatus, errorCode TaskErrorCode, er
 	reporter.currentTask = nil
 	reporter.currentRepository = nil
 	reporter.currentSnapshot = nil
-	go reporter.emitter.Emit(report, reporter.logger)
+	for _, emitter := range reporter.emitters {
+		if _, ok := emitter.(queue); ok {
+			// persisted before returning so that an exit does not lose it
+			emitter.Emit(report, reporter.logger)
+		} else {
+			go emitter.Emit(report, reporter.logger)
+		}
+	}
 }
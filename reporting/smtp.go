@@ -0,0 +1,92 @@
+package reporting
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/PlakarKorp/kloset/logging"
+)
+
+// SMTPEmitter mails the reports.
+type SMTPEmitter struct {
+	addr     string
+	auth     smtp.Auth
+	from     string
+	to       []string
+	subject  *template.Template
+	body     *template.Template
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// NewSMTPEmitter creates an emitter mailing the reports through the server
+// at host, given as host:port with port 587 by default.  Subject and body
+// are templates, with sensible defaults if empty.
+func NewSMTPEmitter(host, username, password, from string, to []string, subject, body string) (*SMTPEmitter, error) {
+	addr := host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		addr = net.JoinHostPort(host, "587")
+	}
+
+	if subject == "" {
+		subject = defaultSubject
+	}
+	if body == "" {
+		body = defaultBody
+	}
+
+	emitter := &SMTPEmitter{
+		addr:     addr,
+		from:     from,
+		to:       to,
+		sendMail: smtp.SendMail,
+	}
+	if username != "" {
+		hostname, _, _ := net.SplitHostPort(addr)
+		emitter.auth = smtp.PlainAuth("", username, password, hostname)
+	}
+
+	var err error
+	if emitter.subject, err = parseTemplate("subject", subject); err != nil {
+		return nil, err
+	}
+	if emitter.body, err = parseTemplate("body", body); err != nil {
+		return nil, err
+	}
+	return emitter, nil
+}
+
+func (emitter *SMTPEmitter) Emit(report Report, logger *logging.Logger) {
+	if err := emitter.Send(report); err != nil {
+		logger.Error("failed to emit report to %s: %s", emitter.addr, err)
+	}
+}
+
+func (emitter *SMTPEmitter) Send(report Report) error {
+	subject, err := render(emitter.subject, report)
+	if err != nil {
+		return err
+	}
+	body, err := render(emitter.body, report)
+	if err != nil {
+		return err
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", emitter.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(emitter.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	if report.ID != "" {
+		fmt.Fprintf(&msg, "Message-ID: <%s@plakar>\r\n", report.ID)
+	}
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&msg, "\r\n")
+	msg.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+
+	return emitter.sendMail(emitter.addr, emitter.auth, emitter.from, emitter.to, []byte(msg.String()))
+}
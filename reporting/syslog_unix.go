@@ -0,0 +1,90 @@
+//go:build !windows
+
+package reporting
+
+import (
+	"fmt"
+	"log/syslog"
+	"sync"
+
+	"github.com/PlakarKorp/kloset/logging"
+)
+
+// SyslogEmitter logs a line per report to syslog, at err priority for the
+// failures, warning for the warnings and info otherwise.
+type SyslogEmitter struct {
+	network string
+	address string
+	tag     string
+
+	mu     sync.Mutex
+	writer *syslog.Writer
+}
+
+// NewSyslogEmitter creates an emitter logging to the syslog server at
+// address, or to the local one if empty.  The connection is established
+// when the first report is emitted.
+func NewSyslogEmitter(network, address, tag string) (*SyslogEmitter, error) {
+	if tag == "" {
+		tag = "plakar"
+	}
+	return &SyslogEmitter{
+		network: network,
+		address: address,
+		tag:     tag,
+	}, nil
+}
+
+func (emitter *SyslogEmitter) Emit(report Report, logger *logging.Logger) {
+	if err := emitter.Send(report); err != nil {
+		logger.Error("failed to emit report to syslog: %s", err)
+	}
+}
+
+func (emitter *SyslogEmitter) Send(report Report) error {
+	emitter.mu.Lock()
+	defer emitter.mu.Unlock()
+
+	if emitter.writer == nil {
+		writer, err := syslog.Dial(emitter.network, emitter.address, syslog.LOG_INFO|syslog.LOG_DAEMON, emitter.tag)
+		if err != nil {
+			return err
+		}
+		emitter.writer = writer
+	}
+
+	line := summary(report)
+	var err error
+	switch {
+	case report.Task != nil && report.Task.Status == StatusFailed:
+		err = emitter.writer.Err(line)
+	case report.Task != nil && report.Task.Status == StatusWarning:
+		err = emitter.writer.Warning(line)
+	default:
+		err = emitter.writer.Info(line)
+	}
+	if err != nil {
+		// reconnect on the next report
+		emitter.writer.Close()
+		emitter.writer = nil
+	}
+	return err
+}
+
+func summary(report Report) string {
+	if report.Task == nil {
+		return "report"
+	}
+	line := fmt.Sprintf("task=%s name=%s status=%s duration=%s",
+		report.Task.Type, report.Task.Name, report.Task.Status, report.Task.Duration)
+	if report.Repository != nil {
+		line += fmt.Sprintf(" repository=%s", report.Repository.Name)
+	}
+	if report.Snapshot != nil {
+		line += fmt.Sprintf(" snapshot=%x", report.Snapshot.Identifier[:4])
+	}
+	if report.Task.ErrorMessage != "" {
+		line += fmt.Sprintf(" error=%q", report.Task.ErrorMessage)
+	}
+	return line
+}
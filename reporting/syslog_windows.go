@@ -0,0 +1,9 @@
+package reporting
+
+import (
+	"errors"
+)
+
+func NewSyslogEmitter(network, address, tag string) (Emitter, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,102 @@
+package reporting
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"text/template"
+	"time"
+
+	"github.com/PlakarKorp/kloset/logging"
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+// WebhookEmitter sends the reports to an HTTP endpoint, as JSON or as the
+// payload produced by a template, e.g. to post a message to a chat.
+type WebhookEmitter struct {
+	url      string
+	method   string
+	headers  map[string]string
+	template *template.Template
+	retry    uint8
+	backoff  time.Duration
+}
+
+func NewWebhookEmitter(url, method string, headers map[string]string, payload string) (*WebhookEmitter, error) {
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	emitter := &WebhookEmitter{
+		url:     url,
+		method:  method,
+		headers: headers,
+		retry:   3,
+		backoff: time.Minute,
+	}
+	if payload != "" {
+		tmpl, err := parseTemplate("payload", payload)
+		if err != nil {
+			return nil, err
+		}
+		emitter.template = tmpl
+	}
+	return emitter, nil
+}
+
+func (emitter *WebhookEmitter) Emit(report Report, logger *logging.Logger) {
+	for i := range emitter.retry {
+		err := emitter.Send(report)
+		if err == nil {
+			return
+		}
+		logger.Warn("failed to emit report to %s: %s", emitter.url, err)
+		if i+1 < emitter.retry {
+			time.Sleep(emitter.backoff << i)
+		}
+	}
+	logger.Error("failed to emit report to %s after %d attempts", emitter.url, emitter.retry)
+}
+
+func (emitter *WebhookEmitter) Send(report Report) error {
+	var data []byte
+	if emitter.template == nil {
+		var err error
+		if data, err = json.Marshal(report); err != nil {
+			return err
+		}
+	} else {
+		payload, err := render(emitter.template, report)
+		if err != nil {
+			return err
+		}
+		data = []byte(payload)
+	}
+
+	req, err := http.NewRequest(emitter.method, emitter.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", fmt.Sprintf("plakar/%s (%s/%s)", utils.VERSION, runtime.GOOS, runtime.GOARCH))
+	req.Header.Set("Content-Type", "application/json")
+	if report.ID != "" {
+		req.Header.Set("Idempotency-Key", report.ID)
+	}
+	for key, value := range emitter.headers {
+		req.Header.Set(key, value)
+	}
+
+	client := http.Client{Timeout: time.Minute}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if 200 <= res.StatusCode && res.StatusCode < 300 {
+		return nil
+	}
+	return fmt.Errorf("request failed with status %s", res.Status)
+}
@@ -0,0 +1,213 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/objects"
+)
+
+// Chain lets a task run when another task of its taskset completes, on
+// top of or instead of its own interval or schedule.  Tasks are referred
+// to as "backup", "check", "restore" or "sync" for the first of their kind,
+// or with their index as in "check/1".
+type Chain struct {
+	After     string // whatever the outcome
+	OnSuccess string `mapstructure:"on_success"`
+	OnFailure string `mapstructure:"on_failure"`
+}
+
+func (c Chain) Empty() bool {
+	return c.After == "" && c.OnSuccess == "" && c.OnFailure == ""
+}
+
+// Result is the outcome of a run of a task, handed over to the tasks
+// chained after it.
+type Result struct {
+	Err     error
+	Warning error // the run succeeded, chained tasks see it as such
+
+	// the snapshot created or processed by the run, zero if none, so
+	// that a check or sync chained after a backup works on the new
+	// snapshot rather than on whatever is latest
+	SnapshotID objects.MAC
+}
+
+func (r *Result) HasSnapshot() bool {
+	return r != nil && r.SnapshotID != objects.MAC{}
+}
+
+type link struct {
+	target    string
+	onSuccess bool
+	onFailure bool
+}
+
+// taskKey resolves a reference to a task of the taskset into the key the
+// scheduler knows it by.
+func (t *Task) taskKey(ref string) (string, error) {
+	kind, index, indexed := strings.Cut(ref, "/")
+
+	n := 0
+	if indexed {
+		var err error
+		if n, err = strconv.Atoi(index); err != nil || n < 0 {
+			return "", fmt.Errorf("invalid task reference: %s", ref)
+		}
+	}
+
+	var count int
+	switch kind {
+	case "backup":
+		if t.Backup != nil {
+			count = 1
+		}
+	case "check":
+		count = len(t.Check)
+	case "restore":
+		count = len(t.Restore)
+	case "sync":
+		count = len(t.Sync)
+	default:
+		return "", fmt.Errorf("invalid task reference: %s", ref)
+	}
+	if n >= count {
+		return "", fmt.Errorf("no such task: %s", ref)
+	}
+
+	if kind == "backup" {
+		return t.Name + "/backup", nil
+	}
+	return fmt.Sprintf("%s/%s/%d", t.Name, kind, n), nil
+}
+
+// chains returns the chain of each task of the taskset by key.
+func (t *Task) chains() map[string]Chain {
+	chains := make(map[string]Chain)
+	if t.Backup != nil {
+		chains[t.Name+"/backup"] = t.Backup.Chain
+	}
+	for i, task := range t.Check {
+		chains[fmt.Sprintf("%s/check/%d", t.Name, i)] = task.Chain
+	}
+	for i, task := range t.Restore {
+		chains[fmt.Sprintf("%s/restore/%d", t.Name, i)] = task.Chain
+	}
+	for i, task := range t.Sync {
+		chains[fmt.Sprintf("%s/sync/%d", t.Name, i)] = task.Chain
+	}
+	return chains
+}
+
+// links returns, for each task of the taskset, the tasks to trigger when
+// it completes.
+func (t *Task) links() (map[string][]link, error) {
+	links := make(map[string][]link)
+	for target, chain := range t.chains() {
+		for _, c := range []struct {
+			ref       string
+			onSuccess bool
+			onFailure bool
+		}{
+			{chain.After, true, true},
+			{chain.OnSuccess, true, false},
+			{chain.OnFailure, false, true},
+		} {
+			if c.ref == "" {
+				continue
+			}
+			source, err := t.taskKey(c.ref)
+			if err != nil {
+				return nil, fmt.Errorf("task %s: %w", target, err)
+			}
+			links[source] = append(links[source], link{
+				target:    target,
+				onSuccess: c.onSuccess,
+				onFailure: c.onFailure,
+			})
+		}
+	}
+
+	// a cycle would make the tasks trigger each other forever
+	visiting := make(map[string]bool)
+	visited := make(map[string]bool)
+	var visit func(key string) error
+	visit = func(key string) error {
+		if visiting[key] {
+			return fmt.Errorf("task %s: chain loops back to itself", key)
+		}
+		if visited[key] {
+			return nil
+		}
+		visiting[key] = true
+		for _, l := range links[key] {
+			if err := visit(l.target); err != nil {
+				return err
+			}
+		}
+		visiting[key] = false
+		visited[key] = true
+		return nil
+	}
+	for key := range links {
+		if err := visit(key); err != nil {
+			return nil, err
+		}
+	}
+	return links, nil
+}
+
+func validateChains(config *Configuration) error {
+	for _, task := range config.Agent.Maintenance {
+		if !task.Chain.Empty() {
+			return errors.New("maintenance tasks cannot be chained")
+		}
+	}
+	for _, taskset := range config.Agent.Tasks {
+		if _, err := taskset.links(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setupChains creates the channels through which tasks trigger the ones
+// chained after them, it must be called before the tasks are started.
+func (s *Scheduler) setupChains() {
+	s.triggers = make(map[string]chan Result)
+	s.links = make(map[string][]link)
+	for _, tasksetCfg := range s.config.Agent.Tasks {
+		links, err := tasksetCfg.links()
+		if err != nil {
+			// already validated when parsing the configuration
+			s.ctx.GetLogger().Error("scheduler: %s", err)
+			continue
+		}
+		for source, targets := range links {
+			s.links[source] = targets
+			for _, l := range targets {
+				if _, ok := s.triggers[l.target]; !ok {
+					s.triggers[l.target] = make(chan Result)
+				}
+			}
+		}
+	}
+}
+
+// done hands the result of a run over to the tasks chained after it.  It
+// blocks until they are ready to run, so that a slow downstream task holds
+// the pipeline back rather than having runs pile up.
+func (s *Scheduler) done(key string, result Result) {
+	for _, l := range s.links[key] {
+		if result.Err == nil && !l.onSuccess || result.Err != nil && !l.onFailure {
+			continue
+		}
+		select {
+		case s.triggers[l.target] <- result:
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package scheduler
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConfigFileChain(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "scheduler.yaml")
+	err := os.WriteFile(filename, []byte(`
+agent:
+  tasks:
+    - name: db
+      repository: /var/backups/plakar
+      backup:
+        path: /var/lib/db
+        interval: 24h
+      check:
+        - after: backup
+          path: /
+      sync:
+        - on_success: check
+          peer: /var/backups/offsite
+        - on_failure: check/0
+          interval: 168h
+          peer: /var/backups/other
+`), 0644)
+	require.NoError(t, err)
+
+	config, err := ParseConfigFile(filename)
+	require.NoError(t, err)
+
+	taskset := config.Agent.Tasks[0]
+	require.Equal(t, "backup", taskset.Check[0].After)
+	require.False(t, taskset.Check[0].Timed())
+	require.Equal(t, "check", taskset.Sync[0].OnSuccess)
+	require.Equal(t, "check/0", taskset.Sync[1].OnFailure)
+	require.True(t, taskset.Sync[1].Timed())
+
+	links, err := taskset.links()
+	require.NoError(t, err)
+	require.Equal(t, []link{{target: "db/check/0", onSuccess: true, onFailure: true}}, links["db/backup"])
+	require.ElementsMatch(t, []link{
+		{target: "db/sync/0", onSuccess: true},
+		{target: "db/sync/1", onFailure: true},
+	}, links["db/check/0"])
+}
+
+func TestParseConfigFileChainErrors(t *testing.T) {
+	for name, tasks := range map[string]string{
+		"unknown kind": `
+      check:
+        - after: prune
+          path: /`,
+		"out of range": `
+      check:
+        - after: check/1
+          path: /`,
+		"missing backup": `
+      check:
+        - after: backup
+          path: /`,
+		"cycle": `
+      check:
+        - after: sync
+          path: /
+      sync:
+        - after: check
+          peer: /var/backups/offsite`,
+		"no timing": `
+      check:
+        - path: /`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "scheduler.yaml")
+			err := os.WriteFile(filename, []byte(`
+agent:
+  tasks:
+    - name: db
+      repository: /var/backups/plakar`+tasks+"\n"), 0644)
+			require.NoError(t, err)
+
+			_, err = ParseConfigFile(filename)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestSchedulerChain(t *testing.T) {
+	state, err := NewState(t.TempDir())
+	require.NoError(t, err)
+
+	ctx := appcontext.NewAppContext()
+	defer ctx.Cancel()
+
+	s := &Scheduler{
+		ctx:   ctx,
+		state: state,
+		config: &Configuration{Agent: AgentConfig{Tasks: []Task{{
+			Name:    "db",
+			Backup:  &BackupConfig{},
+			Check:   []CheckConfig{{Timing: Timing{Chain: Chain{OnSuccess: "backup"}}}},
+			Restore: []RestoreConfig{{Timing: Timing{Chain: Chain{OnFailure: "backup"}}}},
+		}}}},
+	}
+	s.setupChains()
+
+	snapshotID := objects.MAC{1, 2, 3}
+	go func() {
+		s.done("db/backup", Result{Err: errors.New("failed")})
+		s.done("db/backup", Result{SnapshotID: snapshotID})
+	}()
+
+	// the failure only triggers the restore, the success only the check
+	trigger, ok := s.wait("db/restore/0", Timing{})
+	require.True(t, ok)
+	require.Error(t, trigger.Err)
+	require.False(t, trigger.HasSnapshot())
+
+	trigger, ok = s.wait("db/check/0", Timing{})
+	require.True(t, ok)
+	require.NoError(t, trigger.Err)
+	require.Equal(t, snapshotID, trigger.SnapshotID)
+
+	// the last run is recorded once the run is over
+	require.True(t, state.GetLastRun("db/check/0").IsZero())
+	s.run("db/check/0", func(ctx *appcontext.AppContext) Result {
+		require.True(t, state.GetLastRun("db/check/0").IsZero())
+		return Result{}
+	})
+	require.False(t, state.GetLastRun("db/check/0").IsZero())
+
+	ctx.Cancel()
+	_, ok = s.wait("db/check/0", Timing{})
+	require.False(t, ok)
+}
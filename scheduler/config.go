@@ -2,10 +2,16 @@ package scheduler
 
 import (
 	"fmt"
+	"math/rand"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"time"
 
+	"github.com/PlakarKorp/plakar/exclude"
+	"github.com/PlakarKorp/plakar/reporting"
+	"github.com/PlakarKorp/plakar/subcommands/backup"
+	"github.com/PlakarKorp/plakar/subcommands/prune"
 	"github.com/go-playground/validator/v10"
 	"github.com/go-viper/mapstructure/v2"
 
@@ -17,9 +23,10 @@ type Configuration struct {
 }
 
 type AgentConfig struct {
-	Reporting   bool                `yaml:"reporting"`
-	Maintenance []MaintenanceConfig `validate:"dive"`
-	Tasks       []Task              `mapstructure:"tasks" validate:"dive"`
+	Reporting   bool                      `yaml:"reporting"`
+	Emitters    []reporting.EmitterConfig `validate:"dive"`
+	Maintenance []MaintenanceConfig       `validate:"dive"`
+	Tasks       []Task                    `mapstructure:"tasks" validate:"dive"`
 }
 
 type Task struct {
@@ -32,13 +39,72 @@ type Task struct {
 	Sync    []SyncConfig    `validate:"dive"`
 }
 
+// Timing describes when a task runs: either every Interval, or following a
+// cron-like Schedule, and/or when another task of its taskset completes as
+// described by its Chain.  Jitter delays each run by a random amount up to
+// its value, to avoid hammering a repository shared by many agents at once.
+type Timing struct {
+	Interval time.Duration
+	Schedule *Schedule
+	Jitter   time.Duration
+	Chain    `mapstructure:",squash"`
+}
+
+// Timed returns whether the task runs on its own, as opposed to only when
+// triggered by the task it is chained after.
+func (t Timing) Timed() bool {
+	return t.Interval != 0 || t.Schedule != nil
+}
+
+// Next returns when a task last run at last should run again.  A task that
+// never ran waits for its first interval or scheduled time, while a task
+// whose run was missed (e.g. the agent was down) is due immediately.  The
+// zero time means that the schedule has no run left, e.g. a one-shot date
+// that has passed.
+func (t Timing) Next(last, now time.Time) time.Time {
+	var next time.Time
+
+	if t.Schedule != nil {
+		from := last
+		if from.IsZero() {
+			from = now
+		}
+		next = t.Schedule.Next(from)
+		if next.IsZero() {
+			return next
+		}
+	} else if last.IsZero() {
+		next = now.Add(t.Interval)
+	} else {
+		next = last.Add(t.Interval)
+	}
+
+	if next.Before(now) {
+		next = now
+	}
+
+	if t.Jitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(t.Jitter))))
+	}
+	return next
+}
+
 type BackupConfig struct {
-	Name      string
-	Tags      []string
-	Path      string        `validate:"required"`
-	Interval  time.Duration `validate:"required"`
-	Check     BackupConfigCheck
-	Retention time.Duration
+	Timing       `mapstructure:",squash"`
+	Name         string
+	Category     string
+	Environment  string
+	Perimeter    string
+	Tags         []string
+	Path         string `validate:"required"`
+	Excludes     []string
+	ExcludeFiles []string `mapstructure:"exclude_files"`
+	Options      map[string]string
+	Concurrency  uint64
+	Check        BackupConfigCheck
+	Retention    time.Duration
+	Keep         prune.Policy
+	Hooks        backup.Hooks
 }
 
 // CheckDecodeHook is a mapstructure decode hook to allow users to specify
@@ -68,17 +134,17 @@ type BackupConfigCheck struct {
 }
 
 type CheckConfig struct {
-	Path     string `validate:"required"`
-	Since    string
-	Before   string
-	Interval time.Duration `validate:"required"`
-	Latest   bool
+	Timing `mapstructure:",squash"`
+	Path   string `validate:"required"`
+	Since  string
+	Before string
+	Latest bool
 }
 
 type RestoreConfig struct {
-	Path     string        `validate:"required"`
-	Target   string        `validate:"required"`
-	Interval time.Duration `validate:"required"`
+	Timing `mapstructure:",squash"`
+	Path   string `validate:"required"`
+	Target string `validate:"required"`
 }
 
 type SyncDirection string
@@ -139,14 +205,30 @@ func DurationDecodeHook() mapstructure.DecodeHookFunc {
 	}
 }
 
+// ScheduleDecodeHook is a mapstructure decode hook parsing the "schedule"
+// strings into a Schedule, so that invalid expressions are reported when the
+// configuration is loaded rather than when the task is about to run.
+func ScheduleDecodeHook() mapstructure.DecodeHookFunc {
+	return func(
+		from reflect.Type,
+		to reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if from.Kind() == reflect.String && to == reflect.TypeOf(&Schedule{}) {
+			return ParseSchedule(data.(string))
+		}
+		return data, nil
+	}
+}
+
 type SyncConfig struct {
+	Timing    `mapstructure:",squash"`
 	Peer      string        `validate:"required"`
 	Direction SyncDirection `validate:"required"`
-	Interval  time.Duration `validate:"required"`
 }
 
 type MaintenanceConfig struct {
-	Interval   time.Duration `validate:"required"`
+	Timing     `mapstructure:",squash"`
 	Retention  time.Duration `validate:"required"`
 	Repository string        `validate:"required"`
 }
@@ -175,6 +257,7 @@ func ParseConfigFile(filename string) (*Configuration, error) {
 		DecodeHook: mapstructure.ComposeDecodeHookFunc(
 			BackupConfigCheckDecodeHook(),
 			SyncDirectionDecodeHook(),
+			ScheduleDecodeHook(),
 			DurationDecodeHook(),
 		),
 		ErrorUnused: true, // errors out if there are extra/unmapped keys
@@ -187,6 +270,21 @@ func ParseConfigFile(filename string) (*Configuration, error) {
 		return nil, fmt.Errorf("decoding config: %w", err)
 	}
 
+	if err := validateChains(&config); err != nil {
+		return nil, fmt.Errorf("validating config: %w", err)
+	}
+
+	for _, task := range config.Agent.Tasks {
+		if task.Backup == nil {
+			continue
+		}
+		for _, pattern := range task.Backup.Excludes {
+			if err := exclude.Validate(pattern); err != nil {
+				return nil, fmt.Errorf("task %s: failed to compile exclude pattern: %w", task.Name, err)
+			}
+		}
+	}
+
 	// Set default values for SyncConfig.Direction.
 	for i := range config.Agent.Tasks {
 		for j := range config.Agent.Tasks[i].Sync {
@@ -205,9 +303,33 @@ func ParseConfigFile(filename string) (*Configuration, error) {
 		}
 	}, Task{})
 
+	validate.RegisterStructValidation(func(sl validator.StructLevel) {
+		obj := sl.Current().Interface().(Timing)
+		if !obj.Timed() && obj.Chain.Empty() {
+			sl.ReportError(obj.Interval, "Interval", "Interval", "required_without_all", "one of Interval, Schedule, After, OnSuccess or OnFailure must be set")
+		}
+	}, Timing{})
+
 	if err := validate.Struct(config); err != nil {
 		return nil, fmt.Errorf("validating config: %w", err)
 	}
 
+	ids := make(map[string]struct{})
+	for i, emitter := range config.Agent.Emitters {
+		if _, err := reporting.NewEmitter(emitter); err != nil {
+			return nil, fmt.Errorf("emitter %d: %w", i, err)
+		}
+		if emitter.ID == "" {
+			continue
+		}
+		if emitter.ID != filepath.Base(emitter.ID) || strings.HasPrefix(emitter.ID, ".") {
+			return nil, fmt.Errorf("emitter %d: invalid id %q", i, emitter.ID)
+		}
+		if _, ok := ids[emitter.ID]; ok {
+			return nil, fmt.Errorf("emitter %d: duplicate id %q", i, emitter.ID)
+		}
+		ids[emitter.ID] = struct{}{}
+	}
+
 	return &config, nil
 }
@@ -0,0 +1,206 @@
+package scheduler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConfigFileHooks(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "scheduler.yaml")
+	err := os.WriteFile(filename, []byte(`
+agent:
+  tasks:
+    - name: db
+      repository: /var/backups/plakar
+      backup:
+        path: /var/lib/db
+        interval: 24h
+        hooks:
+          pre_backup: /usr/local/bin/quiesce-db
+          on_failure: /usr/local/bin/resume-db
+          timeout: 30s
+`), 0644)
+	require.NoError(t, err)
+
+	config, err := ParseConfigFile(filename)
+	require.NoError(t, err)
+	require.Len(t, config.Agent.Tasks, 1)
+
+	hooks := config.Agent.Tasks[0].Backup.Hooks
+	require.Equal(t, "/usr/local/bin/quiesce-db", hooks.PreBackup)
+	require.Equal(t, "", hooks.PostBackup)
+	require.Equal(t, "/usr/local/bin/resume-db", hooks.OnFailure)
+	require.Equal(t, 30*time.Second, hooks.Timeout)
+}
+
+func TestParseConfigFileSnapshotMetadata(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "scheduler.yaml")
+	err := os.WriteFile(filename, []byte(`
+agent:
+  tasks:
+    - name: db
+      repository: /var/backups/plakar
+      backup:
+        path: /var/lib/db
+        interval: 24h
+        name: db01
+        category: database
+        environment: staging
+        perimeter: eu-west
+        tags: [postgres, nightly]
+`), 0644)
+	require.NoError(t, err)
+
+	config, err := ParseConfigFile(filename)
+	require.NoError(t, err)
+	require.Len(t, config.Agent.Tasks, 1)
+
+	backup := config.Agent.Tasks[0].Backup
+	require.Equal(t, "db01", backup.Name)
+	require.Equal(t, "database", backup.Category)
+	require.Equal(t, "staging", backup.Environment)
+	require.Equal(t, "eu-west", backup.Perimeter)
+	require.Equal(t, []string{"postgres", "nightly"}, backup.Tags)
+}
+
+func TestParseConfigFileBackupOptions(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "scheduler.yaml")
+	err := os.WriteFile(filename, []byte(`
+agent:
+  tasks:
+    - name: bucket
+      repository: /var/backups/plakar
+      backup:
+        path: s3://s3.example.com/bucket
+        interval: 24h
+        concurrency: 4
+        excludes:
+          - "*.tmp"
+          - /cache/
+        exclude_files:
+          - /etc/plakar/excludes
+        options:
+          access_key: minioadmin
+          use_tls: "false"
+`), 0644)
+	require.NoError(t, err)
+
+	config, err := ParseConfigFile(filename)
+	require.NoError(t, err)
+	require.Len(t, config.Agent.Tasks, 1)
+
+	backup := config.Agent.Tasks[0].Backup
+	require.Equal(t, uint64(4), backup.Concurrency)
+	require.Equal(t, []string{"*.tmp", "/cache/"}, backup.Excludes)
+	require.Equal(t, []string{"/etc/plakar/excludes"}, backup.ExcludeFiles)
+	require.Equal(t, map[string]string{"access_key": "minioadmin", "use_tls": "false"}, backup.Options)
+
+	err = os.WriteFile(filename, []byte(`
+agent:
+  tasks:
+    - name: bucket
+      repository: /var/backups/plakar
+      backup:
+        path: /var/www
+        interval: 24h
+        excludes:
+          - "[abc"
+`), 0644)
+	require.NoError(t, err)
+
+	_, err = ParseConfigFile(filename)
+	require.ErrorContains(t, err, "exclude pattern")
+}
+
+func TestBackupExcludes(t *testing.T) {
+	excludeFile := filepath.Join(t.TempDir(), "excludes")
+	err := os.WriteFile(excludeFile, []byte("node_modules/\n!keep.log\n"), 0644)
+	require.NoError(t, err)
+
+	excludes, err := backupExcludes(BackupConfig{
+		Excludes:     []string{"*.log"},
+		ExcludeFiles: []string{excludeFile},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"node_modules/", "!keep.log", "*.log"}, excludes)
+
+	_, err = backupExcludes(BackupConfig{
+		ExcludeFiles: []string{filepath.Join(t.TempDir(), "missing")},
+	})
+	require.Error(t, err)
+}
+
+func TestParseConfigFileEmitters(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "scheduler.yaml")
+	err := os.WriteFile(filename, []byte(`
+agent:
+  emitters:
+    - type: file
+      path: /var/log/plakar/reports.jsonl
+    - type: webhook
+      id: chat
+      url: https://chat.example.com/hooks/backups
+      status: [failure, warning]
+      headers:
+        Authorization: Bearer token
+      template: '{"text": {{json .Task.ErrorMessage}}}'
+    - type: smtp
+      host: mail.example.com
+      from: plakar@example.com
+      to: [ops@example.com]
+  tasks:
+    - name: db
+      repository: /var/backups/plakar
+      backup:
+        path: /var/lib/db
+        interval: 24h
+`), 0644)
+	require.NoError(t, err)
+
+	config, err := ParseConfigFile(filename)
+	require.NoError(t, err)
+
+	emitters := config.Agent.Emitters
+	require.Len(t, emitters, 3)
+	require.Equal(t, "/var/log/plakar/reports.jsonl", emitters[0].Path)
+	require.Equal(t, []string{"failure", "warning"}, emitters[1].Status)
+	require.Equal(t, "Bearer token", emitters[1].Headers["authorization"])
+	require.Equal(t, []string{"ops@example.com"}, emitters[2].To)
+
+	// the outbox follows an emitter through changes to its settings
+	require.Equal(t, "0-file", emitters[0].Name(0))
+	require.Equal(t, "id-chat", emitters[1].Name(1))
+
+	for _, emitter := range []string{`
+    - type: webhook
+`, `
+    - type: smtp
+      host: mail.example.com
+      from: plakar@example.com
+`, `
+    - type: file
+      path: /tmp/reports.jsonl
+      status: [sometimes]
+`, `
+    - type: carrier-pigeon
+`, `
+    - type: syslog
+      id: ../syslog
+`, `
+    - type: syslog
+      id: logs
+    - type: file
+      id: logs
+      path: /tmp/reports.jsonl
+`} {
+		err := os.WriteFile(filename, []byte("agent:\n  emitters:"+emitter), 0644)
+		require.NoError(t, err)
+
+		_, err = ParseConfigFile(filename)
+		require.Error(t, err, emitter)
+	}
+}
@@ -0,0 +1,203 @@
+package scheduler
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/plakar/reporting"
+)
+
+// HISTORY_MAX is the number of runs kept in the history, older ones are
+// dropped.  The file is only rewritten once it holds twice as many.
+const HISTORY_MAX = 1000
+
+// HISTORY_LINE_MAX bounds the size of a run in the history file, a run
+// holding a long error must not make the history unreadable.
+const HISTORY_LINE_MAX = 1024 * 1024
+
+// Run is a completed run of a task.
+type Run struct {
+	Task       string               `json:"task"`
+	Type       string               `json:"type"`
+	Taskset    string               `json:"taskset"`
+	Repository string               `json:"repository"`
+	Started    time.Time            `json:"started"`
+	Duration   time.Duration        `json:"duration"`
+	Status     reporting.TaskStatus `json:"status"`
+	Error      string               `json:"error,omitempty"`
+	SnapshotID objects.MAC          `json:"snapshot_id"`
+}
+
+// History keeps the runs of the tasks on disk, one JSON object per line,
+// so that what the agent did survives a restart.
+type History struct {
+	path string
+	mu   sync.Mutex
+
+	runs  []Run
+	lines int // runs in the file, trimmed or not
+}
+
+func NewHistory(cacheDir string) (*History, error) {
+	dir := filepath.Join(cacheDir, "scheduler", STATE_VERSION)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("cannot create scheduler state directory: %w", err)
+	}
+
+	h, err := ReadHistory(cacheDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(h.runs) > HISTORY_MAX {
+		h.runs = h.runs[len(h.runs)-HISTORY_MAX:]
+		if err := h.rewrite(); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// ReadHistory loads the history without trimming it, so that the runs can be
+// looked at while no scheduler is running.
+func ReadHistory(cacheDir string) (*History, error) {
+	h := &History{
+		path: filepath.Join(cacheDir, "scheduler", STATE_VERSION, "history.jsonl"),
+	}
+
+	fp, err := os.Open(h.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return h, nil
+		}
+		return nil, err
+	}
+	defer fp.Close()
+
+	scanner := bufio.NewScanner(fp)
+	scanner.Buffer(make([]byte, 0, 64*1024), HISTORY_LINE_MAX)
+	for scanner.Scan() {
+		h.lines++
+		var run Run
+		if err := json.Unmarshal(scanner.Bytes(), &run); err != nil {
+			// a line cut short by a crash, skip it
+			continue
+		}
+		h.runs = append(h.runs, run)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("corrupted scheduler history %s: %w", h.path, err)
+	}
+	return h, nil
+}
+
+func (h *History) rewrite() error {
+	tmp := h.path + ".tmp"
+	fp, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(fp)
+	for _, run := range h.runs {
+		if err := enc.Encode(run); err != nil {
+			fp.Close()
+			return err
+		}
+	}
+	if err := fp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, h.path); err != nil {
+		return err
+	}
+	h.lines = len(h.runs)
+	return nil
+}
+
+func (h *History) Append(run Run) error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.runs = append(h.runs, run)
+	if len(h.runs) > HISTORY_MAX {
+		h.runs = slices.Delete(h.runs, 0, len(h.runs)-HISTORY_MAX)
+	}
+	if h.lines >= 2*HISTORY_MAX {
+		return h.rewrite()
+	}
+
+	fp, err := os.OpenFile(h.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(fp).Encode(run); err != nil {
+		fp.Close()
+		return err
+	}
+	if err := fp.Close(); err != nil {
+		return err
+	}
+	h.lines++
+	return nil
+}
+
+// Runs returns the runs of the task identified by key, or of all the tasks
+// of the taskset of that name, or of all tasks if key is empty, most recent
+// first.  At most limit runs are returned unless limit is zero.
+func (h *History) Runs(key string, limit int) []Run {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var runs []Run
+	for i := len(h.runs) - 1; i >= 0; i-- {
+		if key != "" && h.runs[i].Task != key && h.runs[i].Taskset != key {
+			continue
+		}
+		runs = append(runs, h.runs[i])
+		if limit != 0 && len(runs) == limit {
+			break
+		}
+	}
+	return runs
+}
+
+// Last returns the most recent run of the task identified by key.
+func (h *History) Last(key string) *Run {
+	return h.last(key, func(*Run) bool { return true })
+}
+
+// LastSuccess returns the most recent run of the task identified by key
+// that did not fail.
+func (h *History) LastSuccess(key string) *Run {
+	return h.last(key, func(run *Run) bool { return run.Status != reporting.StatusFailed })
+}
+
+func (h *History) last(key string, match func(*Run) bool) *Run {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i := len(h.runs) - 1; i >= 0; i-- {
+		if h.runs[i].Task == key && match(&h.runs[i]) {
+			run := h.runs[i]
+			return &run
+		}
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package scheduler
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/events"
+	"github.com/PlakarKorp/kloset/logging"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/metrics"
+	"github.com/PlakarKorp/plakar/reporting"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	dir := t.TempDir()
+
+	h, err := NewHistory(dir)
+	require.NoError(t, err)
+	require.Nil(t, h.Last("db/backup"))
+
+	started := time.Date(2026, 10, 17, 2, 30, 0, 0, time.UTC)
+	require.NoError(t, h.Append(Run{Task: "db/backup", Taskset: "db", Started: started, Status: reporting.StatusOK}))
+	require.NoError(t, h.Append(Run{Task: "db/check/0", Taskset: "db", Started: started, Status: reporting.StatusFailed, Error: "boom"}))
+	require.NoError(t, h.Append(Run{Task: "www/backup", Taskset: "www", Started: started, Status: reporting.StatusOK}))
+
+	h, err = NewHistory(dir)
+	require.NoError(t, err)
+	require.Equal(t, "boom", h.Last("db/check/0").Error)
+	require.Len(t, h.Runs("", 0), 3)
+	require.Len(t, h.Runs("db", 0), 2)
+	require.Len(t, h.Runs("www/backup", 0), 1)
+
+	runs := h.Runs("", 2)
+	require.Len(t, runs, 2)
+	require.Equal(t, "www/backup", runs[0].Task)
+	require.Equal(t, "db/check/0", runs[1].Task)
+}
+
+func TestHistoryTrim(t *testing.T) {
+	dir := t.TempDir()
+
+	h, err := NewHistory(dir)
+	require.NoError(t, err)
+	for i := 0; i < HISTORY_MAX+10; i++ {
+		require.NoError(t, h.Append(Run{Task: "db/backup", Duration: time.Duration(i)}))
+	}
+
+	// a line cut short by a crash is skipped
+	fp, err := os.OpenFile(filepath.Join(dir, "scheduler", STATE_VERSION, "history.jsonl"), os.O_APPEND|os.O_WRONLY, 0600)
+	require.NoError(t, err)
+	_, err = fp.WriteString(`{"task":"db/ba`)
+	require.NoError(t, err)
+	require.NoError(t, fp.Close())
+
+	// reading the history leaves it alone
+	h, err = ReadHistory(dir)
+	require.NoError(t, err)
+	require.Len(t, h.Runs("", 0), HISTORY_MAX+10)
+
+	h, err = NewHistory(dir)
+	require.NoError(t, err)
+	runs := h.Runs("", 0)
+	require.Len(t, runs, HISTORY_MAX)
+	require.Equal(t, time.Duration(HISTORY_MAX+9), runs[0].Duration)
+}
+
+func TestHistoryAppendTrim(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "scheduler", STATE_VERSION, "history.jsonl")
+
+	h, err := NewHistory(dir)
+	require.NoError(t, err)
+
+	// a run larger than the default scanner buffer does not break reading
+	require.NoError(t, h.Append(Run{Task: "db/backup", Error: strings.Repeat("x", 128*1024)}))
+	h, err = ReadHistory(dir)
+	require.NoError(t, err)
+	require.Len(t, h.Runs("", 0), 1)
+
+	for i := 0; i < 2*HISTORY_MAX; i++ {
+		require.NoError(t, h.Append(Run{Task: "db/backup", Duration: time.Duration(i)}))
+		require.LessOrEqual(t, len(h.Runs("", 0)), HISTORY_MAX)
+	}
+
+	// the file was rewritten once it reached twice the limit
+	h, err = ReadHistory(dir)
+	require.NoError(t, err)
+	runs := h.Runs("", 0)
+	require.Less(t, len(runs), 2*HISTORY_MAX)
+	require.Equal(t, time.Duration(2*HISTORY_MAX-1), runs[0].Duration)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, len(runs), strings.Count(string(data), "\n"))
+}
+
+func TestSchedulerStatus(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+	ctx.CacheDir = t.TempDir()
+	ctx.SetLogger(logging.NewLogger(os.Stdout, os.Stderr))
+	defer ctx.Cancel()
+
+	s := NewScheduler(ctx, &Configuration{Agent: AgentConfig{Tasks: []Task{{
+		Name:       "db",
+		Repository: "/var/backups/plakar",
+		Backup:     &BackupConfig{},
+		Check:      []CheckConfig{{}},
+	}}}})
+
+	status := s.Status()
+	require.Len(t, status, 2)
+	require.Equal(t, "db/backup", status[0].Task)
+	require.Equal(t, "db/check/0", status[1].Task)
+	require.False(t, status[0].Running)
+	require.Nil(t, status[0].LastRun)
+
+	snapshotID := objects.MAC{1, 2, 3}
+	s.run("db/backup", func(ctx *appcontext.AppContext) Result {
+		status := s.Status()
+		require.True(t, status[0].Running)
+
+		ctx.Events().Send(events.FileOKEvent(snapshotID, "/etc/passwd", 1024))
+		ctx.Events().Send(events.DirectoryOKEvent(snapshotID, "/etc"))
+		return Result{SnapshotID: snapshotID}
+	})
+	s.run("db/check/0", func(ctx *appcontext.AppContext) Result {
+		return Result{Err: errors.New("boom")}
+	})
+
+	status = s.Status()
+	require.False(t, status[0].Running)
+	require.Equal(t, Progress{Directories: 1, Files: 1, Size: 1024}, status[0].Progress)
+	require.Equal(t, reporting.StatusOK, status[0].LastRun.Status)
+	require.Equal(t, snapshotID, status[0].LastRun.SnapshotID)
+	require.Equal(t, "backup", status[0].LastRun.Type)
+	require.Equal(t, reporting.StatusFailed, status[1].LastRun.Status)
+	require.Equal(t, "boom", status[1].LastRun.Error)
+
+	// a new scheduler picks the last runs from the history
+	s = NewScheduler(ctx, s.config)
+	status = s.Status()
+	require.Equal(t, snapshotID, status[0].LastRun.SnapshotID)
+	require.Len(t, s.History().Runs("db", 0), 2)
+
+	// and so does the status of a configuration that is not scheduled
+	history, err := ReadHistory(ctx.CacheDir)
+	require.NoError(t, err)
+	status = ConfigurationStatus(s.config, history)
+	require.Len(t, status, 2)
+	require.Equal(t, "db/backup", status[0].Task)
+	require.Equal(t, "backup", status[0].Type)
+	require.Equal(t, snapshotID, status[0].LastRun.SnapshotID)
+	require.Equal(t, "boom", status[1].LastRun.Error)
+}
+
+func TestSchedulerMetrics(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+	ctx.CacheDir = t.TempDir()
+	ctx.SetLogger(logging.NewLogger(os.Stdout, os.Stderr))
+	defer ctx.Cancel()
+
+	config := &Configuration{Agent: AgentConfig{Tasks: []Task{{
+		Name:       "metrics",
+		Repository: "/var/backups/plakar",
+		Backup:     &BackupConfig{},
+	}}}}
+	s := NewScheduler(ctx, config)
+
+	// the collectors are global, start afresh on each test run
+	metrics.TaskRuns.Reset()
+	metrics.TaskFiles.Reset()
+	metrics.TaskErrors.Reset()
+
+	s.run("metrics/backup", func(ctx *appcontext.AppContext) Result {
+		ctx.Events().Send(events.FileOKEvent(objects.MAC{}, "/etc/passwd", 1024))
+		ctx.Events().Send(events.FileErrorEvent(objects.MAC{}, "/etc/shadow", "permission denied"))
+		return Result{Warning: errors.New("permission denied")}
+	})
+	s.run("metrics/backup", func(ctx *appcontext.AppContext) Result {
+		return Result{Err: errors.New("boom")}
+	})
+
+	labels := []string{"metrics", "metrics/backup", "backup"}
+	require.Equal(t, 1.0, testutil.ToFloat64(metrics.TaskRuns.WithLabelValues(append(labels, "WARNING")...)))
+	require.Equal(t, 1.0, testutil.ToFloat64(metrics.TaskRuns.WithLabelValues(append(labels, "FAILURE")...)))
+	require.Equal(t, 1.0, testutil.ToFloat64(metrics.TaskFiles.WithLabelValues(labels...)))
+	require.Equal(t, 1.0, testutil.ToFloat64(metrics.TaskErrors.WithLabelValues(labels...)))
+	require.Equal(t, 0.0, testutil.ToFloat64(metrics.TaskRunning.WithLabelValues(labels...)))
+
+	// a failure does not count as a success, a warning does
+	success := s.History().LastSuccess("metrics/backup")
+	require.NotNil(t, success)
+	require.Equal(t, reporting.StatusWarning, success.Status)
+	lastSuccess := float64(success.Started.Add(success.Duration).Unix())
+	require.Equal(t, lastSuccess, testutil.ToFloat64(metrics.TaskLastSuccess.WithLabelValues(labels...)))
+
+	// and the last success is restored from the history on restart
+	metrics.TaskLastSuccess.Reset()
+	NewScheduler(ctx, config)
+	require.Equal(t, lastSuccess, testutil.ToFloat64(metrics.TaskLastSuccess.WithLabelValues(labels...)))
+}
@@ -0,0 +1,357 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression or calendar specification.  Each field
+// is a bitset of the values it matches.
+type Schedule struct {
+	spec string
+
+	second uint64
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	years  []int
+
+	// Like cron(8), when both the day of month and the day of week are
+	// restricted a day matches if either of them does.
+	domStar bool
+	dowStar bool
+
+	location *time.Location
+}
+
+type fieldBounds struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	secondBounds = fieldBounds{0, 59, nil}
+	minuteBounds = fieldBounds{0, 59, nil}
+	hourBounds   = fieldBounds{0, 23, nil}
+	domBounds    = fieldBounds{1, 31, nil}
+	monthBounds  = fieldBounds{1, 12, map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dowBounds = fieldBounds{0, 6, map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var scheduleDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+
+	"yearly":   "0 0 1 1 *",
+	"annually": "0 0 1 1 *",
+	"monthly":  "0 0 1 * *",
+	"weekly":   "0 0 * * 0",
+	"daily":    "0 0 * * *",
+	"hourly":   "0 * * * *",
+}
+
+// ParseSchedule parses either a standard five-field cron expression
+// ("30 2 * * *"), one of the @daily-style descriptors, or a calendar
+// specification in the "[DOW] [YYYY-MM-DD] HH:MM[:SS]" form ("Sun 02:30",
+// "Mon..Fri *-*-* 22:00", "*-*-01 03:00:00").  The expression may be prefixed
+// with "TZ=Area/City" or "CRON_TZ=Area/City" to be evaluated in that time
+// zone instead of the local one.
+func ParseSchedule(spec string) (*Schedule, error) {
+	s := &Schedule{
+		spec:     spec,
+		location: time.Local,
+	}
+
+	expr := strings.TrimSpace(spec)
+	if strings.HasPrefix(expr, "TZ=") || strings.HasPrefix(expr, "CRON_TZ=") {
+		tz, rest, _ := strings.Cut(expr, " ")
+		_, name, _ := strings.Cut(tz, "=")
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone %q: %w", name, err)
+		}
+		s.location = loc
+		expr = strings.TrimSpace(rest)
+	}
+
+	if expr == "" {
+		return nil, fmt.Errorf("empty schedule")
+	}
+
+	if cron, ok := scheduleDescriptors[strings.ToLower(expr)]; ok {
+		expr = cron
+	}
+
+	fields := strings.Fields(expr)
+	var err error
+	if len(fields) == 5 && !strings.Contains(expr, ":") {
+		err = s.parseCron(fields)
+	} else {
+		err = s.parseCalendar(fields)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+	}
+	return s, nil
+}
+
+func (s *Schedule) String() string {
+	return s.spec
+}
+
+func (s *Schedule) parseCron(fields []string) error {
+	var err error
+
+	s.second = 1 << 0
+	if s.minute, err = parseField(fields[0], minuteBounds); err != nil {
+		return fmt.Errorf("minute: %w", err)
+	}
+	if s.hour, err = parseField(fields[1], hourBounds); err != nil {
+		return fmt.Errorf("hour: %w", err)
+	}
+	if s.dom, err = parseField(fields[2], domBounds); err != nil {
+		return fmt.Errorf("day of month: %w", err)
+	}
+	if s.month, err = parseField(fields[3], monthBounds); err != nil {
+		return fmt.Errorf("month: %w", err)
+	}
+	// both 0 and 7 stand for Sunday
+	if s.dow, err = parseField(fields[4], fieldBounds{0, 7, dowBounds.names}); err != nil {
+		return fmt.Errorf("day of week: %w", err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow = (s.dow | 1) &^ (1 << 7)
+	}
+	s.domStar = fields[2] == "*" || fields[2] == "?"
+	s.dowStar = fields[4] == "*" || fields[4] == "?"
+	return nil
+}
+
+func (s *Schedule) parseCalendar(fields []string) error {
+	var err error
+
+	// unlike cron, all the fields of a calendar specification must match
+	s.dow = bitRange(dowBounds.min, dowBounds.max)
+	s.month = bitRange(monthBounds.min, monthBounds.max)
+	s.dom = bitRange(domBounds.min, domBounds.max)
+	s.domStar = true
+	s.dowStar = true
+
+	if len(fields) > 0 && !strings.ContainsAny(fields[0], ":-") {
+		spec := strings.ToLower(strings.ReplaceAll(fields[0], "..", "-"))
+		if s.dow, err = parseField(spec, dowBounds); err != nil {
+			return fmt.Errorf("day of week: %w", err)
+		}
+		fields = fields[1:]
+	}
+
+	if len(fields) > 0 && strings.Contains(fields[0], "-") && !strings.Contains(fields[0], ":") {
+		date := strings.Split(fields[0], "-")
+		if len(date) == 2 {
+			date = append([]string{"*"}, date...)
+		}
+		if len(date) != 3 {
+			return fmt.Errorf("malformed date %q", fields[0])
+		}
+		if date[0] != "*" {
+			for _, y := range strings.Split(date[0], ",") {
+				year, err := strconv.Atoi(y)
+				if err != nil {
+					return fmt.Errorf("invalid year %q", y)
+				}
+				s.years = append(s.years, year)
+			}
+		}
+		if s.month, err = parseField(date[1], monthBounds); err != nil {
+			return fmt.Errorf("month: %w", err)
+		}
+		if s.dom, err = parseField(date[2], domBounds); err != nil {
+			return fmt.Errorf("day of month: %w", err)
+		}
+		fields = fields[1:]
+	}
+
+	if len(fields) != 1 {
+		return fmt.Errorf("expected a time of day in the HH:MM[:SS] form")
+	}
+
+	hms := strings.Split(fields[0], ":")
+	if len(hms) < 2 || len(hms) > 3 {
+		return fmt.Errorf("malformed time of day %q", fields[0])
+	}
+	if s.hour, err = parseField(hms[0], hourBounds); err != nil {
+		return fmt.Errorf("hour: %w", err)
+	}
+	if s.minute, err = parseField(hms[1], minuteBounds); err != nil {
+		return fmt.Errorf("minute: %w", err)
+	}
+	s.second = 1 << 0
+	if len(hms) == 3 {
+		if s.second, err = parseField(hms[2], secondBounds); err != nil {
+			return fmt.Errorf("second: %w", err)
+		}
+	}
+	return nil
+}
+
+func bitRange(min, max int) uint64 {
+	var bits uint64
+	for i := min; i <= max; i++ {
+		bits |= 1 << uint(i)
+	}
+	return bits
+}
+
+// parseField parses a single cron field made of comma-separated items, each
+// being "*", a value, or a range, optionally followed by a "/step".
+func parseField(field string, bounds fieldBounds) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		expr, stepStr, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepStr)
+			}
+		}
+
+		var lo, hi int
+		switch {
+		case expr == "*" || expr == "?":
+			lo, hi = bounds.min, bounds.max
+		case strings.Contains(expr, "-"):
+			from, to, _ := strings.Cut(expr, "-")
+			var err error
+			if lo, err = parseValue(from, bounds); err != nil {
+				return 0, err
+			}
+			if hi, err = parseValue(to, bounds); err != nil {
+				return 0, err
+			}
+		default:
+			var err error
+			if lo, err = parseValue(expr, bounds); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if hasStep {
+				hi = bounds.max
+			}
+		}
+
+		if lo > hi {
+			return 0, fmt.Errorf("invalid range %q", expr)
+		}
+		for i := lo; i <= hi; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+func parseValue(value string, bounds fieldBounds) (int, error) {
+	if n, ok := bounds.names[strings.ToLower(value)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+	if n < bounds.min || n > bounds.max {
+		return 0, fmt.Errorf("value %d out of range [%d-%d]", n, bounds.min, bounds.max)
+	}
+	return n, nil
+}
+
+func (s *Schedule) matchYear(year int) bool {
+	if len(s.years) == 0 {
+		return true
+	}
+	for _, y := range s.years {
+		if y == year {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Schedule) matchDay(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Next returns the first time strictly after t matching the schedule, or the
+// zero time if there is none within the next five years.
+func (s *Schedule) Next(t time.Time) time.Time {
+	origLocation := t.Location()
+	t = t.In(s.location).Truncate(time.Second).Add(time.Second)
+
+	limit := t.Year() + 5
+
+wrap:
+	for t.Year() <= limit {
+		if !s.matchYear(t.Year()) {
+			t = time.Date(t.Year()+1, 1, 1, 0, 0, 0, 0, s.location)
+			continue
+		}
+
+		for s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.location)
+			if t.Month() == time.January {
+				continue wrap
+			}
+		}
+
+		for !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.location)
+			if t.Day() == 1 {
+				continue wrap
+			}
+		}
+
+		for s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.location)
+			if t.Hour() == 0 {
+				continue wrap
+			}
+		}
+
+		for s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Truncate(time.Minute).Add(time.Minute)
+			if t.Minute() == 0 {
+				continue wrap
+			}
+		}
+
+		for s.second&(1<<uint(t.Second())) == 0 {
+			t = t.Add(time.Second)
+			if t.Second() == 0 {
+				continue wrap
+			}
+		}
+
+		return t.In(origLocation)
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,164 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseScheduleCron(t *testing.T) {
+	s, err := ParseSchedule("TZ=UTC 30 2 * * *")
+	require.NoError(t, err)
+
+	from := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	require.Equal(t, time.Date(2026, 10, 18, 2, 30, 0, 0, time.UTC), s.Next(from))
+
+	// an exact match is not returned twice
+	from = time.Date(2026, 10, 18, 2, 30, 0, 0, time.UTC)
+	require.Equal(t, time.Date(2026, 10, 19, 2, 30, 0, 0, time.UTC), s.Next(from))
+
+	// Sundays only, 7 being an alias for 0
+	s, err = ParseSchedule("TZ=UTC 0 3 * * 7")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2026, 10, 18, 3, 0, 0, 0, time.UTC), s.Next(from))
+
+	s, err = ParseSchedule("TZ=UTC */15 * * * *")
+	require.NoError(t, err)
+	from = time.Date(2026, 10, 17, 12, 16, 0, 0, time.UTC)
+	require.Equal(t, time.Date(2026, 10, 17, 12, 30, 0, 0, time.UTC), s.Next(from))
+
+	s, err = ParseSchedule("TZ=UTC @monthly")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC), s.Next(from))
+
+	// day of month and day of week are OR-ed when both are restricted
+	s, err = ParseSchedule("TZ=UTC 0 0 1 * mon")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC), s.Next(from))
+}
+
+func TestParseScheduleCalendar(t *testing.T) {
+	from := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	s, err := ParseSchedule("TZ=UTC Mon..Fri 22:00")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2026, 10, 19, 22, 0, 0, 0, time.UTC), s.Next(from))
+
+	s, err = ParseSchedule("TZ=UTC *-*-01 03:00:30")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2026, 11, 1, 3, 0, 30, 0, time.UTC), s.Next(from))
+
+	s, err = ParseSchedule("TZ=UTC 2027-01-15 08:00")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2027, 1, 15, 8, 0, 0, 0, time.UTC), s.Next(from))
+	require.True(t, s.Next(time.Date(2027, 1, 16, 0, 0, 0, 0, time.UTC)).IsZero())
+}
+
+func TestParseScheduleTimezone(t *testing.T) {
+	s, err := ParseSchedule("CRON_TZ=Europe/Paris 30 2 * * *")
+	require.NoError(t, err)
+
+	paris, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+
+	from := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	next := s.Next(from)
+	require.Equal(t, time.UTC, next.Location())
+	require.Equal(t, time.Date(2026, 10, 18, 2, 30, 0, 0, paris).UTC(), next)
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"61 * * * *",
+		"* * * *",
+		"5-1 * * * *",
+		"TZ=Nowhere/Land 0 0 * * *",
+		"Funday 10:00",
+		"25:00",
+	} {
+		_, err := ParseSchedule(spec)
+		require.Error(t, err, spec)
+	}
+}
+
+func TestTimingNext(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	timing := Timing{Interval: time.Hour}
+	require.Equal(t, now.Add(time.Hour), timing.Next(time.Time{}, now))
+	require.Equal(t, now.Add(30*time.Minute), timing.Next(now.Add(-30*time.Minute), now))
+
+	// a run missed while the agent was down is caught up immediately
+	require.Equal(t, now, timing.Next(now.Add(-3*time.Hour), now))
+
+	s, err := ParseSchedule("TZ=UTC 30 2 * * *")
+	require.NoError(t, err)
+	timing = Timing{Schedule: s}
+	require.Equal(t, time.Date(2026, 10, 18, 2, 30, 0, 0, time.UTC), timing.Next(time.Time{}, now))
+	require.Equal(t, now, timing.Next(time.Date(2026, 10, 16, 2, 30, 0, 0, time.UTC), now))
+	require.Equal(t, time.Date(2026, 10, 18, 2, 30, 0, 0, time.UTC),
+		timing.Next(time.Date(2026, 10, 17, 2, 30, 0, 0, time.UTC), now))
+
+	timing.Jitter = time.Minute
+	next := timing.Next(time.Time{}, now)
+	require.False(t, next.Before(time.Date(2026, 10, 18, 2, 30, 0, 0, time.UTC)))
+	require.True(t, next.Before(time.Date(2026, 10, 18, 2, 31, 0, 0, time.UTC)))
+}
+
+func TestTimingNextExpired(t *testing.T) {
+	s, err := ParseSchedule("TZ=UTC 2027-01-15 08:00")
+	require.NoError(t, err)
+	timing := Timing{Schedule: s}
+
+	now := time.Date(2027, 1, 16, 0, 0, 0, 0, time.UTC)
+	require.True(t, timing.Next(time.Time{}, now).IsZero())
+	require.True(t, timing.Next(time.Date(2027, 1, 15, 8, 0, 0, 0, time.UTC), now).IsZero())
+}
+
+func TestSchedulerWaitExpired(t *testing.T) {
+	state, err := NewState(t.TempDir())
+	require.NoError(t, err)
+
+	ctx := appcontext.NewAppContext()
+	defer ctx.Cancel()
+
+	s := &Scheduler{ctx: ctx, state: state}
+
+	// a one-shot date in the past never runs again
+	schedule, err := ParseSchedule("TZ=UTC 2020-01-15 08:00")
+	require.NoError(t, err)
+
+	done := make(chan bool)
+	go func() {
+		_, ok := s.wait("db/backup", Timing{Schedule: schedule})
+		done <- ok
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("task ran on an expired schedule")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	ctx.Cancel()
+	require.False(t, <-done)
+	require.True(t, state.GetLastRun("db/backup").IsZero())
+}
+
+func TestStateLastRun(t *testing.T) {
+	dir := t.TempDir()
+
+	st, err := NewState(dir)
+	require.NoError(t, err)
+	require.True(t, st.GetLastRun("job/backup").IsZero())
+
+	when := time.Date(2026, 10, 17, 2, 30, 0, 0, time.UTC)
+	require.NoError(t, st.SetLastRun("job/backup", when))
+
+	st, err = NewState(dir)
+	require.NoError(t, err)
+	require.True(t, when.Equal(st.GetLastRun("job/backup")))
+}
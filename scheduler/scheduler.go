@@ -1,6 +1,7 @@
 package scheduler
 
 import (
+	"fmt"
 	"sync"
 	"time"
 
@@ -14,6 +15,19 @@ type Scheduler struct {
 	config *Configuration
 	ctx    *appcontext.AppContext
 	wg     sync.WaitGroup
+	state  *State
+
+	mu      sync.Mutex
+	tasks   map[string]*taskState
+	history *History
+
+	outbox   *reporting.Outbox
+	emitters []reporting.Emitter
+
+	// results of runs handed from a task to the ones chained after it,
+	// triggers are indexed by downstream task and links by upstream task
+	triggers map[string]chan Result
+	links    map[string][]link
 }
 
 func stringToDuration(s string) (time.Duration, error) {
@@ -25,37 +39,101 @@ func stringToDuration(s string) (time.Duration, error) {
 }
 
 func NewScheduler(ctx *appcontext.AppContext, config *Configuration) *Scheduler {
-	return &Scheduler{
-		ctx:    ctx,
-		config: config,
-		wg:     sync.WaitGroup{},
+	state, err := NewState(ctx.CacheDir)
+	if err != nil {
+		ctx.GetLogger().Warn("scheduler: could not load state, last runs will not be remembered: %s", err)
+	}
+
+	history, err := NewHistory(ctx.CacheDir)
+	if err != nil {
+		ctx.GetLogger().Warn("scheduler: could not load history, runs will not be recorded: %s", err)
 	}
+
+	s := &Scheduler{
+		ctx:     ctx,
+		config:  config,
+		wg:      sync.WaitGroup{},
+		state:   state,
+		tasks:   make(map[string]*taskState),
+		history: history,
+	}
+
+	config.eachTask(s.register)
+	return s
 }
 
 func (s *Scheduler) Run() {
-	for _, cleanupCfg := range s.config.Agent.Maintenance {
-		go s.maintenanceTask(cleanupCfg)
+	s.setupChains()
+
+	outbox, err := reporting.NewOutbox(s.ctx, s.ctx.CacheDir, s.ctx.GetLogger())
+	if err != nil {
+		s.ctx.GetLogger().Warn("scheduler: could not create outbox, failed reports will be lost: %s", err)
+	}
+	s.outbox = outbox
+
+	for i, emitterCfg := range s.config.Agent.Emitters {
+		emitter, err := reporting.NewEmitter(emitterCfg)
+		if err != nil {
+			s.ctx.GetLogger().Warn("scheduler: emitter %d: %s", i, err)
+			continue
+		}
+		if s.outbox != nil {
+			emitter = s.outbox.Emitter(emitterCfg.Name(i), emitter)
+		}
+		s.emitters = append(s.emitters, emitter)
+	}
+
+	for i, cleanupCfg := range s.config.Agent.Maintenance {
+		go s.maintenanceTask(fmt.Sprintf("maintenance/%d", i), cleanupCfg)
 	}
 
 	for _, tasksetCfg := range s.config.Agent.Tasks {
 		if tasksetCfg.Backup != nil {
-			go s.backupTask(tasksetCfg, *tasksetCfg.Backup)
+			go s.backupTask(tasksetCfg.Name+"/backup", tasksetCfg, *tasksetCfg.Backup)
 		}
 
-		for _, checkCfg := range tasksetCfg.Check {
-			go s.checkTask(tasksetCfg, checkCfg)
+		for i, checkCfg := range tasksetCfg.Check {
+			go s.checkTask(fmt.Sprintf("%s/check/%d", tasksetCfg.Name, i), tasksetCfg, checkCfg)
 		}
 
-		for _, restoreCfg := range tasksetCfg.Restore {
-			go s.restoreTask(tasksetCfg, restoreCfg)
+		for i, restoreCfg := range tasksetCfg.Restore {
+			go s.restoreTask(fmt.Sprintf("%s/restore/%d", tasksetCfg.Name, i), tasksetCfg, restoreCfg)
 		}
 
-		for _, syncCfg := range tasksetCfg.Sync {
-			go s.syncTask(tasksetCfg, syncCfg)
+		for i, syncCfg := range tasksetCfg.Sync {
+			go s.syncTask(fmt.Sprintf("%s/sync/%d", tasksetCfg.Name, i), tasksetCfg, syncCfg)
 		}
 	}
 }
 
+// wait blocks until the task identified by key is due according to timing,
+// or is triggered by the task it is chained after.  It returns the result
+// of the upstream run if triggered, and false if the scheduler was stopped
+// in the meantime.
+func (s *Scheduler) wait(key string, timing Timing) (*Result, bool) {
+	// a nil timer never fires, leaving only the triggers
+	var timer <-chan time.Time
+	var next time.Time
+	if timing.Timed() {
+		now := time.Now()
+		next = timing.Next(s.state.GetLastRun(key), now)
+		if !next.IsZero() {
+			timer = time.After(next.Sub(now))
+		}
+	}
+	s.setNextRun(key, next)
+
+	var trigger *Result
+	select {
+	case <-s.ctx.Done():
+		return nil, false
+	case <-timer:
+	case result := <-s.triggers[key]:
+		trigger = &result
+	}
+	return trigger, true
+}
+
 func (s *Scheduler) NewTaskReporter(ctx *appcontext.AppContext, repo *repository.Repository, taskType, taskName, repoName string) *reporting.Reporter {
 	doReport := true
 	authToken, err := s.ctx.GetCookies().GetAuthToken()
@@ -68,9 +146,17 @@ func (s *Scheduler) NewTaskReporter(ctx *appcontext.AppContext, repo *repository
 			doReport = false
 		}
 	}
-	reporter := reporting.NewReporter(ctx, doReport, repo, s.ctx.GetLogger())
+	reporter := reporting.NewReporter(ctx, doReport && s.outbox == nil, repo, s.ctx.GetLogger())
+	if doReport && s.outbox != nil {
+		reporter.AddEmitter(s.outbox.Emitter("plakar", reporting.NewHttpEmitter(authToken)))
+	}
+	for _, emitter := range s.emitters {
+		reporter.AddEmitter(emitter)
+	}
 	reporter.TaskStart(taskType, taskName)
 	reporter.WithRepositoryName(repoName)
-	reporter.WithRepository(repo)
+	if repo != nil {
+		reporter.WithRepository(repo)
+	}
 	return reporter
 }
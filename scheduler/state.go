@@ -0,0 +1,78 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const STATE_VERSION = "1.0.0"
+
+// State keeps track of when each task last ran, so that restarting the agent
+// neither resets the intervals nor triggers or skips scheduled runs.
+type State struct {
+	path string
+	mu   sync.Mutex
+
+	LastRun map[string]time.Time `json:"last_run"`
+}
+
+func NewState(cacheDir string) (*State, error) {
+	dir := filepath.Join(cacheDir, "scheduler", STATE_VERSION)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("cannot create scheduler state directory: %w", err)
+	}
+
+	st := &State{
+		path:    filepath.Join(dir, "state.json"),
+		LastRun: make(map[string]time.Time),
+	}
+
+	data, err := os.ReadFile(st.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return st, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("corrupted scheduler state %s: %w", st.path, err)
+	}
+	if st.LastRun == nil {
+		st.LastRun = make(map[string]time.Time)
+	}
+	return st, nil
+}
+
+func (st *State) GetLastRun(key string) time.Time {
+	if st == nil {
+		return time.Time{}
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.LastRun[key]
+}
+
+func (st *State) SetLastRun(key string, t time.Time) error {
+	if st == nil {
+		return nil
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.LastRun[key] = t
+
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+
+	tmp := st.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, st.path)
+}
@@ -0,0 +1,273 @@
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/PlakarKorp/kloset/events"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/metrics"
+	"github.com/PlakarKorp/plakar/reporting"
+)
+
+// TaskStatus is what the scheduler knows about one of its tasks.
+type TaskStatus struct {
+	Task       string `json:"task"`
+	Type       string `json:"type"`
+	Taskset    string `json:"taskset"`
+	Repository string `json:"repository"`
+
+	Running  bool      `json:"running"`
+	Started  time.Time `json:"started,omitempty"`
+	Progress Progress  `json:"progress"`
+
+	// zero when the task only runs when chained after another one, or
+	// when its schedule has no run left
+	NextRun time.Time `json:"next_run,omitempty"`
+	LastRun *Run      `json:"last_run,omitempty"`
+}
+
+// Progress of a running task, counted from the events it emits.
+type Progress struct {
+	Directories uint64 `json:"directories"`
+	Files       uint64 `json:"files"`
+	Size        uint64 `json:"size"`
+	Errors      uint64 `json:"errors"`
+}
+
+type progress struct {
+	directories atomic.Uint64
+	files       atomic.Uint64
+	size        atomic.Uint64
+	errors      atomic.Uint64
+}
+
+func (p *progress) update(event interface{}) {
+	switch event := event.(type) {
+	case events.DirectoryOK:
+		p.directories.Add(1)
+	case events.FileOK:
+		p.files.Add(1)
+		p.size.Add(uint64(event.Size))
+	case events.PathError, events.DirectoryError, events.FileError,
+		events.DirectoryMissing, events.FileMissing, events.ObjectMissing, events.ChunkMissing,
+		events.DirectoryCorrupted, events.FileCorrupted, events.ObjectCorrupted, events.ChunkCorrupted:
+		p.errors.Add(1)
+	}
+}
+
+func (p *progress) get() Progress {
+	return Progress{
+		Directories: p.directories.Load(),
+		Files:       p.files.Load(),
+		Size:        p.size.Load(),
+		Errors:      p.errors.Load(),
+	}
+}
+
+type taskState struct {
+	status   TaskStatus
+	progress *progress
+}
+
+// eachTask calls fn with the key, type, taskset and repository of each task
+// of the configuration.
+func (config *Configuration) eachTask(fn func(key, typ, taskset, repository string)) {
+	for i, cleanupCfg := range config.Agent.Maintenance {
+		fn(fmt.Sprintf("maintenance/%d", i), "maintenance", "maintenance", cleanupCfg.Repository)
+	}
+	for _, tasksetCfg := range config.Agent.Tasks {
+		if tasksetCfg.Backup != nil {
+			fn(tasksetCfg.Name+"/backup", "backup", tasksetCfg.Name, tasksetCfg.Repository)
+		}
+		for i := range tasksetCfg.Check {
+			fn(fmt.Sprintf("%s/check/%d", tasksetCfg.Name, i), "check", tasksetCfg.Name, tasksetCfg.Repository)
+		}
+		for i := range tasksetCfg.Restore {
+			fn(fmt.Sprintf("%s/restore/%d", tasksetCfg.Name, i), "restore", tasksetCfg.Name, tasksetCfg.Repository)
+		}
+		for i := range tasksetCfg.Sync {
+			fn(fmt.Sprintf("%s/sync/%d", tasksetCfg.Name, i), "sync", tasksetCfg.Name, tasksetCfg.Repository)
+		}
+	}
+}
+
+// ConfigurationStatus returns the status of the tasks of a configuration no
+// scheduler runs, sorted by key, their last run being taken from history.
+func ConfigurationStatus(config *Configuration, history *History) []TaskStatus {
+	ret := make([]TaskStatus, 0)
+	config.eachTask(func(key, typ, taskset, repository string) {
+		ret = append(ret, TaskStatus{
+			Task:       key,
+			Type:       typ,
+			Taskset:    taskset,
+			Repository: repository,
+			LastRun:    history.Last(key),
+		})
+	})
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Task < ret[j].Task
+	})
+	return ret
+}
+
+func (s *Scheduler) register(key, typ, taskset, repository string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tasks[key] = &taskState{
+		status: TaskStatus{
+			Task:       key,
+			Type:       typ,
+			Taskset:    taskset,
+			Repository: repository,
+		},
+	}
+
+	// so that a restart does not look like a task never succeeded
+	if run := s.history.LastSuccess(key); run != nil {
+		metrics.TaskLastSuccess.WithLabelValues(taskset, key, typ).Set(float64(run.Started.Add(run.Duration).Unix()))
+	}
+}
+
+// measure records the I/O of a task on its repository, and the size of the
+// latter.  It must be called before the repository is closed.
+func (s *Scheduler) measure(key string, repo *repository.Repository) {
+	s.mu.Lock()
+	task := s.tasks[key]
+	s.mu.Unlock()
+	if task == nil {
+		return
+	}
+
+	labels := []string{task.status.Taskset, key, task.status.Type}
+	metrics.TaskReadBytes.WithLabelValues(labels...).Add(float64(repo.RBytes()))
+	metrics.TaskWrittenBytes.WithLabelValues(labels...).Add(float64(repo.WBytes()))
+	metrics.RepositorySize.WithLabelValues(task.status.Repository).Set(float64(repo.StorageSize()))
+}
+
+func (s *Scheduler) setNextRun(key string, next time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if task, ok := s.tasks[key]; ok {
+		task.status.NextRun = next
+	}
+}
+
+// run executes fn as a run of the task identified by key, in a context of
+// its own so that its progress can be followed, and records its outcome in
+// the history and its start as the last run of the task.
+func (s *Scheduler) run(key string, fn func(ctx *appcontext.AppContext) Result) Result {
+	ctx := appcontext.NewAppContextFrom(s.ctx)
+
+	p := &progress{}
+	listener := ctx.Events().Listen()
+	listening := make(chan struct{})
+	go func() {
+		for event := range listener {
+			p.update(event)
+		}
+		close(listening)
+	}()
+
+	started := time.Now()
+	s.mu.Lock()
+	task := s.tasks[key]
+	if task != nil {
+		task.status.Running = true
+		task.status.Started = started
+		task.progress = p
+		metrics.TaskRunning.WithLabelValues(task.status.Taskset, key, task.status.Type).Set(1)
+	}
+	s.mu.Unlock()
+
+	result := fn(ctx)
+	ctx.Close()
+	<-listening
+
+	run := Run{
+		Task:       key,
+		Started:    started,
+		Duration:   time.Since(started),
+		Status:     reporting.StatusOK,
+		SnapshotID: result.SnapshotID,
+	}
+	if result.Err != nil {
+		run.Status = reporting.StatusFailed
+		run.Error = result.Err.Error()
+	} else if result.Warning != nil {
+		run.Status = reporting.StatusWarning
+		run.Error = result.Warning.Error()
+	}
+
+	s.mu.Lock()
+	if task != nil {
+		run.Type = task.status.Type
+		run.Taskset = task.status.Taskset
+		run.Repository = task.status.Repository
+
+		task.status.Running = false
+		task.status.Progress = p.get()
+		task.progress = nil
+		task.status.LastRun = &run
+	}
+	s.mu.Unlock()
+	s.observe(run, p.get())
+
+	if err := s.history.Append(run); err != nil {
+		s.ctx.GetLogger().Warn("scheduler: could not record run of %s: %s", key, err)
+	}
+
+	// only once the run is over, so that a run cut short by the agent
+	// exiting is due again when it restarts
+	if err := s.state.SetLastRun(key, started); err != nil {
+		s.ctx.GetLogger().Warn("scheduler: could not record last run of %s: %s", key, err)
+	}
+	return result
+}
+
+func (s *Scheduler) observe(run Run, progress Progress) {
+	labels := []string{run.Taskset, run.Task, run.Type}
+	completed := float64(run.Started.Add(run.Duration).Unix())
+
+	metrics.TaskRunning.WithLabelValues(labels...).Set(0)
+	metrics.TaskRuns.WithLabelValues(append(labels, string(run.Status))...).Inc()
+	metrics.TaskDuration.WithLabelValues(labels...).Observe(run.Duration.Seconds())
+	metrics.TaskLastRun.WithLabelValues(labels...).Set(completed)
+	if run.Status != reporting.StatusFailed {
+		metrics.TaskLastSuccess.WithLabelValues(labels...).Set(completed)
+	}
+	metrics.TaskFiles.WithLabelValues(labels...).Add(float64(progress.Files))
+	metrics.TaskDirectories.WithLabelValues(labels...).Add(float64(progress.Directories))
+	metrics.TaskErrors.WithLabelValues(labels...).Add(float64(progress.Errors))
+}
+
+// Status returns the status of the tasks of the scheduler, sorted by key.
+func (s *Scheduler) Status() []TaskStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ret := make([]TaskStatus, 0, len(s.tasks))
+	for key, task := range s.tasks {
+		status := task.status
+		if task.progress != nil {
+			status.Progress = task.progress.get()
+		}
+		if status.LastRun == nil {
+			status.LastRun = s.history.Last(key)
+		}
+		ret = append(ret, status)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Task < ret[j].Task
+	})
+	return ret
+}
+
+func (s *Scheduler) History() *History {
+	return s.history
+}
@@ -9,10 +9,13 @@ import (
 	"github.com/PlakarKorp/kloset/storage"
 	"github.com/PlakarKorp/kloset/versioning"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/exclude"
 	"github.com/PlakarKorp/plakar/locate"
+	"github.com/PlakarKorp/plakar/reporting"
 	"github.com/PlakarKorp/plakar/subcommands/backup"
 	"github.com/PlakarKorp/plakar/subcommands/check"
 	"github.com/PlakarKorp/plakar/subcommands/maintenance"
+	"github.com/PlakarKorp/plakar/subcommands/prune"
 	"github.com/PlakarKorp/plakar/subcommands/restore"
 	"github.com/PlakarKorp/plakar/subcommands/rm"
 	"github.com/PlakarKorp/plakar/subcommands/sync"
@@ -65,139 +68,252 @@ func loadRepository(newCtx *appcontext.AppContext, name string) (*repository.Rep
 	return repo, store, nil
 }
 
-func (s *Scheduler) backupTask(taskset Task, task BackupConfig) {
+// loop runs the task identified by key each time it is due, and hands the
+// outcome of each run over to the tasks chained after it.
+func (s *Scheduler) loop(key string, timing Timing, fn func(ctx *appcontext.AppContext, trigger *Result) Result) {
+	for {
+		trigger, ok := s.wait(key, timing)
+		if !ok {
+			return
+		}
+		s.done(key, s.run(key, func(ctx *appcontext.AppContext) Result {
+			return fn(ctx, trigger)
+		}))
+	}
+}
+
+func (s *Scheduler) backupTask(key string, taskset Task, task BackupConfig) {
 	backupSubcommand := &backup.Backup{}
 	backupSubcommand.Silent = true
 	backupSubcommand.Job = taskset.Name
+	backupSubcommand.Name = task.Name
+	backupSubcommand.Category = task.Category
+	backupSubcommand.Environment = task.Environment
+	backupSubcommand.Perimeter = task.Perimeter
+	backupSubcommand.Tags = task.Tags
 	backupSubcommand.Path = task.Path
 	backupSubcommand.Quiet = true
-	backupSubcommand.Opts = make(map[string]string)
+	backupSubcommand.Concurrency = task.Concurrency
+	if backupSubcommand.Concurrency == 0 {
+		backupSubcommand.Concurrency = uint64(s.ctx.MaxConcurrency)
+	}
+	backupSubcommand.Opts = make(map[string]string, len(task.Options))
+	for k, v := range task.Options {
+		backupSubcommand.Opts[k] = v
+	}
+	backupSubcommand.Hooks = task.Hooks
 	if task.Check.Enabled {
 		backupSubcommand.OptCheck = true
 	}
 
 	rmSubcommand := &rm.Rm{}
 	rmSubcommand.LocateOptions = locate.NewDefaultLocateOptions()
-	rmSubcommand.LocateOptions.Job = task.Name
+	rmSubcommand.LocateOptions.Job = taskset.Name
 
-	for {
-		tick := time.After(task.Interval)
-		select {
-		case <-s.ctx.Done():
-			return
-		case <-tick:
-			repo, store, err := loadRepository(s.ctx, taskset.Repository)
-			if err != nil {
-				s.ctx.GetLogger().Error("Error loading repository: %s", err)
-				continue
-			}
-			reporter := s.NewTaskReporter(s.ctx, repo, "backup", taskset.Name, taskset.Repository)
-
-			var reportWarning error
-			if retval, err, snapId, warning := backupSubcommand.DoBackup(s.ctx, repo); err != nil || retval != 0 {
-				s.ctx.GetLogger().Error("Error creating backup: %s", err)
-				reporter.TaskFailed(1, "Error creating backup: retval=%d, err=%s", retval, err)
-				goto close
-			} else {
-				reportWarning = warning
-				reporter.WithSnapshotID(snapId)
-			}
+	pruneSubcommand := &prune.Prune{}
+	pruneSubcommand.LocateOptions = locate.NewDefaultLocateOptions()
+	pruneSubcommand.LocateOptions.Job = taskset.Name
+	pruneSubcommand.Policy = task.Keep
+	pruneSubcommand.GroupBy = []string{"name"}
 
-			if task.Retention != 0 {
-				rmSubcommand.LocateOptions.Before = time.Now().Add(-task.Retention)
-				if retval, err := rmSubcommand.Execute(s.ctx, repo); err != nil || retval != 0 {
-					s.ctx.GetLogger().Error("Error removing obsolete backups: %s", err)
-					reporter.TaskWarning("Error removing obsolete backups: retval=%d, err=%s", retval, err)
-					goto close
-				}
+	s.loop(key, task.Timing, func(ctx *appcontext.AppContext, _ *Result) Result {
+		repo, store, err := loadRepository(ctx, taskset.Repository)
+		if err != nil {
+			s.ctx.GetLogger().Error("Error loading repository: %s", err)
+			s.NewTaskReporter(ctx, nil, "backup", taskset.Name, taskset.Repository).TaskFailed(1, "Error loading repository: %s", err)
+			return Result{Err: err}
+		}
+		defer store.Close()
+		defer repo.Close()
+		defer s.measure(key, repo)
+		reporter := s.NewTaskReporter(ctx, repo, "backup", taskset.Name, taskset.Repository)
+
+		excludes, err := backupExcludes(task)
+		if err != nil {
+			s.ctx.GetLogger().Error("Error creating backup: %s", err)
+			reporter.TaskFailed(1, "Error creating backup: %s", err)
+			return Result{Err: err}
+		}
+		backupSubcommand.Excludes = excludes
+
+		retval, err, snapId, warning := backupSubcommand.DoBackup(ctx, repo)
+		reportHooks(reporter, backupSubcommand.HookResults)
+		if err != nil || retval != 0 {
+			s.ctx.GetLogger().Error("Error creating backup: %s", err)
+			reporter.TaskFailed(1, "Error creating backup: retval=%d, err=%s", retval, err)
+			return Result{Err: failure(retval, err)}
+		}
+		reporter.WithSnapshotID(snapId)
+
+		// the snapshot was created, failing to clean up the older ones
+		// only warrants a warning and does not fail the chain
+		result := Result{SnapshotID: snapId}
+		if task.Retention != 0 {
+			rmSubcommand.LocateOptions.Before = time.Now().Add(-task.Retention)
+			if retval, err := rmSubcommand.Execute(ctx, repo); err != nil || retval != 0 {
+				s.ctx.GetLogger().Error("Error removing obsolete backups: %s", err)
+				reporter.TaskWarning("Error removing obsolete backups: retval=%d, err=%s", retval, err)
+				result.Warning = failure(retval, err)
+				return result
 			}
-			if reportWarning != nil {
-				reporter.TaskWarning("Warning during backup: %s", reportWarning)
-			} else {
-				reporter.TaskDone()
+		}
+		if !task.Keep.Empty() {
+			if retval, err := pruneSubcommand.Execute(ctx, repo); err != nil || retval != 0 {
+				s.ctx.GetLogger().Error("Error pruning backups: %s", err)
+				reporter.TaskWarning("Error pruning backups: retval=%d, err=%s", retval, err)
+				result.Warning = failure(retval, err)
+				return result
 			}
+		}
+		if warning != nil {
+			reporter.TaskWarning("Warning during backup: %s", warning)
+			result.Warning = warning
+		} else {
+			reporter.TaskDone()
+		}
+		return result
+	})
+}
 
-		close:
-			repo.Close()
-			store.Close()
+// failure turns the outcome of a subcommand into the error of a run.
+func failure(retval int, err error) error {
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("exited with status %d", retval)
+}
+
+// backupExcludes gathers the exclusion patterns of a backup task in the
+// same order as the backup command does.  The exclude files are read on
+// every run, so that they can be edited without restarting the agent.
+func backupExcludes(task BackupConfig) ([]string, error) {
+	var excludes []string
+	for _, filename := range task.ExcludeFiles {
+		lines, err := exclude.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read excludes file: %w", err)
+		}
+		excludes = append(excludes, lines...)
+	}
+	excludes = append(excludes, task.Excludes...)
+
+	for _, item := range excludes {
+		if err := exclude.Validate(item); err != nil {
+			return nil, fmt.Errorf("failed to compile exclude pattern: %w", err)
 		}
 	}
+	return excludes, nil
 }
 
-func (s *Scheduler) checkTask(taskset Task, task CheckConfig) {
+func reportHooks(reporter *reporting.Reporter, results []*backup.HookResult) {
+	for _, result := range results {
+		hook := reporting.ReportHook{
+			Name:     result.Hook,
+			Command:  result.Command,
+			ExitCode: result.ExitCode,
+			Duration: result.Duration,
+			Output:   result.Output,
+		}
+		if result.Err != nil {
+			hook.Error = result.Err.Error()
+		}
+		reporter.WithHook(hook)
+	}
+}
+
+func (s *Scheduler) checkTask(key string, taskset Task, task CheckConfig) {
 	checkSubcommand := &check.Check{}
 	checkSubcommand.LocateOptions = locate.NewDefaultLocateOptions()
 	checkSubcommand.LocateOptions.Job = taskset.Name
 	checkSubcommand.LocateOptions.Latest = task.Latest
 	checkSubcommand.Silent = true
+	var snapshots []string
 	if task.Path != "" {
-		checkSubcommand.Snapshots = []string{":" + task.Path}
+		snapshots = []string{":" + task.Path}
 	}
 
-	for {
-		tick := time.After(task.Interval)
-		select {
-		case <-s.ctx.Done():
-			return
-		case <-tick:
-			repo, store, err := loadRepository(s.ctx, taskset.Repository)
-			if err != nil {
-				s.ctx.GetLogger().Error("Error loading repository: %s", err)
-				continue
-			}
-			reporter := s.NewTaskReporter(s.ctx, repo, "check", taskset.Name, taskset.Repository)
+	s.loop(key, task.Timing, func(ctx *appcontext.AppContext, trigger *Result) Result {
+		// chained after a backup, check the snapshot it just created
+		result := Result{}
+		checkSubcommand.Snapshots = snapshots
+		if trigger.HasSnapshot() {
+			result.SnapshotID = trigger.SnapshotID
+			checkSubcommand.Snapshots = []string{fmt.Sprintf("%x:%s", trigger.SnapshotID, task.Path)}
+		}
 
-			retval, err := checkSubcommand.Execute(s.ctx, repo)
-			if err != nil || retval != 0 {
-				s.ctx.GetLogger().Error("Error executing check: %s", err)
-				reporter.TaskFailed(1, "Error executing check: retval=%d, err=%s", retval, err)
-			} else {
-				reporter.TaskDone()
-			}
+		repo, store, err := loadRepository(ctx, taskset.Repository)
+		if err != nil {
+			s.ctx.GetLogger().Error("Error loading repository: %s", err)
+			s.NewTaskReporter(ctx, nil, "check", taskset.Name, taskset.Repository).TaskFailed(1, "Error loading repository: %s", err)
+			result.Err = err
+			return result
+		}
+		defer store.Close()
+		defer repo.Close()
+		defer s.measure(key, repo)
+		reporter := s.NewTaskReporter(ctx, repo, "check", taskset.Name, taskset.Repository)
+		if result.HasSnapshot() {
+			reporter.WithSnapshotID(result.SnapshotID)
+		}
 
-			repo.Close()
-			store.Close()
+		retval, err := checkSubcommand.Execute(ctx, repo)
+		if err != nil || retval != 0 {
+			s.ctx.GetLogger().Error("Error executing check: %s", err)
+			reporter.TaskFailed(1, "Error executing check: retval=%d, err=%s", retval, err)
+			result.Err = failure(retval, err)
+		} else {
+			reporter.TaskDone()
 		}
-	}
+		return result
+	})
 }
 
-func (s *Scheduler) restoreTask(taskset Task, task RestoreConfig) {
+func (s *Scheduler) restoreTask(key string, taskset Task, task RestoreConfig) {
 	restoreSubcommand := &restore.Restore{}
 	restoreSubcommand.OptJob = taskset.Name
 	restoreSubcommand.Target = task.Target
 	restoreSubcommand.Silent = true
+	var snapshots []string
 	if task.Path != "" {
-		restoreSubcommand.Snapshots = []string{":" + task.Path}
+		snapshots = []string{":" + task.Path}
 	}
 
-	for {
-		tick := time.After(task.Interval)
-		select {
-		case <-s.ctx.Done():
-			return
-		case <-tick:
-			repo, store, err := loadRepository(s.ctx, taskset.Repository)
-			if err != nil {
-				s.ctx.GetLogger().Error("Error loading repository: %s", err)
-				continue
-			}
-			reporter := s.NewTaskReporter(s.ctx, repo, "restore", taskset.Name, taskset.Repository)
+	s.loop(key, task.Timing, func(ctx *appcontext.AppContext, trigger *Result) Result {
+		result := Result{}
+		restoreSubcommand.Snapshots = snapshots
+		if trigger.HasSnapshot() {
+			result.SnapshotID = trigger.SnapshotID
+			restoreSubcommand.Snapshots = []string{fmt.Sprintf("%x:%s", trigger.SnapshotID, task.Path)}
+		}
 
-			retval, err := restoreSubcommand.Execute(s.ctx, repo)
-			if err != nil || retval != 0 {
-				s.ctx.GetLogger().Error("Error executing restore: %s", err)
-				reporter.TaskFailed(1, "Error executing restore: retval=%d, err=%s", retval, err)
-			} else {
-				reporter.TaskDone()
-			}
+		repo, store, err := loadRepository(ctx, taskset.Repository)
+		if err != nil {
+			s.ctx.GetLogger().Error("Error loading repository: %s", err)
+			s.NewTaskReporter(ctx, nil, "restore", taskset.Name, taskset.Repository).TaskFailed(1, "Error loading repository: %s", err)
+			result.Err = err
+			return result
+		}
+		defer store.Close()
+		defer repo.Close()
+		defer s.measure(key, repo)
+		reporter := s.NewTaskReporter(ctx, repo, "restore", taskset.Name, taskset.Repository)
+		if result.HasSnapshot() {
+			reporter.WithSnapshotID(result.SnapshotID)
+		}
 
-			repo.Close()
-			store.Close()
+		retval, err := restoreSubcommand.Execute(ctx, repo)
+		if err != nil || retval != 0 {
+			s.ctx.GetLogger().Error("Error executing restore: %s", err)
+			reporter.TaskFailed(1, "Error executing restore: retval=%d, err=%s", retval, err)
+			result.Err = failure(retval, err)
+		} else {
+			reporter.TaskDone()
 		}
-	}
+		return result
+	})
 }
 
-func (s *Scheduler) syncTask(taskset Task, task SyncConfig) {
+func (s *Scheduler) syncTask(key string, taskset Task, task SyncConfig) {
 	syncSubcommand := &sync.Sync{}
 	syncSubcommand.PeerRepositoryLocation = task.Peer
 	if task.Direction == SyncDirectionTo {
@@ -219,78 +335,80 @@ func (s *Scheduler) syncTask(taskset Task, task SyncConfig) {
 	//	syncSubcommand.Target = task.Target
 	//	syncSubcommand.Silent = true
 
-	for {
-		tick := time.After(task.Interval)
-		select {
-		case <-s.ctx.Done():
-			return
-		case <-tick:
-			repo, store, err := loadRepository(s.ctx, taskset.Repository)
-			if err != nil {
-				s.ctx.GetLogger().Error("Error loading repository: %s", err)
-				continue
-			}
-			reporter := s.NewTaskReporter(s.ctx, repo, "sync", taskset.Name, taskset.Repository)
-
-			retval, err := syncSubcommand.Execute(s.ctx, repo)
-			if err != nil || retval != 0 {
-				s.ctx.GetLogger().Error("sync: %s", err)
-				reporter.TaskFailed(1, "Error executing sync: retval=%d, err=%s", retval, err)
-			} else {
-				s.ctx.GetLogger().Info("sync: synchronization succeeded")
-				reporter.TaskDone()
+	s.loop(key, task.Timing, func(ctx *appcontext.AppContext, trigger *Result) Result {
+		// chained after a backup, only push the snapshot it just created
+		result := Result{}
+		syncSubcommand.SrcLocateOptions = nil
+		if trigger.HasSnapshot() {
+			result.SnapshotID = trigger.SnapshotID
+			if task.Direction == SyncDirectionTo {
+				syncSubcommand.SrcLocateOptions = locate.NewDefaultLocateOptions()
+				syncSubcommand.SrcLocateOptions.Prefix = fmt.Sprintf("%x", trigger.SnapshotID)
 			}
+		}
 
-			repo.Close()
-			store.Close()
+		repo, store, err := loadRepository(ctx, taskset.Repository)
+		if err != nil {
+			s.ctx.GetLogger().Error("Error loading repository: %s", err)
+			s.NewTaskReporter(ctx, nil, "sync", taskset.Name, taskset.Repository).TaskFailed(1, "Error loading repository: %s", err)
+			result.Err = err
+			return result
 		}
-	}
+		defer store.Close()
+		defer repo.Close()
+		defer s.measure(key, repo)
+		reporter := s.NewTaskReporter(ctx, repo, "sync", taskset.Name, taskset.Repository)
+
+		retval, err := syncSubcommand.Execute(ctx, repo)
+		if err != nil || retval != 0 {
+			s.ctx.GetLogger().Error("sync: %s", err)
+			reporter.TaskFailed(1, "Error executing sync: retval=%d, err=%s", retval, err)
+			result.Err = failure(retval, err)
+		} else {
+			s.ctx.GetLogger().Info("sync: synchronization succeeded")
+			reporter.TaskDone()
+		}
+		return result
+	})
 }
 
-func (s *Scheduler) maintenanceTask(task MaintenanceConfig) {
+func (s *Scheduler) maintenanceTask(key string, task MaintenanceConfig) {
 	maintenanceSubcommand := &maintenance.Maintenance{}
 	rmSubcommand := &rm.Rm{}
 	rmSubcommand.LocateOptions = locate.NewDefaultLocateOptions()
 	rmSubcommand.LocateOptions.Job = "maintenance"
 
-	for {
-		tick := time.After(task.Interval)
-		select {
-		case <-s.ctx.Done():
-			return
-		case <-tick:
-			repo, store, err := loadRepository(s.ctx, task.Repository)
-			if err != nil {
-				s.ctx.GetLogger().Error("Error loading repository: %s", err)
-				continue
-			}
-			reporter := s.NewTaskReporter(s.ctx, repo, "maintenance", "maintenance", task.Repository)
+	s.loop(key, task.Timing, func(ctx *appcontext.AppContext, _ *Result) Result {
+		repo, store, err := loadRepository(ctx, task.Repository)
+		if err != nil {
+			s.ctx.GetLogger().Error("Error loading repository: %s", err)
+			s.NewTaskReporter(ctx, nil, "maintenance", "maintenance", task.Repository).TaskFailed(1, "Error loading repository: %s", err)
+			return Result{Err: err}
+		}
+		defer store.Close()
+		defer repo.Close()
+		defer s.measure(key, repo)
+		reporter := s.NewTaskReporter(ctx, repo, "maintenance", "maintenance", task.Repository)
+
+		retval, err := maintenanceSubcommand.Execute(ctx, repo)
+		if err != nil || retval != 0 {
+			s.ctx.GetLogger().Error("Error executing maintenance: %s", err)
+			reporter.TaskFailed(1, "Error executing maintenance: retval=%d, err=%s", retval, err)
+			return Result{Err: failure(retval, err)}
+		}
+		s.ctx.GetLogger().Info("maintenance of repository %s succeeded", task.Repository)
 
-			retval, err := maintenanceSubcommand.Execute(s.ctx, repo)
+		if task.Retention != 0 {
+			rmSubcommand.LocateOptions.Before = time.Now().Add(-task.Retention)
+			retval, err = rmSubcommand.Execute(ctx, repo)
 			if err != nil || retval != 0 {
-				s.ctx.GetLogger().Error("Error executing maintenance: %s", err)
-				reporter.TaskFailed(1, "Error executing maintenance: retval=%d, err=%s", retval, err)
-				goto close
-			} else {
-				s.ctx.GetLogger().Info("maintenance of repository %s succeeded", task.Repository)
-			}
-
-			if task.Retention != 0 {
-				rmSubcommand.LocateOptions.Before = time.Now().Add(-task.Retention)
-				retval, err = rmSubcommand.Execute(s.ctx, repo)
-				if err != nil || retval != 0 {
-					s.ctx.GetLogger().Error("Error removing obsolete backups: %s", err)
-					reporter.TaskWarning("Error removing obsolete backups: retval=%d, err=%s", retval, err)
-					goto close
-				} else {
-					s.ctx.GetLogger().Info("Retention purge succeeded")
-				}
+				s.ctx.GetLogger().Error("Error removing obsolete backups: %s", err)
+				reporter.TaskWarning("Error removing obsolete backups: retval=%d, err=%s", retval, err)
+				return Result{Warning: failure(retval, err)}
 			}
-			reporter.TaskDone()
-
-		close:
-			repo.Close()
-			store.Close()
+			s.ctx.GetLogger().Info("Retention purge succeeded")
 		}
-	}
+		reporter.TaskDone()
+		return Result{}
+	})
 }
@@ -0,0 +1,339 @@
+package httpd
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+)
+
+// Role is what an authenticated client is allowed to do on the repository.
+type Role int
+
+const (
+	// RoleReadOnly clients can only fetch states, packfiles and locks.
+	RoleReadOnly Role = iota
+
+	// RoleAppendOnly clients can also push new states and packfiles, and
+	// take locks to do so, but never delete data nor the locks of others.
+	RoleAppendOnly
+
+	// RoleReadWrite clients can also delete states and packfiles, if the
+	// server allows deletion at all.
+	RoleReadWrite
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleReadOnly:
+		return "read-only"
+	case RoleAppendOnly:
+		return "append-only"
+	case RoleReadWrite:
+		return "read-write"
+	default:
+		return fmt.Sprintf("Role(%d)", int(r))
+	}
+}
+
+func ParseRole(s string) (Role, error) {
+	switch s {
+	case "read-only", "ro":
+		return RoleReadOnly, nil
+	case "append-only", "ao":
+		return RoleAppendOnly, nil
+	case "read-write", "rw":
+		return RoleReadWrite, nil
+	default:
+		return RoleReadOnly, fmt.Errorf("invalid role %q: must be one of read-only, append-only or read-write", s)
+	}
+}
+
+type token struct {
+	digest [sha256.Size]byte
+	role   Role
+}
+
+// readTokenFile loads bearer tokens from a file with one token per line,
+// optionally followed by the role it grants.  Tokens without a role get
+// defaultRole, empty lines and lines starting with # are ignored.
+func readTokenFile(filename string, defaultRole Role) ([]token, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	var tokens []token
+	scanner := bufio.NewScanner(fp)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("%s:%d: expected a token and an optional role", filename, lineno)
+		}
+
+		tok := token{digest: sha256.Sum256([]byte(fields[0])), role: defaultRole}
+		if len(fields) == 2 {
+			tok.role, err = ParseRole(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", filename, lineno, err)
+			}
+		}
+		tokens = append(tokens, tok)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%s: no token found", filename)
+	}
+	return tokens, nil
+}
+
+// tlsConfig builds the server TLS configuration from a certificate and key,
+// and requires clients to present a certificate signed by clientCA if set.
+func tlsConfig(certFile, keyFile, clientCA string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCA != "" {
+		pem, err := os.ReadFile(clientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificate found", clientCA)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+type roleKey struct{}
+
+// clientKey holds the clientID of the client.
+type clientKey struct{}
+
+// clientID is the digest of the token of a client, zero when the server
+// has no tokens.
+type clientID [sha256.Size]byte
+
+// authenticate resolves the role of the client, from its bearer token when
+// tokens are configured.  Client certificates are verified during the TLS
+// handshake already, so they need no further check here.
+func (s *server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role := s.role
+		var client clientID
+
+		if len(s.tokens) != 0 {
+			key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "missing bearer token", http.StatusUnauthorized)
+				return
+			}
+
+			digest := sha256.Sum256([]byte(key))
+			client = digest
+			found := false
+			for _, tok := range s.tokens {
+				if subtle.ConstantTimeCompare(digest[:], tok.digest[:]) == 1 {
+					role = tok.role
+					found = true
+				}
+			}
+			if !found {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "invalid bearer token", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		ctx := context.WithValue(r.Context(), roleKey{}, role)
+		ctx = context.WithValue(ctx, clientKey{}, client)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// require restricts a handler to clients having at least the given role.
+func (s *server) require(role Role, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		granted, ok := r.Context().Value(roleKey{}).(Role)
+		if !ok || granted < role {
+			http.Error(w, fmt.Sprintf("not allowed: %s access required", role), http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// claimUpload serializes the uploads of an object, and fails the request
+// with a conflict if a client below read-write is about to replace an
+// existing one, which would amount to deleting it.  get and list fetch and
+// list the objects of the kind being pushed.  Unless the request was
+// refused, the returned function must be called once the object is written.
+func (s *server) claimUpload(w http.ResponseWriter, r *http.Request, get func(objects.MAC) (io.Reader, error),
+	list func() ([]objects.MAC, error), mac objects.MAC) (func(), bool) {
+	release := s.uploads.lock(mac)
+	if granted, _ := r.Context().Value(roleKey{}).(Role); granted >= RoleReadWrite {
+		return release, true
+	}
+
+	found, err := exists(get, list, mac)
+	if err != nil {
+		release()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if found {
+		release()
+		http.Error(w, fmt.Sprintf("%x already exists: read-write access required to replace it", mac), http.StatusConflict)
+		return nil, false
+	}
+	return release, true
+}
+
+// exists tells whether the store holds mac by fetching it, rather than by
+// listing all the objects, unless the store doesn't report a missing object
+// in a way we know of.
+func exists(get func(objects.MAC) (io.Reader, error), list func() ([]objects.MAC, error), mac objects.MAC) (bool, error) {
+	rd, err := get(mac)
+	if err == nil {
+		// some stores only fail once the object is read
+		var b [1]byte
+		var n int
+		n, err = rd.Read(b[:])
+		if closer, ok := rd.(io.Closer); ok {
+			closer.Close()
+		}
+		if n != 0 {
+			return true, nil
+		}
+	}
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, repository.ErrPackfileNotFound) || errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+
+	macs, err := list()
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(macs, mac), nil
+}
+
+// takeLock records the client taking the lock mac, and fails the request
+// with a conflict if a client below read-write is about to replace a lock
+// it did not take.
+func (s *server) takeLock(w http.ResponseWriter, r *http.Request, mac objects.MAC) (func(), bool) {
+	release := s.uploads.lock(mac)
+	client, _ := r.Context().Value(clientKey{}).(clientID)
+	if granted, _ := r.Context().Value(roleKey{}).(Role); granted >= RoleReadWrite {
+		return release, true
+	}
+
+	s.locksMu.Lock()
+	owner, owned := s.locks[mac]
+	s.locksMu.Unlock()
+	if owned && owner == client {
+		return release, true
+	}
+
+	found, err := exists(s.store.GetLock, s.store.GetLocks, mac)
+	if err != nil {
+		release()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if found {
+		release()
+		http.Error(w, fmt.Sprintf("lock %x already exists: read-write access required to replace it", mac), http.StatusConflict)
+		return nil, false
+	}
+
+	s.locksMu.Lock()
+	if s.locks == nil {
+		s.locks = make(map[objects.MAC]clientID)
+	}
+	s.locks[mac] = client
+	s.locksMu.Unlock()
+	return release, true
+}
+
+// releaseLock fails the request if a client below read-write is about to
+// delete a lock it did not take, e.g. one of a maintenance client.
+func (s *server) releaseLock(w http.ResponseWriter, r *http.Request, mac objects.MAC) bool {
+	client, _ := r.Context().Value(clientKey{}).(clientID)
+	granted, _ := r.Context().Value(roleKey{}).(Role)
+
+	s.locksMu.Lock()
+	defer s.locksMu.Unlock()
+	if owner, owned := s.locks[mac]; granted < RoleReadWrite && (!owned || owner != client) {
+		http.Error(w, fmt.Sprintf("lock %x: read-write access required to delete a lock taken by another client", mac), http.StatusForbidden)
+		return false
+	}
+	delete(s.locks, mac)
+	return true
+}
+
+// uploads keeps track of the objects being written, so that two clients
+// can't both find one missing and then both write it.
+type uploads struct {
+	mu      sync.Mutex
+	pending map[objects.MAC]chan struct{}
+}
+
+// lock waits for the pending upload of mac, if any, and returns the function
+// ending the upload of the caller.
+func (u *uploads) lock(mac objects.MAC) func() {
+	for {
+		u.mu.Lock()
+		if u.pending == nil {
+			u.pending = make(map[objects.MAC]chan struct{})
+		}
+		done, ok := u.pending[mac]
+		if !ok {
+			done = make(chan struct{})
+			u.pending[mac] = done
+			u.mu.Unlock()
+			return func() {
+				u.mu.Lock()
+				delete(u.pending, mac)
+				u.mu.Unlock()
+				close(done)
+			}
+		}
+		u.mu.Unlock()
+		<-done
+	}
+}
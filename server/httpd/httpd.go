@@ -7,16 +7,44 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
+	"sync"
 
+	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/storage"
 	"github.com/PlakarKorp/plakar/network"
 )
 
+// Options configures the access to the repository exposed by the server.
+type Options struct {
+	// deletion of states and packfiles is refused, whatever the role
+	NoDelete bool
+
+	// role granted to clients, unless given by their token
+	Role Role
+
+	// file of bearer tokens required from clients, see readTokenFile
+	TokenFile string
+
+	// serve over TLS, requiring client certificates signed by TLSClientCA
+	// if set
+	TLSCert     string
+	TLSKey      string
+	TLSClientCA string
+}
+
 type server struct {
 	store    storage.Store
 	ctx      context.Context
 	noDelete bool
+	role     Role
+	tokens   []token
+	uploads  uploads
+
+	// the locks taken by clients below read-write, and by whom
+	locksMu sync.Mutex
+	locks   map[objects.MAC]clientID
 }
 
 func (s *server) openRepository(w http.ResponseWriter, r *http.Request) {
@@ -35,6 +63,9 @@ func (s *server) openRepository(w http.ResponseWriter, r *http.Request) {
 	var resOpen network.ResOpen
 	resOpen.Configuration = serializedConfig
 	resOpen.Err = ""
+	if slices.Contains(reqOpen.Protocols, network.ProtocolV2) {
+		resOpen.Protocol = network.ProtocolV2
+	}
 	if err := json.NewEncoder(w).Encode(resOpen); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -69,6 +100,12 @@ func (s *server) putState(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	release, ok := s.claimUpload(w, r, s.store.GetState, s.store.GetStates, reqPutState.MAC)
+	if !ok {
+		return
+	}
+	defer release()
+
 	var resPutIndex network.ResPutState
 	data := reqPutState.Data
 	_, err := s.store.PutState(reqPutState.MAC, bytes.NewBuffer(data))
@@ -157,6 +194,12 @@ func (s *server) putPackfile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	release, ok := s.claimUpload(w, r, s.store.GetPackfile, s.store.GetPackfiles, reqPutPackfile.MAC)
+	if !ok {
+		return
+	}
+	defer release()
+
 	var resPutPackfile network.ResPutPackfile
 	_, err := s.store.PutPackfile(reqPutPackfile.MAC, bytes.NewBuffer(reqPutPackfile.Data))
 	if err != nil {
@@ -268,6 +311,12 @@ func (s *server) putLock(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	release, ok := s.takeLock(w, r, req.Mac)
+	if !ok {
+		return
+	}
+	defer release()
+
 	var res network.ResPutLock
 	if _, err := s.store.PutLock(req.Mac, bytes.NewReader(req.Data)); err != nil {
 		res.Err = err.Error()
@@ -312,6 +361,10 @@ func (s *server) deleteLock(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !s.releaseLock(w, r, req.Mac) {
+		return
+	}
+
 	var res network.ResDeleteLock
 	if err := s.store.DeleteLock(req.Mac); err != nil {
 		res.Err = err.Error()
@@ -323,38 +376,71 @@ func (s *server) deleteLock(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func Server(ctx context.Context, repo *repository.Repository, addr string, noDelete bool) error {
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /", s.require(RoleReadOnly, s.openRepository))
+
+	mux.HandleFunc("GET /states", s.require(RoleReadOnly, s.getStates))
+	mux.HandleFunc("PUT /state", s.require(RoleAppendOnly, s.putState))
+	mux.HandleFunc("GET /state", s.require(RoleReadOnly, s.getState))
+	mux.HandleFunc("DELETE /state", s.require(RoleReadWrite, s.deleteState))
+
+	mux.HandleFunc("GET /packfiles", s.require(RoleReadOnly, s.getPackfiles))
+	mux.HandleFunc("PUT /packfile", s.require(RoleAppendOnly, s.putPackfile))
+	mux.HandleFunc("GET /packfile", s.require(RoleReadOnly, s.getPackfile))
+	mux.HandleFunc("GET /packfile/blob", s.require(RoleReadOnly, s.GetPackfileBlob))
+	mux.HandleFunc("DELETE /packfile", s.require(RoleReadWrite, s.deletePackfile))
+
+	mux.HandleFunc("GET /v2/states/{mac}", s.require(RoleReadOnly, s.streamState))
+	mux.HandleFunc("PUT /v2/states/{mac}", s.require(RoleAppendOnly, s.receiveState))
+	mux.HandleFunc("GET /v2/packfiles/{mac}", s.require(RoleReadOnly, s.streamPackfile))
+	mux.HandleFunc("PUT /v2/packfiles/{mac}", s.require(RoleAppendOnly, s.receivePackfile))
+
+	// writers need locks to push data, and must be able to release theirs
+	mux.HandleFunc("GET /locks", s.require(RoleReadOnly, s.getLocks))
+	mux.HandleFunc("PUT /lock", s.require(RoleAppendOnly, s.putLock))
+	mux.HandleFunc("GET /lock", s.require(RoleReadOnly, s.getLock))
+	mux.HandleFunc("DELETE /lock", s.require(RoleAppendOnly, s.deleteLock))
+
+	return s.authenticate(mux)
+}
+
+func Server(ctx context.Context, repo *repository.Repository, addr string, opts *Options) error {
 	s := server{
 		store:    repo.Store(),
 		ctx:      ctx,
-		noDelete: noDelete,
+		noDelete: opts.NoDelete,
+		role:     opts.Role,
 	}
 
-	mux := http.NewServeMux()
-
-	mux.HandleFunc("GET /", s.openRepository)
-
-	mux.HandleFunc("GET /states", s.getStates)
-	mux.HandleFunc("PUT /state", s.putState)
-	mux.HandleFunc("GET /state", s.getState)
-	mux.HandleFunc("DELETE /state", s.deleteState)
+	if opts.TokenFile != "" {
+		tokens, err := readTokenFile(opts.TokenFile, opts.Role)
+		if err != nil {
+			return err
+		}
+		s.tokens = tokens
+	}
 
-	mux.HandleFunc("GET /packfiles", s.getPackfiles)
-	mux.HandleFunc("PUT /packfile", s.putPackfile)
-	mux.HandleFunc("GET /packfile", s.getPackfile)
-	mux.HandleFunc("GET /packfile/blob", s.GetPackfileBlob)
-	mux.HandleFunc("DELETE /packfile", s.deletePackfile)
+	server := &http.Server{Addr: addr, Handler: s.handler()}
 
-	mux.HandleFunc("GET /locks", s.getLocks)
-	mux.HandleFunc("PUT /lock", s.putLock)
-	mux.HandleFunc("GET /lock", s.getLock)
-	mux.HandleFunc("DELETE /lock", s.deleteLock)
+	if opts.TLSCert != "" || opts.TLSKey != "" {
+		config, err := tlsConfig(opts.TLSCert, opts.TLSKey, opts.TLSClientCA)
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = config
+	} else if opts.TLSClientCA != "" {
+		return fmt.Errorf("client certificates require a server certificate and key")
+	}
 
-	server := &http.Server{Addr: addr, Handler: mux}
 	go func() {
 		<-repo.AppContext().Done()
 		server.Shutdown(repo.AppContext().Context)
 	}()
 
+	if server.TLSConfig != nil {
+		return server.ListenAndServeTLS("", "")
+	}
 	return server.ListenAndServe()
 }
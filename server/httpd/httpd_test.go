@@ -0,0 +1,282 @@
+package httpd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/storage"
+	httpstorage "github.com/PlakarKorp/plakar/connectors/http/storage"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRole(t *testing.T) {
+	for _, role := range []Role{RoleReadOnly, RoleAppendOnly, RoleReadWrite} {
+		parsed, err := ParseRole(role.String())
+		require.NoError(t, err)
+		require.Equal(t, role, parsed)
+	}
+
+	_, err := ParseRole("admin")
+	require.Error(t, err)
+}
+
+func TestReadTokenFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "tokens")
+	err := os.WriteFile(filename, []byte("# comment\n\nreader read-only\nwriter\n"), 0600)
+	require.NoError(t, err)
+
+	tokens, err := readTokenFile(filename, RoleAppendOnly)
+	require.NoError(t, err)
+	require.Len(t, tokens, 2)
+	require.Equal(t, RoleReadOnly, tokens[0].role)
+	require.Equal(t, RoleAppendOnly, tokens[1].role)
+
+	err = os.WriteFile(filename, []byte("token superuser\n"), 0600)
+	require.NoError(t, err)
+	_, err = readTokenFile(filename, RoleReadOnly)
+	require.ErrorContains(t, err, ":1:")
+}
+
+func TestServerRoles(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, _ := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+
+	filename := filepath.Join(t.TempDir(), "tokens")
+	err := os.WriteFile(filename, []byte("reader read-only\nappender append-only\nwriter read-write\n"), 0600)
+	require.NoError(t, err)
+	tokens, err := readTokenFile(filename, RoleReadOnly)
+	require.NoError(t, err)
+
+	s := &server{store: repo.Store(), ctx: context.Background(), tokens: tokens}
+	ts := httptest.NewServer(s.handler())
+	t.Cleanup(ts.Close)
+
+	connect := func(token string) storage.Store {
+		store, err := httpstorage.NewStore(context.Background(), "http", map[string]string{
+			"location": ts.URL,
+			"token":    token,
+		})
+		require.NoError(t, err)
+		return store
+	}
+
+	mac := objects.MAC{0x10, 0x20}
+
+	_, err = connect("").GetStates()
+	require.ErrorContains(t, err, "missing bearer token")
+	_, err = connect("nope").GetStates()
+	require.ErrorContains(t, err, "invalid bearer token")
+
+	reader := connect("reader")
+	_, err = reader.Open(context.Background())
+	require.NoError(t, err)
+	_, err = reader.GetStates()
+	require.NoError(t, err)
+	_, err = reader.PutState(mac, bytes.NewReader([]byte("state")))
+	require.ErrorContains(t, err, "append-only access required")
+
+	appender := connect("appender")
+	_, err = appender.PutState(mac, bytes.NewReader([]byte("state")))
+	require.NoError(t, err)
+	err = appender.DeleteState(mac)
+	require.ErrorContains(t, err, "read-write access required")
+
+	// appending must not allow replacing what is already there, with
+	// either version of the protocol
+	_, err = appender.PutPackfile(mac, bytes.NewReader([]byte("packfile")))
+	require.NoError(t, err)
+	_, err = appender.PutPackfile(mac, bytes.NewReader([]byte("garbage")))
+	require.ErrorContains(t, err, "already exists")
+	_, err = appender.Open(context.Background())
+	require.NoError(t, err)
+	_, err = appender.PutPackfile(mac, bytes.NewReader([]byte("garbage")))
+	require.ErrorContains(t, err, "already exists")
+	_, err = appender.PutState(mac, bytes.NewReader([]byte("garbage")))
+	require.ErrorContains(t, err, "already exists")
+	rd, err := appender.GetPackfile(mac)
+	require.NoError(t, err)
+	data, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, []byte("packfile"), data)
+
+	// locks can only be released by the client that took them, unless
+	// it has read-write access
+	writer := connect("writer")
+	lock := objects.MAC{0x30}
+	_, err = appender.PutLock(lock, bytes.NewReader([]byte("lock")))
+	require.NoError(t, err)
+	_, err = appender.PutLock(lock, bytes.NewReader([]byte("refreshed")))
+	require.NoError(t, err)
+	require.NoError(t, appender.DeleteLock(lock))
+
+	_, err = writer.PutLock(lock, bytes.NewReader([]byte("maintenance")))
+	require.NoError(t, err)
+	_, err = appender.PutLock(lock, bytes.NewReader([]byte("stolen")))
+	require.ErrorContains(t, err, "already exists")
+	err = appender.DeleteLock(lock)
+	require.ErrorContains(t, err, "read-write access required")
+	require.NoError(t, writer.DeleteLock(lock))
+
+	// deletion must still be enabled on the server
+	s.noDelete = true
+	err = writer.DeleteState(mac)
+	require.ErrorContains(t, err, "not allowed to delete")
+	s.noDelete = false
+	err = writer.DeleteState(mac)
+	require.NoError(t, err)
+}
+
+func TestServerAppendConflict(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, _ := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+
+	s := &server{store: repo.Store(), ctx: context.Background(), role: RoleAppendOnly}
+	ts := httptest.NewServer(s.handler())
+	t.Cleanup(ts.Close)
+
+	put := func(path, data string) int {
+		req, err := http.NewRequest(http.MethodPut, ts.URL+path, strings.NewReader(data))
+		require.NoError(t, err)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	path := fmt.Sprintf("/v2/packfiles/%x", objects.MAC{0x33})
+	require.Equal(t, http.StatusNoContent, put(path, "packfile"))
+	require.Equal(t, http.StatusConflict, put(path, "garbage"))
+
+	// of concurrent uploads of a new object, only one gets to write it
+	path = fmt.Sprintf("/v2/states/%x", objects.MAC{0x34})
+	statuses := make(chan int, 8)
+	var wg sync.WaitGroup
+	for i := range cap(statuses) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses <- put(path, fmt.Sprintf("state %d", i))
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	created := 0
+	for status := range statuses {
+		if status == http.StatusNoContent {
+			created++
+		} else {
+			require.Equal(t, http.StatusConflict, status)
+		}
+	}
+	require.Equal(t, 1, created)
+}
+
+func TestServerStreaming(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, _ := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+
+	var mu sync.Mutex
+	var v2 int
+	s := &server{store: repo.Store(), ctx: context.Background(), role: RoleReadWrite}
+	handler := s.handler()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v2/") {
+			mu.Lock()
+			v2++
+			mu.Unlock()
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+
+	store, err := httpstorage.NewStore(context.Background(), "http", map[string]string{"location": ts.URL})
+	require.NoError(t, err)
+	_, err = store.Open(context.Background())
+	require.NoError(t, err)
+
+	data := make([]byte, 3<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	mac := objects.MAC{0x42}
+
+	n, err := store.PutPackfile(mac, bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), n)
+
+	rd, err := store.GetPackfile(mac)
+	require.NoError(t, err)
+	got, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, data, got)
+
+	rd, err = store.GetPackfileBlob(mac, 1<<20, 1000)
+	require.NoError(t, err)
+	got, err = io.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, data[1<<20:1<<20+1000], got)
+
+	_, err = store.PutState(mac, bytes.NewReader([]byte("state")))
+	require.NoError(t, err)
+	rd, err = store.GetState(mac)
+	require.NoError(t, err)
+	got, err = io.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, []byte("state"), got)
+
+	_, err = store.GetPackfile(objects.MAC{0x43})
+	require.Error(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, 6, v2)
+}
+
+func TestParseRange(t *testing.T) {
+	offset, length, err := parseRange("bytes=10-19")
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), offset)
+	require.Equal(t, uint32(10), length)
+
+	for _, header := range []string{"bytes=10-", "bytes=-10", "bytes=20-10", "items=0-1", "bytes=0-1,4-5"} {
+		_, _, err := parseRange(header)
+		require.Error(t, err, header)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("disk on fire")
+}
+
+func TestSendStreamAborts(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// large enough for the headers to reach the client before the abort
+		rd := io.MultiReader(bytes.NewReader(make([]byte, 64<<10)), failingReader{})
+		sendStream(w, http.StatusOK, rd, 128<<10)
+	}))
+	t.Cleanup(ts.Close)
+
+	res, err := http.Get(ts.URL)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, int64(128<<10), res.ContentLength)
+	_, err = io.ReadAll(res.Body)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,178 @@
+package httpd
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/objects"
+)
+
+// Version 2 of the protocol moves states and packfiles as raw bodies so that
+// neither side has to hold them in memory, and serves packfile blobs through
+// HTTP range requests.
+
+func parseMAC(s string) (objects.MAC, error) {
+	var mac objects.MAC
+	data, err := hex.DecodeString(s)
+	if err != nil || len(data) != len(mac) {
+		return mac, fmt.Errorf("invalid MAC %q", s)
+	}
+	copy(mac[:], data)
+	return mac, nil
+}
+
+// parseRange parses a single "bytes=first-last" range, the only form the
+// client sends.
+func parseRange(header string) (offset uint64, length uint32, err error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return 0, 0, fmt.Errorf("unsupported range %q", header)
+	}
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("unsupported range %q", header)
+	}
+
+	start, err := strconv.ParseUint(first, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unsupported range %q", header)
+	}
+	end, err := strconv.ParseUint(last, 10, 64)
+	if err != nil || end < start || end-start >= 1<<32 {
+		return 0, 0, fmt.Errorf("unsupported range %q", header)
+	}
+	return start, uint32(end - start + 1), nil
+}
+
+// streamSize returns the number of bytes left in rd, or -1 if it can't
+// be known without reading it.
+func streamSize(rd io.Reader) int64 {
+	switch rd := rd.(type) {
+	case interface{ Len() int }:
+		return int64(rd.Len())
+	case *os.File:
+		info, err := rd.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return -1
+		}
+		offset, err := rd.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		return info.Size() - offset
+	}
+	return -1
+}
+
+// sendStream copies rd to the client, announcing size bytes when it is not
+// negative.
+func sendStream(w http.ResponseWriter, status int, rd io.Reader, size int64) {
+	if closer, ok := rd.(io.Closer); ok {
+		defer closer.Close()
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	w.WriteHeader(status)
+
+	n, err := io.Copy(w, rd)
+	if err != nil || (size >= 0 && n != size) {
+		// too late to report an error: reset the connection so that the
+		// client doesn't mistake a truncated body for a complete one.
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (s *server) streamState(w http.ResponseWriter, r *http.Request) {
+	mac, err := parseMAC(r.PathValue("mac"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rd, err := s.store.GetState(mac)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendStream(w, http.StatusOK, rd, streamSize(rd))
+}
+
+func (s *server) receiveState(w http.ResponseWriter, r *http.Request) {
+	mac, err := parseMAC(r.PathValue("mac"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	release, ok := s.claimUpload(w, r, s.store.GetState, s.store.GetStates, mac)
+	if !ok {
+		return
+	}
+	defer release()
+
+	if _, err := s.store.PutState(mac, r.Body); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) streamPackfile(w http.ResponseWriter, r *http.Request) {
+	mac, err := parseMAC(r.PathValue("mac"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rangeHeader := r.Header.Get("Range")
+	if rangeHeader == "" {
+		rd, err := s.store.GetPackfile(mac)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sendStream(w, http.StatusOK, rd, streamSize(rd))
+		return
+	}
+
+	offset, length, err := parseRange(rangeHeader)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	rd, err := s.store.GetPackfileBlob(mac, offset, length)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/*", offset, offset+uint64(length)-1))
+	sendStream(w, http.StatusPartialContent, rd, int64(length))
+}
+
+func (s *server) receivePackfile(w http.ResponseWriter, r *http.Request) {
+	mac, err := parseMAC(r.PathValue("mac"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	release, ok := s.claimUpload(w, r, s.store.GetPackfile, s.store.GetPackfiles, mac)
+	if !ok {
+		return
+	}
+	defer release()
+
+	if _, err := s.store.PutPackfile(mac, r.Body); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
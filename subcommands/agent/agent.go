@@ -29,6 +29,7 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/PlakarKorp/kloset/events"
 	"github.com/PlakarKorp/kloset/logging"
@@ -36,6 +37,8 @@ import (
 	"github.com/PlakarKorp/kloset/storage"
 	"github.com/PlakarKorp/plakar/agent"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/metrics"
+	"github.com/PlakarKorp/plakar/reporting"
 	"github.com/PlakarKorp/plakar/scheduler"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/task"
@@ -52,6 +55,10 @@ func init() {
 		subcommands.AgentSupport|subcommands.BeforeRepositoryOpen, "agent", "tasks", "start")
 	subcommands.Register(func() subcommands.Subcommand { return &AgentTasksStop{} },
 		subcommands.AgentSupport|subcommands.BeforeRepositoryOpen, "agent", "tasks", "stop")
+	subcommands.Register(func() subcommands.Subcommand { return &AgentStatus{} },
+		subcommands.AgentSupport|subcommands.BeforeRepositoryOpen, "agent", "status")
+	subcommands.Register(func() subcommands.Subcommand { return &AgentHistory{} },
+		subcommands.AgentSupport|subcommands.BeforeRepositoryOpen, "agent", "history")
 	subcommands.Register(func() subcommands.Subcommand { return &AgentRestart{} },
 		subcommands.AgentSupport|subcommands.BeforeRepositoryOpen|subcommands.IgnoreVersion, "agent", "reload")
 	subcommands.Register(func() subcommands.Subcommand { return &AgentRestart{} },
@@ -125,6 +132,7 @@ type AgentContext struct {
 	schedulerCtx    *appcontext.AppContext
 	schedulerConfig *scheduler.Configuration
 	schedulerState  schedulerState
+	scheduler       *scheduler.Scheduler
 	mtx             sync.Mutex
 }
 
@@ -476,7 +484,19 @@ func handleClient(ctx *appcontext.AppContext, wg *sync.WaitGroup, conn net.Conn)
 		eventsDone <- struct{}{}
 	}()
 
+	command := strings.Join(name, " ")
+	t0 := time.Now()
 	status, err := task.RunCommand(clientContext, subcommand, repo, "@agent")
+	metrics.CommandDuration.WithLabelValues(command).Observe(time.Since(t0).Seconds())
+	if status == 0 {
+		metrics.CommandRuns.WithLabelValues(command, string(reporting.StatusOK)).Inc()
+	} else {
+		metrics.CommandRuns.WithLabelValues(command, string(reporting.StatusFailed)).Inc()
+	}
+	if repo != nil {
+		metrics.CommandReadBytes.WithLabelValues(command).Add(float64(repo.RBytes()))
+		metrics.CommandWrittenBytes.WithLabelValues(command).Add(float64(repo.WBytes()))
+	}
 
 	errStr := ""
 	if err != nil {
@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/output"
+	"github.com/PlakarKorp/plakar/scheduler"
+	"github.com/PlakarKorp/plakar/subcommands"
+)
+
+type AgentHistory struct {
+	subcommands.SubcommandBase
+
+	Limit int
+	Task  string
+}
+
+func (cmd *AgentHistory) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("agent history", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] [TASK]\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+	flags.IntVar(&cmd.Limit, "limit", 20, "maximum number of runs to display, 0 for all")
+	flags.Parse(args)
+
+	if flags.NArg() > 1 {
+		return fmt.Errorf("too many arguments")
+	}
+	if cmd.Limit < 0 {
+		return fmt.Errorf("invalid limit: %d", cmd.Limit)
+	}
+	cmd.Task = flags.Arg(0)
+
+	return nil
+}
+
+func (cmd *AgentHistory) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	if agentContextSingleton == nil {
+		return 1, fmt.Errorf("agent not started")
+	}
+
+	agentContextSingleton.mtx.Lock()
+	sched := agentContextSingleton.scheduler
+	cacheDir := agentContextSingleton.agentCtx.CacheDir
+	agentContextSingleton.mtx.Unlock()
+
+	// the history outlives the scheduler, read it from disk if stopped
+	var history *scheduler.History
+	if sched != nil {
+		history = sched.History()
+	} else {
+		var err error
+		if history, err = scheduler.ReadHistory(cacheDir); err != nil {
+			return 1, err
+		}
+	}
+	runs := history.Runs(cmd.Task, cmd.Limit)
+
+	if cmd.OutputFormat != output.Text {
+		enc := output.NewEncoder(ctx.Stdout, cmd.OutputFormat)
+		for _, run := range runs {
+			if err := enc.Encode(run); err != nil {
+				return 1, err
+			}
+		}
+		if err := enc.Close(); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+
+	for _, run := range runs {
+		fmt.Fprintf(ctx.Stdout, "%s: %s\n", run.Task, formatRun(&run))
+	}
+	return 0, nil
+}
@@ -0,0 +1,115 @@
+package agent
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/output"
+	"github.com/PlakarKorp/plakar/scheduler"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/dustin/go-humanize"
+)
+
+type AgentStatus struct {
+	subcommands.SubcommandBase
+}
+
+func (cmd *AgentStatus) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("agent status", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS]\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		return fmt.Errorf("too many arguments")
+	}
+
+	return nil
+}
+
+func (cmd *AgentStatus) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	if agentContextSingleton == nil {
+		return 1, fmt.Errorf("agent not started")
+	}
+
+	agentContextSingleton.mtx.Lock()
+	sched := agentContextSingleton.scheduler
+	config := agentContextSingleton.schedulerConfig
+	cacheDir := agentContextSingleton.agentCtx.CacheDir
+	running := agentContextSingleton.schedulerState&AGENT_SCHEDULER_RUNNING != 0
+	agentContextSingleton.mtx.Unlock()
+
+	var tasks []scheduler.TaskStatus
+	if sched != nil {
+		tasks = sched.Status()
+	} else if config != nil {
+		// not started, the tasks are known but will not run
+		history, err := scheduler.ReadHistory(cacheDir)
+		if err != nil {
+			return 1, err
+		}
+		tasks = scheduler.ConfigurationStatus(config, history)
+	} else {
+		return 1, fmt.Errorf("agent scheduler does not have a configuration")
+	}
+
+	if cmd.OutputFormat != output.Text {
+		enc := output.NewEncoder(ctx.Stdout, cmd.OutputFormat)
+		for _, task := range tasks {
+			if err := enc.Encode(task); err != nil {
+				return 1, err
+			}
+		}
+		if err := enc.Close(); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+
+	if running {
+		fmt.Fprintf(ctx.Stdout, "scheduler: running\n")
+	} else {
+		fmt.Fprintf(ctx.Stdout, "scheduler: stopped\n")
+	}
+
+	now := time.Now()
+	for _, task := range tasks {
+		fmt.Fprintf(ctx.Stdout, "%s: %s task on %s\n", task.Task, task.Type, task.Repository)
+		if task.Running {
+			fmt.Fprintf(ctx.Stdout, "  running for %s: %d directories, %d files, %s, %d errors\n",
+				now.Sub(task.Started).Round(time.Second),
+				task.Progress.Directories, task.Progress.Files,
+				humanize.Bytes(task.Progress.Size), task.Progress.Errors)
+		}
+		if task.LastRun != nil {
+			fmt.Fprintf(ctx.Stdout, "  last run: %s\n", formatRun(task.LastRun))
+		}
+		if !running {
+			continue
+		}
+		if task.NextRun.IsZero() {
+			fmt.Fprintf(ctx.Stdout, "  next run: none scheduled\n")
+		} else {
+			fmt.Fprintf(ctx.Stdout, "  next run: %s\n", task.NextRun.UTC().Format(time.RFC3339))
+		}
+	}
+	return 0, nil
+}
+
+func formatRun(run *scheduler.Run) string {
+	ret := fmt.Sprintf("%s %s in %s",
+		run.Started.UTC().Format(time.RFC3339), run.Status, run.Duration.Round(time.Second))
+	if run.SnapshotID != (objects.MAC{}) {
+		ret += fmt.Sprintf(", snapshot %x", run.SnapshotID[:4])
+	}
+	if run.Error != "" {
+		ret += ": " + run.Error
+	}
+	return ret
+}
@@ -61,7 +61,8 @@ func (cmd *AgentTasksConfigure) Execute(ctx *appcontext.AppContext, repo *reposi
 		agentContextSingleton.schedulerCtx.Cancel()
 		agentContextSingleton.schedulerCtx = appcontext.NewAppContextFrom(agentContextSingleton.agentCtx)
 
-		go scheduler.NewScheduler(agentContextSingleton.schedulerCtx, schedConfig).Run()
+		agentContextSingleton.scheduler = scheduler.NewScheduler(agentContextSingleton.schedulerCtx, schedConfig)
+		go agentContextSingleton.scheduler.Run()
 
 		fmt.Fprintf(ctx.Stderr, "done !\n")
 	}
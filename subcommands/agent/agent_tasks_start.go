@@ -47,7 +47,8 @@ func (cmd *AgentTasksStart) Execute(ctx *appcontext.AppContext, repo *repository
 
 	// this needs to execute in the agent context, not the client context
 	agentContextSingleton.schedulerCtx = appcontext.NewAppContextFrom(agentContextSingleton.agentCtx)
-	go scheduler.NewScheduler(agentContextSingleton.schedulerCtx, agentContextSingleton.schedulerConfig).Run()
+	agentContextSingleton.scheduler = scheduler.NewScheduler(agentContextSingleton.schedulerCtx, agentContextSingleton.schedulerConfig)
+	go agentContextSingleton.scheduler.Run()
 
 	agentContextSingleton.schedulerState = AGENT_SCHEDULER_RUNNING
 	return 0, nil
@@ -45,6 +45,7 @@ func (cmd *AgentTasksStop) Execute(ctx *appcontext.AppContext, repo *repository.
 	agentContextSingleton.schedulerState = AGENT_SCHEDULER_STOPPED
 	fmt.Fprintf(ctx.Stderr, "done !\n")
 	agentContextSingleton.schedulerCtx = nil
+	agentContextSingleton.scheduler = nil
 
 	return 0, nil
 }
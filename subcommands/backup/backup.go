@@ -18,20 +18,22 @@ package backup
 
 import (
 	"bufio"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/kloset/snapshot/importer"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/exclude"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/utils"
 	"github.com/dustin/go-humanize"
-	"github.com/gobwas/glob"
 )
 
 func init() {
@@ -71,6 +73,7 @@ func (e *tagFlags) asList() []string {
 
 func (cmd *Backup) Parse(ctx *appcontext.AppContext, args []string) error {
 	var opt_exclude_file string
+	var opt_include_file string
 	var opt_exclude excludeFlags
 	var opt_tags tagFlags
 
@@ -80,7 +83,7 @@ func (cmd *Backup) Parse(ctx *appcontext.AppContext, args []string) error {
 
 	flags := flag.NewFlagSet("backup", flag.ExitOnError)
 	flags.Usage = func() {
-		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] path\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] path...\n", flags.Name())
 		fmt.Fprintf(flags.Output(), "       %s [OPTIONS] @LOCATION\n", flags.Name())
 		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
 		flags.PrintDefaults()
@@ -88,42 +91,55 @@ func (cmd *Backup) Parse(ctx *appcontext.AppContext, args []string) error {
 
 	flags.Uint64Var(&cmd.Concurrency, "concurrency", uint64(ctx.MaxConcurrency), "maximum number of parallel tasks")
 	flags.Var(&opt_tags, "tag", "comma-separated list of tags to apply to the snapshot")
-	flags.StringVar(&opt_exclude_file, "exclude-file", "", "path to a file containing newline-separated regex patterns, treated as -exclude")
-	flags.Var(&opt_exclude, "exclude", "glob pattern to exclude files, can be specified multiple times to add several exclusion patterns")
+	flags.StringVar(&cmd.Name, "name", "", "name of the snapshot")
+	flags.StringVar(&cmd.Category, "category", "", "category of the snapshot")
+	flags.StringVar(&cmd.Environment, "environment", "", "environment of the snapshot")
+	flags.StringVar(&cmd.Perimeter, "perimeter", "", "perimeter of the snapshot")
+	flags.StringVar(&opt_exclude_file, "exclude-file", "", "path to a file containing newline-separated .gitignore-style patterns, treated as -exclude")
+	flags.StringVar(&opt_include_file, "include-file", "", "path to a file containing newline-separated paths to back up in addition to the arguments")
+	flags.Var(&opt_exclude, "exclude", ".gitignore-style pattern to exclude files, can be specified multiple times to add several exclusion patterns")
 	flags.BoolVar(&cmd.Quiet, "quiet", false, "suppress output")
 	flags.BoolVar(&cmd.Silent, "silent", false, "suppress ALL output")
 	flags.BoolVar(&cmd.OptCheck, "check", false, "check the snapshot after creating it")
 	flags.Var(utils.NewOptsFlag(cmd.Opts), "o", "specify extra importer options")
 	flags.BoolVar(&cmd.DryRun, "scan", false, "do not actually perform a backup, just list the files")
+	flags.StringVar(&cmd.Hooks.PreBackup, "pre-hook", "", "shell command to run before the backup, aborting it on failure")
+	flags.StringVar(&cmd.Hooks.PostBackup, "post-hook", "", "shell command to run after a successful backup")
+	flags.StringVar(&cmd.Hooks.OnFailure, "fail-hook", "", "shell command to run if the backup fails")
+	flags.DurationVar(&cmd.Hooks.Timeout, "hook-timeout", DefaultHookTimeout, "maximum duration of each hook")
 	//flags.BoolVar(&opt_stdio, "stdio", false, "output one line per file to stdout instead of the default interactive output")
 	flags.Parse(args)
 
-	if flags.NArg() > 1 {
-		return fmt.Errorf("Too many arguments")
+	if opt_exclude_file != "" {
+		lines, err := exclude.ReadFile(opt_exclude_file)
+		if err != nil {
+			return fmt.Errorf("unable to read excludes file: %w", err)
+		}
+		excludes = append(excludes, lines...)
 	}
+	excludes = append(excludes, opt_exclude...)
 
-	for _, item := range opt_exclude {
-		if _, err := glob.Compile(item); err != nil {
-			return fmt.Errorf("failed to compile exclude pattern: %s", item)
+	for _, item := range excludes {
+		if err := exclude.Validate(item); err != nil {
+			return fmt.Errorf("failed to compile exclude pattern: %w", err)
 		}
-		excludes = append(excludes, item)
 	}
 
-	if opt_exclude_file != "" {
-		fp, err := os.Open(opt_exclude_file)
+	paths := flags.Args()
+	if opt_include_file != "" {
+		fp, err := os.Open(opt_include_file)
 		if err != nil {
-			return fmt.Errorf("unable to open excludes file: %w", err)
+			return fmt.Errorf("unable to open include file: %w", err)
 		}
 		defer fp.Close()
 
 		scanner := bufio.NewScanner(fp)
 		for scanner.Scan() {
-			line := scanner.Text()
-			_, err := glob.Compile(line)
-			if err != nil {
-				return fmt.Errorf("failed to compile exclude pattern: %s", line)
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
 			}
-			excludes = append(excludes, line)
+			paths = append(paths, line)
 		}
 		if err := scanner.Err(); err != nil {
 			ctx.GetLogger().Error("%s", err)
@@ -133,11 +149,15 @@ func (cmd *Backup) Parse(ctx *appcontext.AppContext, args []string) error {
 
 	cmd.RepositorySecret = ctx.GetSecret()
 	cmd.Excludes = excludes
-	cmd.Path = flags.Arg(0)
 	cmd.Tags = opt_tags.asList()
 
-	if cmd.Path == "" {
+	switch len(paths) {
+	case 0:
 		cmd.Path = "fs:" + ctx.CWD
+	case 1:
+		cmd.Path = paths[0]
+	default:
+		cmd.Paths = paths
 	}
 
 	return nil
@@ -147,15 +167,24 @@ type Backup struct {
 	subcommands.SubcommandBase
 
 	Job         string
+	Name        string
+	Category    string
+	Environment string
+	Perimeter   string
 	Concurrency uint64
 	Tags        []string
 	Excludes    []string
 	Silent      bool
 	Quiet       bool
 	Path        string
+	Paths       []string
 	OptCheck    bool
 	Opts        map[string]string
 	DryRun      bool
+	Hooks       Hooks
+
+	// filled by DoBackup with the hooks that ran
+	HookResults []*HookResult
 }
 
 func (cmd *Backup) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
@@ -164,50 +193,126 @@ func (cmd *Backup) Execute(ctx *appcontext.AppContext, repo *repository.Reposito
 }
 
 func (cmd *Backup) DoBackup(ctx *appcontext.AppContext, repo *repository.Repository) (int, error, objects.MAC, error) {
+	cmd.HookResults = nil
+	if cmd.DryRun {
+		return cmd.doBackup(ctx, repo)
+	}
+
+	if cmd.Hooks.PreBackup != "" {
+		if err := cmd.runHook(ctx, HookPreBackup, cmd.Hooks.PreBackup); err != nil {
+			err = fmt.Errorf("backup aborted: %w", err)
+			cmd.runFailureHook(ctx, err)
+			return 1, err, objects.MAC{}, nil
+		}
+	}
+
+	ret, err, snapshotID, warning := cmd.doBackup(ctx, repo)
+	if err != nil || ret != 0 {
+		cmd.runFailureHook(ctx, err)
+		return ret, err, snapshotID, warning
+	}
+
+	if cmd.Hooks.PostBackup != "" {
+		status := "success"
+		if warning != nil {
+			status = "warning"
+		}
+		if err := cmd.runHook(ctx, HookPostBackup, cmd.Hooks.PostBackup,
+			"PLAKAR_STATUS="+status,
+			fmt.Sprintf("PLAKAR_SNAPSHOT_ID=%x", snapshotID)); err != nil {
+			warning = errors.Join(warning, err)
+		}
+	}
+	return ret, err, snapshotID, warning
+}
+
+func (cmd *Backup) runHook(ctx *appcontext.AppContext, hook, command string, env ...string) error {
+	paths := cmd.Paths
+	if len(paths) == 0 {
+		paths = []string{cmd.Path}
+	}
+	env = append(env,
+		"PLAKAR_JOB="+cmd.Job,
+		"PLAKAR_PATHS="+strings.Join(paths, "\n"))
+
+	result := runHook(ctx, hook, command, cmd.Hooks.Timeout, env, cmd.Silent)
+	cmd.HookResults = append(cmd.HookResults, result)
+	if result.Err != nil {
+		ctx.GetLogger().Error("backup: %s", result.Err)
+	} else {
+		ctx.GetLogger().Info("backup: %s hook completed in %s", hook, result.Duration.Round(time.Millisecond))
+	}
+	return result.Err
+}
+
+func (cmd *Backup) runFailureHook(ctx *appcontext.AppContext, err error) {
+	if cmd.Hooks.OnFailure == "" {
+		return
+	}
+	message := "unknown error"
+	if err != nil {
+		message = err.Error()
+	}
+	cmd.runHook(ctx, HookOnFailure, cmd.Hooks.OnFailure,
+		"PLAKAR_STATUS=failure",
+		"PLAKAR_ERROR="+message)
+}
+
+func (cmd *Backup) doBackup(ctx *appcontext.AppContext, repo *repository.Repository) (int, error, objects.MAC, error) {
 	opts := &snapshot.BackupOptions{
 		MaxConcurrency: cmd.Concurrency,
 		Name:           "default",
 		Tags:           cmd.Tags,
-		Excludes:       cmd.Excludes,
+	}
+	if cmd.Name != "" {
+		opts.Name = cmd.Name
 	}
 
-	scanDir := "fs:" + ctx.CWD
-	if cmd.Path != "" {
-		scanDir = cmd.Path
+	paths := cmd.Paths
+	if len(paths) == 0 {
+		scanDir := "fs:" + ctx.CWD
+		if cmd.Path != "" {
+			scanDir = cmd.Path
+		}
+		paths = []string{scanDir}
 	}
 
-	if strings.HasPrefix(scanDir, "@") {
-		remote, ok := ctx.Config.GetSource(scanDir[1:])
-		if !ok {
-			return 1, fmt.Errorf("could not resolve importer: %s", scanDir), objects.MAC{}, nil
+	// the importers are cancelled together if one of them fails to scan
+	scanCtx := appcontext.NewAppContextFrom(ctx)
+	defer scanCtx.Close()
+
+	importers := make([]importer.Importer, 0, len(paths))
+	for _, scanDir := range paths {
+		imp, err := cmd.newImporter(scanCtx, scanDir)
+		if err == nil {
+			imp, err = withExcludes(imp, cmd.Excludes)
 		}
-		if _, ok := remote["location"]; !ok {
-			return 1, fmt.Errorf("could not resolve importer location: %s", scanDir), objects.MAC{}, nil
-		} else {
-			// inherit all the options -- but the ones
-			// specified in the command line takes the
-			// precendence.
-			for k, v := range remote {
-				if _, found := cmd.Opts[k]; !found {
-					cmd.Opts[k] = v
-				}
+		if err != nil {
+			for _, imp := range importers {
+				imp.Close()
 			}
+			return 1, err, objects.MAC{}, nil
 		}
+		importers = append(importers, imp)
 	}
 
-	// Now that we have resolved the possible @ syntax let's apply the scandir.
-	if _, found := cmd.Opts["location"]; !found {
-		cmd.Opts["location"] = scanDir
-	}
-
-	imp, err := importer.NewImporter(ctx.GetInner(), ctx.ImporterOpts(), cmd.Opts)
-	if err != nil {
-		return 1, fmt.Errorf("failed to create an importer for %s: %s", scanDir, err), objects.MAC{}, nil
+	var imp importer.Importer = importers[0]
+	var multi *multiImporter
+	if len(importers) > 1 {
+		var err error
+		multi, err = newMultiImporter(importers, scanCtx.Cancel)
+		if err != nil {
+			for _, imp := range importers {
+				imp.Close()
+			}
+			return 1, err, objects.MAC{}, nil
+		}
+		imp = multi
 	}
 	defer imp.Close()
 
 	if cmd.DryRun {
-		if err := dryrun(ctx, imp, cmd.Excludes); err != nil {
+		if err := dryrun(ctx, imp); err != nil {
 			return 1, err, objects.MAC{}, nil
 		}
 		return 0, nil, objects.MAC{}, nil
@@ -223,6 +328,18 @@ func (cmd *Backup) DoBackup(ctx *appcontext.AppContext, repo *repository.Reposit
 	if cmd.Job != "" {
 		snap.Header.Job = cmd.Job
 	}
+	if cmd.Category != "" {
+		snap.Header.Category = cmd.Category
+	}
+	if cmd.Environment != "" {
+		snap.Header.Environment = cmd.Environment
+	}
+	if cmd.Perimeter != "" {
+		snap.Header.Perimeter = cmd.Perimeter
+	}
+	if multi != nil {
+		multi.bindSources(snap.Header)
+	}
 
 	if cmd.Silent {
 		if err := snap.Backup(imp, opts); err != nil {
@@ -274,11 +391,9 @@ func (cmd *Backup) DoBackup(ctx *appcontext.AppContext, repo *repository.Reposit
 		humanize.Bytes(uint64(snap.Repository().WBytes())),
 	)
 
-	totalErrors := uint64(0)
-	for i := 0; i < len(snap.Header.Sources); i++ {
-		s := snap.Header.GetSource(i)
-		totalErrors += s.Summary.Directory.Errors + s.Summary.Below.Errors
-	}
+	// the sources following the first one only describe parts of it
+	s := snap.Header.GetSource(0)
+	totalErrors := s.Summary.Directory.Errors + s.Summary.Below.Errors
 	var warning error
 	if totalErrors > 0 {
 		warning = fmt.Errorf("%d errors during backup", totalErrors)
@@ -286,45 +401,49 @@ func (cmd *Backup) DoBackup(ctx *appcontext.AppContext, repo *repository.Reposit
 	return 0, nil, snap.Header.Identifier, warning
 }
 
-func dryrun(ctx *appcontext.AppContext, imp importer.Importer, excludePatterns []string) error {
-	scanner, err := imp.Scan()
-	if err != nil {
-		return fmt.Errorf("failed to scan: %w", err)
-	}
-
-	excludes := []glob.Glob{}
-	for _, item := range excludePatterns {
-		g, err := glob.Compile(item)
-		if err != nil {
-			return fmt.Errorf("failed to compile exclude pattern: %s", item)
-		}
-		excludes = append(excludes, g)
+// newImporter resolves a path or @source argument into an importer.  Options
+// given on the command line take precedence over those of the source.
+func (cmd *Backup) newImporter(ctx *appcontext.AppContext, scanDir string) (importer.Importer, error) {
+	opts := make(map[string]string, len(cmd.Opts))
+	for k, v := range cmd.Opts {
+		opts[k] = v
 	}
 
-	errors := false
-	for record := range scanner {
-		var pathname string
-		switch {
-		case record.Record != nil:
-			pathname = record.Record.Pathname
-		case record.Error != nil:
-			pathname = record.Error.Pathname
+	if strings.HasPrefix(scanDir, "@") {
+		remote, ok := ctx.Config.GetSource(scanDir[1:])
+		if !ok {
+			return nil, fmt.Errorf("could not resolve importer: %s", scanDir)
 		}
-
-		skip := false
-		for _, exclude := range excludes {
-			if exclude.Match(pathname) {
-				skip = true
-				break
-			}
+		if _, ok := remote["location"]; !ok {
+			return nil, fmt.Errorf("could not resolve importer location: %s", scanDir)
 		}
-		if skip {
-			if record.Record != nil {
-				record.Record.Close()
+		for k, v := range remote {
+			if _, found := opts[k]; !found {
+				opts[k] = v
 			}
-			continue
 		}
+	}
 
+	// Now that we have resolved the possible @ syntax let's apply the scandir.
+	if _, found := opts["location"]; !found {
+		opts["location"] = scanDir
+	}
+
+	imp, err := importer.NewImporter(ctx.GetInner(), ctx.ImporterOpts(), opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create an importer for %s: %s", scanDir, err)
+	}
+	return imp, nil
+}
+
+func dryrun(ctx *appcontext.AppContext, imp importer.Importer) error {
+	scanner, err := imp.Scan()
+	if err != nil {
+		return fmt.Errorf("failed to scan: %w", err)
+	}
+
+	errors := false
+	for record := range scanner {
 		switch {
 		case record.Error != nil:
 			errors = true
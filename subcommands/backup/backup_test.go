@@ -7,17 +7,21 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/PlakarKorp/kloset/caching"
 	"github.com/PlakarKorp/kloset/hashing"
 	"github.com/PlakarKorp/kloset/logging"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/importer"
 	"github.com/PlakarKorp/kloset/storage"
 	"github.com/PlakarKorp/kloset/versioning"
 	"github.com/PlakarKorp/plakar/appcontext"
 	_ "github.com/PlakarKorp/plakar/connectors/fs/importer"
 	bfs "github.com/PlakarKorp/plakar/connectors/fs/storage"
+	"github.com/PlakarKorp/plakar/locate"
 	"github.com/stretchr/testify/require"
 )
 
@@ -49,7 +53,7 @@ func generateFixtures(t *testing.T, bufOut *bytes.Buffer, bufErr *bytes.Buffer)
 	require.NoError(t, err)
 	err = os.WriteFile(tmpBackupDir+"/subdir/foo.txt", []byte("hello foo"), 0644)
 	require.NoError(t, err)
-	err = os.WriteFile(tmpBackupDir+"/subdir/to_exclude", []byte("*/subdir/to_exclude\n"), 0644)
+	err = os.WriteFile(tmpBackupDir+"/subdir/to_exclude", []byte("/subdir/to_exclude\n"), 0644)
 	require.NoError(t, err)
 	err = os.WriteFile(tmpBackupDir+"/another_subdir/bar", []byte("hello bar"), 0644)
 	require.NoError(t, err)
@@ -167,3 +171,272 @@ func TestExecuteCmdCreateDefaultWithExcludes(t *testing.T) {
 	lastline := lines[len(lines)-1]
 	require.Contains(t, lastline, "created unsigned snapshot")
 }
+
+func TestExecuteCmdCreateMultiplePaths(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, tmpBackupDir, ctx := generateFixtures(t, bufOut, bufErr)
+
+	includeFile := tmpBackupDir + "/include"
+	err := os.WriteFile(includeFile, []byte("# more paths\n"+tmpBackupDir+"/another_subdir\n"), 0644)
+	require.NoError(t, err)
+
+	ctx.MaxConcurrency = 1
+	args := []string{"-include-file", includeFile, tmpBackupDir + "/subdir"}
+
+	subcommand := &Backup{}
+	err = subcommand.Parse(ctx, args)
+	require.NoError(t, err)
+	require.Equal(t, []string{tmpBackupDir + "/subdir", tmpBackupDir + "/another_subdir"}, subcommand.Paths)
+
+	status, err, snapshotID, _ := subcommand.DoBackup(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	snap, err := snapshot.Load(repo, snapshotID)
+	require.NoError(t, err)
+	defer snap.Close()
+
+	require.Equal(t, tmpBackupDir, snap.Header.GetSource(0).Importer.Directory)
+	require.Equal(t, []string{tmpBackupDir + "/subdir", tmpBackupDir + "/another_subdir"}, locate.Sources(snap.Header))
+
+	root, err := locate.SourceRoot(snap.Header, "1")
+	require.NoError(t, err)
+	require.Equal(t, tmpBackupDir+"/another_subdir", root)
+
+	// each root is described by a source of its own
+	require.Len(t, snap.Header.Sources, 3)
+	source := snap.Header.GetSource(2)
+	require.Equal(t, "fs", source.Importer.Type)
+	require.Equal(t, snap.Header.GetSource(0).Importer.Origin, source.Importer.Origin)
+	require.Equal(t, tmpBackupDir+"/another_subdir", source.Importer.Directory)
+	require.Equal(t, uint64(1), source.Summary.Directory.Files)
+	require.Equal(t, uint64(len("hello bar")), source.Summary.Directory.Size)
+	require.Equal(t, uint64(3), snap.Header.GetSource(1).Summary.Directory.Files)
+
+	fs, err := snap.Filesystem()
+	require.NoError(t, err)
+	var pathnames []string
+	for pathname, err := range fs.Pathnames() {
+		require.NoError(t, err)
+		pathnames = append(pathnames, pathname)
+	}
+	require.Contains(t, pathnames, tmpBackupDir+"/subdir/foo.txt")
+	require.Contains(t, pathnames, tmpBackupDir+"/another_subdir/bar")
+	require.NotContains(t, pathnames, tmpBackupDir+"/include")
+}
+
+func TestExecuteCmdCreateOverlappingPaths(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, tmpBackupDir, ctx := generateFixtures(t, bufOut, bufErr)
+
+	ctx.MaxConcurrency = 1
+	args := []string{tmpBackupDir, tmpBackupDir + "/subdir"}
+
+	subcommand := &Backup{}
+	err := subcommand.Parse(ctx, args)
+	require.NoError(t, err)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.ErrorContains(t, err, "overlapping paths")
+	require.Equal(t, 1, status)
+}
+
+func TestExecuteCmdCreateScanWithExcludes(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, tmpBackupDir, ctx := generateFixtures(t, bufOut, bufErr)
+
+	ctx.MaxConcurrency = 1
+	ctx.Stdout = bufOut
+	args := []string{"-scan", "-exclude-file", tmpBackupDir + "/subdir/to_exclude", "-exclude", "another_subdir/", tmpBackupDir}
+
+	subcommand := &Backup{}
+	err := subcommand.Parse(ctx, args)
+	require.NoError(t, err)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	output := bufOut.String()
+	require.Contains(t, output, tmpBackupDir+"/subdir/foo.txt\n")
+	require.NotContains(t, output, "to_exclude")
+	require.NotContains(t, output, "another_subdir")
+}
+
+func TestExecuteCmdCreateMetadata(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, tmpBackupDir, ctx := generateFixtures(t, bufOut, bufErr)
+	ctx.MaxConcurrency = 1
+
+	args := []string{
+		"-silent",
+		"-name", "www",
+		"-category", "web",
+		"-environment", "staging",
+		"-perimeter", "eu-west",
+		tmpBackupDir,
+	}
+
+	subcommand := &Backup{}
+	err := subcommand.Parse(ctx, args)
+	require.NoError(t, err)
+
+	status, err, snapshotID, _ := subcommand.DoBackup(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	snap, err := snapshot.Load(repo, snapshotID)
+	require.NoError(t, err)
+	defer snap.Close()
+
+	require.Equal(t, "www", snap.Header.Name)
+	require.Equal(t, "web", snap.Header.Category)
+	require.Equal(t, "staging", snap.Header.Environment)
+	require.Equal(t, "eu-west", snap.Header.Perimeter)
+
+	opts := locate.NewDefaultLocateOptions()
+	opts.Environment = "staging"
+	require.True(t, opts.Match(snapshotID, snap.Header))
+	opts.Environment = "production"
+	require.False(t, opts.Match(snapshotID, snap.Header))
+}
+
+func TestExecuteCmdCreateHooks(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, tmpBackupDir, ctx := generateFixtures(t, bufOut, bufErr)
+	ctx.MaxConcurrency = 1
+
+	marker := t.TempDir() + "/post_hook"
+
+	args := []string{
+		"-silent",
+		"-pre-hook", "echo quiescing $PLAKAR_HOOK",
+		"-post-hook", "echo $PLAKAR_STATUS $PLAKAR_SNAPSHOT_ID > " + marker,
+		tmpBackupDir,
+	}
+
+	subcommand := &Backup{}
+	err := subcommand.Parse(ctx, args)
+	require.NoError(t, err)
+
+	status, err, snapshotID, _ := subcommand.DoBackup(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	require.Len(t, subcommand.HookResults, 2)
+	require.Equal(t, HookPreBackup, subcommand.HookResults[0].Hook)
+	require.Equal(t, "quiescing pre-backup\n", subcommand.HookResults[0].Output)
+	require.NoError(t, subcommand.HookResults[1].Err)
+
+	data, err := os.ReadFile(marker)
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf("success %x\n", snapshotID), string(data))
+}
+
+func TestExecuteCmdCreatePreHookAborts(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, tmpBackupDir, ctx := generateFixtures(t, bufOut, bufErr)
+	ctx.MaxConcurrency = 1
+
+	args := []string{
+		"-silent",
+		"-pre-hook", "echo database is busy; exit 3",
+		"-fail-hook", "echo $PLAKAR_STATUS: $PLAKAR_ERROR",
+		"-hook-timeout", "10s",
+		tmpBackupDir,
+	}
+
+	subcommand := &Backup{}
+	err := subcommand.Parse(ctx, args)
+	require.NoError(t, err)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.ErrorContains(t, err, "pre-backup hook exited with status 3")
+	require.Equal(t, 1, status)
+
+	require.Len(t, subcommand.HookResults, 2)
+	require.Equal(t, 3, subcommand.HookResults[0].ExitCode)
+	require.Equal(t, HookOnFailure, subcommand.HookResults[1].Hook)
+	require.Equal(t, "failure: backup aborted: pre-backup hook exited with status 3\n", subcommand.HookResults[1].Output)
+
+	snapshots, err := repo.GetSnapshots()
+	require.NoError(t, err)
+	require.Empty(t, snapshots)
+}
+
+func TestRunHookTimeout(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+
+	result := runHook(ctx, HookPreBackup, "sleep 5", 100*time.Millisecond, nil, true)
+	require.ErrorContains(t, result.Err, "timed out")
+	require.Less(t, result.Duration, 3*time.Second)
+}
+
+type closeCounter struct {
+	io.Reader
+	closed *int
+}
+
+func (c closeCounter) Close() error {
+	*c.closed++
+	return nil
+}
+
+type fakeImporter struct {
+	root    string
+	records []*importer.ScanResult
+	err     error
+}
+
+func (f *fakeImporter) Origin() string { return "localhost" }
+func (f *fakeImporter) Type() string   { return "fake" }
+func (f *fakeImporter) Root() string   { return f.root }
+func (f *fakeImporter) Close() error   { return nil }
+
+func (f *fakeImporter) Scan() (<-chan *importer.ScanResult, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	results := make(chan *importer.ScanResult, len(f.records))
+	for _, record := range f.records {
+		results <- record
+	}
+	close(results)
+	return results, nil
+}
+
+func TestMultiImporterScanError(t *testing.T) {
+	closed := 0
+	records := make([]*importer.ScanResult, 0, 3)
+	for _, name := range []string{"a", "b", "c"} {
+		records = append(records, &importer.ScanResult{Record: &importer.ScanRecord{
+			Pathname: "/first/" + name,
+			Reader:   closeCounter{strings.NewReader(name), &closed},
+		}})
+	}
+
+	cancelled := false
+	multi, err := newMultiImporter([]importer.Importer{
+		&fakeImporter{root: "/first", records: records},
+		&fakeImporter{root: "/second", err: fmt.Errorf("scan failed")},
+	}, func() { cancelled = true })
+	require.NoError(t, err)
+
+	// the first scan is cancelled rather than read to the end
+	_, err = multi.Scan()
+	require.ErrorContains(t, err, "scan failed")
+	require.True(t, cancelled)
+	require.Zero(t, closed)
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package backup
+
+import (
+	"github.com/PlakarKorp/kloset/snapshot/importer"
+	"github.com/PlakarKorp/plakar/exclude"
+)
+
+// filterImporter applies exclusion rules to the scan of importers that
+// can't do it themselves while walking.
+type filterImporter struct {
+	importer.Importer
+	excludes *exclude.Ruleset
+}
+
+func withExcludes(imp importer.Importer, patterns []string) (importer.Importer, error) {
+	if len(patterns) == 0 {
+		return imp, nil
+	}
+
+	if excluder, ok := imp.(exclude.Excluder); ok {
+		if err := excluder.AddExcludes(patterns); err != nil {
+			return nil, err
+		}
+		return imp, nil
+	}
+
+	excludes := exclude.NewRuleset()
+	if err := excludes.Add(imp.Root(), patterns); err != nil {
+		return nil, err
+	}
+	return &filterImporter{Importer: imp, excludes: excludes}, nil
+}
+
+func (f *filterImporter) Scan() (<-chan *importer.ScanResult, error) {
+	scanner, err := f.Importer.Scan()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(chan *importer.ScanResult, 1000)
+	go func() {
+		defer close(results)
+		for record := range scanner {
+			switch {
+			case record.Record != nil:
+				if f.excludes.Excluded(record.Record.Pathname, record.Record.FileInfo.IsDir()) {
+					record.Record.Close()
+					continue
+				}
+			case record.Error != nil:
+				if f.excludes.Excluded(record.Error.Pathname, false) {
+					continue
+				}
+			}
+			results <- record
+		}
+	}()
+	return results, nil
+}
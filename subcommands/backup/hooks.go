@@ -0,0 +1,151 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/plakar/appcontext"
+)
+
+const (
+	HookPreBackup  = "pre-backup"
+	HookPostBackup = "post-backup"
+	HookOnFailure  = "on-failure"
+
+	DefaultHookTimeout = 5 * time.Minute
+
+	// hook output kept for the reports, the rest is dropped
+	maxHookOutput = 64 * 1024
+)
+
+// Hooks are shell commands run around a backup.  A failing pre-backup hook
+// aborts the backup, the on-failure hook runs whenever the backup does not
+// complete, and the post-backup hook only after a successful one.
+type Hooks struct {
+	PreBackup  string        `mapstructure:"pre_backup"`
+	PostBackup string        `mapstructure:"post_backup"`
+	OnFailure  string        `mapstructure:"on_failure"`
+	Timeout    time.Duration `mapstructure:"timeout"`
+}
+
+func (h Hooks) Empty() bool {
+	return h.PreBackup == "" && h.PostBackup == "" && h.OnFailure == ""
+}
+
+// HookResult records the execution of a hook, for reporting.
+type HookResult struct {
+	Hook     string
+	Command  string
+	ExitCode int
+	Duration time.Duration
+	Output   string
+	Err      error
+}
+
+// limitedBuffer keeps the first max bytes written to it, it is shared by
+// the stdout and stderr of a hook.
+type limitedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := b.max - b.buf.Len(); room > 0 {
+		if len(p) > room {
+			b.buf.Write(p[:room])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// runHook executes command through the shell with env added to the
+// environment.  Its output is captured and, unless silent, copied to the
+// standard output and error of the context.
+func runHook(ctx *appcontext.AppContext, hook, command string, timeout time.Duration, env []string, silent bool) *HookResult {
+	if timeout <= 0 {
+		timeout = DefaultHookTimeout
+	}
+
+	hctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(hctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(hctx, "/bin/sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Env = append(cmd.Env, "PLAKAR_HOOK="+hook)
+	cmd.WaitDelay = time.Second
+
+	output := &limitedBuffer{max: maxHookOutput}
+	if silent {
+		cmd.Stdout = output
+		cmd.Stderr = output
+	} else {
+		cmd.Stdout = io.MultiWriter(output, ctx.Stdout)
+		cmd.Stderr = io.MultiWriter(output, ctx.Stderr)
+	}
+
+	t0 := time.Now()
+	err := cmd.Run()
+
+	result := &HookResult{
+		Hook:     hook,
+		Command:  command,
+		Duration: time.Since(t0),
+		Output:   output.String(),
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		switch {
+		case errors.Is(hctx.Err(), context.DeadlineExceeded):
+			result.Err = fmt.Errorf("%s hook timed out after %s", hook, timeout)
+		case errors.As(err, &exitErr):
+			result.Err = fmt.Errorf("%s hook exited with status %d", hook, exitErr.ExitCode())
+		default:
+			result.Err = fmt.Errorf("%s hook failed: %w", hook, err)
+		}
+		result.ExitCode = -1
+		if exitErr != nil {
+			result.ExitCode = exitErr.ExitCode()
+		}
+	}
+	return result
+}
@@ -0,0 +1,293 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/snapshot/header"
+	"github.com/PlakarKorp/kloset/snapshot/importer"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+)
+
+// multiImporter merges the scans of several importers of the same type and
+// origin, so that a single snapshot covers all of their roots.  The snapshot
+// root becomes the deepest directory common to all of them.
+type multiImporter struct {
+	importers []importer.Importer
+	roots     []string
+	root      string
+
+	// cancels the context the importers were created with
+	cancel func()
+
+	// one per importer, see bindSources
+	sources []header.Source
+}
+
+func newMultiImporter(importers []importer.Importer, cancel func()) (*multiImporter, error) {
+	typ, origin := importers[0].Type(), importers[0].Origin()
+
+	roots := make([]string, 0, len(importers))
+	for _, imp := range importers {
+		if imp.Type() != typ || imp.Origin() != origin {
+			return nil, fmt.Errorf("can't mix %s://%s and %s://%s in a single snapshot",
+				typ, origin, imp.Type(), imp.Origin())
+		}
+		roots = append(roots, imp.Root())
+	}
+
+	for i := range roots {
+		for j := range roots {
+			if i != j && isUnder(roots[i], roots[j]) {
+				return nil, fmt.Errorf("overlapping paths: %s is within %s", roots[i], roots[j])
+			}
+		}
+	}
+
+	return &multiImporter{
+		importers: importers,
+		roots:     roots,
+		root:      commonRoot(roots),
+		cancel:    cancel,
+	}, nil
+}
+
+func isUnder(pathname, root string) bool {
+	return pathname == root || root == "/" || strings.HasPrefix(pathname, root+"/")
+}
+
+func commonRoot(roots []string) string {
+	common := roots[0]
+	for _, root := range roots[1:] {
+		for !isUnder(root, common) {
+			common = path.Dir(common)
+		}
+	}
+	return common
+}
+
+func (m *multiImporter) Origin() string {
+	return m.importers[0].Origin()
+}
+
+func (m *multiImporter) Type() string {
+	return m.importers[0].Type()
+}
+
+func (m *multiImporter) Root() string {
+	return m.root
+}
+
+// bindSources describes each root as a source of hdr, following the first
+// source that kloset fills for the snapshot as a whole.  Their summaries are
+// computed from the scan, which ends before the snapshot is committed, and
+// only account for what the scan tells: objects and content types are not
+// known yet.
+func (m *multiImporter) bindSources(hdr *header.Header) {
+	for _, imp := range m.importers {
+		source := header.NewSource()
+		source.Importer = header.Importer{
+			Type:      imp.Type(),
+			Origin:    imp.Origin(),
+			Directory: imp.Root(),
+		}
+		hdr.Sources = append(hdr.Sources, source)
+	}
+	m.sources = hdr.Sources[len(hdr.Sources)-len(m.importers):]
+}
+
+// sourceSummary accumulates the summary of the source rooted at root, its
+// direct children and what lies deeper being accounted for separately as
+// kloset does for directories.
+type sourceSummary struct {
+	root   string
+	direct vfs.Directory
+	below  vfs.Directory
+}
+
+func countEntry(d *vfs.Directory, info objects.FileInfo) {
+	d.Children++
+
+	switch mode := info.Mode(); {
+	case mode.IsDir():
+		d.Directories++
+		return
+	case mode.IsRegular():
+		d.Files++
+	case mode&os.ModeSymlink != 0:
+		d.Symlinks++
+	case mode&os.ModeDevice != 0:
+		d.Devices++
+	case mode&os.ModeNamedPipe != 0:
+		d.Pipes++
+	case mode&os.ModeSocket != 0:
+		d.Sockets++
+	default:
+		d.Files++
+	}
+
+	size := uint64(info.Size())
+	d.Size += size
+	if d.MinSize == 0 || size < d.MinSize {
+		d.MinSize = size
+	}
+	if size > d.MaxSize {
+		d.MaxSize = size
+	}
+
+	modTime := info.ModTime().Unix()
+	if d.MinModTime == 0 || modTime < d.MinModTime {
+		d.MinModTime = modTime
+	}
+	if modTime > d.MaxModTime {
+		d.MaxModTime = modTime
+	}
+}
+
+func (s *sourceSummary) update(result *importer.ScanResult) {
+	var pathname string
+	if result.Error != nil {
+		pathname = result.Error.Pathname
+	} else if result.Record != nil && !result.Record.IsXattr {
+		pathname = result.Record.Pathname
+	} else {
+		return
+	}
+	if pathname == s.root || !isUnder(pathname, s.root) {
+		return
+	}
+
+	if result.Error != nil {
+		s.below.Errors++
+	} else if path.Dir(pathname) == s.root {
+		countEntry(&s.direct, result.Record.FileInfo)
+	} else {
+		countEntry(&s.below, result.Record.FileInfo)
+	}
+}
+
+func (s *sourceSummary) summary() vfs.Summary {
+	summary := vfs.Summary{
+		Directory: s.direct,
+		Below: vfs.Below{
+			Directories: s.below.Directories,
+			Files:       s.below.Files,
+			Symlinks:    s.below.Symlinks,
+			Devices:     s.below.Devices,
+			Pipes:       s.below.Pipes,
+			Sockets:     s.below.Sockets,
+			Children:    s.below.Children,
+			MinSize:     s.below.MinSize,
+			MaxSize:     s.below.MaxSize,
+			Size:        s.below.Size,
+			MinModTime:  s.below.MinModTime,
+			MaxModTime:  s.below.MaxModTime,
+			Errors:      s.below.Errors,
+		},
+	}
+	summary.UpdateAverages()
+	return summary
+}
+
+// shared tells whether a pathname lies outside of all roots, that is one of
+// the parent directories that every importer emits.
+func (m *multiImporter) shared(pathname string) bool {
+	for _, root := range m.roots {
+		if isUnder(pathname, root) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *multiImporter) Scan() (<-chan *importer.ScanResult, error) {
+	scanners := make([]<-chan *importer.ScanResult, 0, len(m.importers))
+	for _, imp := range m.importers {
+		scanner, err := imp.Scan()
+		if err != nil {
+			// the scans already started are abandoned
+			m.cancel()
+			return nil, err
+		}
+		scanners = append(scanners, scanner)
+	}
+
+	results := make(chan *importer.ScanResult, 1000)
+
+	var mu sync.Mutex
+	seen := make(map[string]struct{})
+
+	var wg sync.WaitGroup
+	for i, scanner := range scanners {
+		wg.Add(1)
+		go func(i int, scanner <-chan *importer.ScanResult) {
+			defer wg.Done()
+
+			// the results channel is closed once every summary is set,
+			// so they are in the header before it is committed
+			summary := &sourceSummary{root: m.roots[i]}
+			if m.sources != nil {
+				defer func() { m.sources[i].Summary = summary.summary() }()
+			}
+
+			for record := range scanner {
+				summary.update(record)
+				if record.Record != nil {
+					pathname := record.Record.Pathname
+					if m.shared(pathname) {
+						key := pathname
+						if record.Record.IsXattr {
+							key += "\x00" + record.Record.XattrName
+						}
+						mu.Lock()
+						_, dup := seen[key]
+						seen[key] = struct{}{}
+						mu.Unlock()
+						if dup {
+							record.Record.Close()
+							continue
+						}
+					}
+				}
+				results <- record
+			}
+		}(i, scanner)
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results, nil
+}
+
+func (m *multiImporter) Close() error {
+	var err error
+	for _, imp := range m.importers {
+		if e := imp.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
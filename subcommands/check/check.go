@@ -17,7 +17,6 @@
 package check
 
 import (
-	"encoding/hex"
 	"flag"
 	"fmt"
 
@@ -25,6 +24,7 @@ import (
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/locate"
+	"github.com/PlakarKorp/plakar/output"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/google/uuid"
 )
@@ -77,8 +77,17 @@ type Check struct {
 }
 
 func (cmd *Check) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	var enc *output.Encoder
+	var processor *jsonProcessor
 	if !cmd.Silent {
-		go eventsProcessorStdio(ctx, cmd.Quiet)
+		if cmd.OutputFormat != output.Text {
+			enc = output.NewEncoder(ctx.Stdout, cmd.OutputFormat)
+			defer enc.Close()
+			processor = eventsProcessorJSON(ctx, enc, cmd.Quiet)
+			defer processor.close()
+		} else {
+			go eventsProcessorStdio(ctx, cmd.Quiet)
+		}
 	}
 
 	var snapshots []string
@@ -93,10 +102,13 @@ func (cmd *Check) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 	} else {
 		for _, snapshotPath := range cmd.Snapshots {
 			prefix, path := locate.ParseSnapshotPath(snapshotPath)
-			if prefix != "" {
-				if _, err := hex.DecodeString(prefix); err != nil {
-					return 1, fmt.Errorf("invalid snapshot prefix: %s", prefix)
+			if locate.IsReference(prefix) {
+				snapshotID, err := locate.LocateSnapshot(repo, prefix)
+				if err != nil {
+					return 1, err
 				}
+				snapshots = append(snapshots, fmt.Sprintf("%x:%s", snapshotID, path))
+				continue
 			}
 
 			cmd.LocateOptions.Prefix = prefix
@@ -132,19 +144,38 @@ func (cmd *Check) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 
 		snap.SetCheckCache(checkCache)
 
-		if !cmd.NoVerify && snap.Header.Identity.Identifier != uuid.Nil {
+		record := output.CheckRecord{
+			Snapshot:  snap.Header.Identifier,
+			Type:      output.CheckSnapshot,
+			Path:      pathname,
+			Status:    output.StatusOK,
+			Signature: output.SignatureUnsigned,
+		}
+
+		if cmd.NoVerify {
+			record.Signature = output.SignatureSkipped
+		} else if snap.Header.Identity.Identifier != uuid.Nil {
 			if ok, err := snap.Verify(); err != nil {
 				ctx.GetLogger().Warn("%s", err)
+				record.Signature = output.SignatureError
 			} else if !ok {
 				ctx.GetLogger().Info("snapshot %x signature verification failed", snap.Header.Identifier)
+				record.Signature = output.SignatureFailed
+				record.Status = output.StatusFailed
 				failures = true
 			} else {
 				ctx.GetLogger().Info("snapshot %x signature verification succeeded", snap.Header.Identifier)
+				record.Signature = output.SignatureVerified
 			}
 		}
 
+		if processor != nil {
+			processor.begin(snap.Header.Identifier)
+		}
 		if err := snap.Check(pathname, opts); err != nil {
 			ctx.GetLogger().Warn("%s", err)
+			record.Status = output.StatusFailed
+			record.Message = err.Error()
 			failures = true
 		}
 
@@ -154,6 +185,15 @@ func (cmd *Check) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 				pathname)
 		}
 
+		if enc != nil {
+			// wait for the records of this check to be written first
+			processor.wait(snap.Header.Identifier)
+			if err := enc.Encode(record); err != nil {
+				snap.Close()
+				return 1, err
+			}
+		}
+
 		snap.Close()
 	}
 
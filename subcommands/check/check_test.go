@@ -3,15 +3,19 @@ package check
 import (
 	"bytes"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/PlakarKorp/kloset/events"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
 	_ "github.com/PlakarKorp/plakar/connectors/fs/exporter"
+	"github.com/PlakarKorp/plakar/output"
 	ptesting "github.com/PlakarKorp/plakar/testing"
 	"github.com/stretchr/testify/require"
 )
@@ -106,3 +110,61 @@ func TestExecuteCmdCheckSpecificSnapshot(t *testing.T) {
 	lastline := lines[len(lines)-1]
 	require.Contains(t, lastline, fmt.Sprintf("info: check: verification of %s:%s completed successfully", hex.EncodeToString(snap.Header.GetIndexShortID()[:]), snap.Header.GetSource(0).Importer.Directory))
 }
+
+func TestExecuteCmdCheckJSON(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, snap, ctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	// keep the records apart from the info logs
+	bufJSON := bytes.NewBuffer(nil)
+	ctx.Stdout = bufJSON
+
+	subcommand := &Check{}
+	err := subcommand.Parse(ctx, []string{})
+	require.NoError(t, err)
+	subcommand.SetOutputFormat(output.NDJSON)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	var records []output.CheckRecord
+	for _, line := range strings.Split(strings.Trim(bufJSON.String(), "\n"), "\n") {
+		var record output.CheckRecord
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+
+	// one record per path checked, then the snapshot summary
+	require.Equal(t, 8, len(records))
+	for _, record := range records[:7] {
+		require.Equal(t, snap.Header.Identifier, record.Snapshot)
+		require.Contains(t, []string{output.CheckDirectory, output.CheckFile}, record.Type)
+		require.Equal(t, output.StatusOK, record.Status)
+	}
+
+	summary := records[7]
+	require.Equal(t, snap.Header.Identifier, summary.Snapshot)
+	require.Equal(t, output.CheckSnapshot, summary.Type)
+	require.Equal(t, output.StatusOK, summary.Status)
+	require.Equal(t, output.SignatureUnsigned, summary.Signature)
+
+	// events sent once the check is over are neither written nor blocking
+	sent := make(chan struct{})
+	go func() {
+		for range 3 {
+			ctx.Events().Send(events.DoneEvent())
+			ctx.Events().Send(events.FileOKEvent(snap.Header.Identifier, "/dummy", 0))
+		}
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("events sent after the check are blocked")
+	}
+	require.Equal(t, 8, strings.Count(bufJSON.String(), "\n"))
+}
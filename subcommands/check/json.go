@@ -0,0 +1,154 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package check
+
+import (
+	"sync"
+
+	"github.com/PlakarKorp/kloset/events"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/output"
+)
+
+// jsonProcessor turns the check events into records.  The caller announces
+// the snapshot it checks with begin, and wait returns once the events of
+// that check have all been written, so that the caller can emit its summary
+// record after them.
+type jsonProcessor struct {
+	mu       sync.Mutex
+	checking objects.MAC
+	pending  bool
+	closed   bool
+	done     chan objects.MAC
+}
+
+func eventsProcessorJSON(ctx *appcontext.AppContext, enc *output.Encoder, quiet bool) *jsonProcessor {
+	p := &jsonProcessor{done: make(chan objects.MAC, 1)}
+
+	// listen before returning so no event of the first check is missed
+	listener := ctx.Events().Listen()
+	go func() {
+		for event := range listener {
+			var record *output.CheckRecord
+			switch event := event.(type) {
+			case events.Done:
+				p.finish()
+			case events.DirectoryMissing:
+				record = pathRecord(event.SnapshotID, output.CheckDirectory, event.Pathname, output.StatusMissing, "")
+			case events.FileMissing:
+				record = pathRecord(event.SnapshotID, output.CheckFile, event.Pathname, output.StatusMissing, "")
+			case events.ObjectMissing:
+				record = macRecord(event.SnapshotID, output.CheckObject, event.MAC, output.StatusMissing)
+			case events.ChunkMissing:
+				record = macRecord(event.SnapshotID, output.CheckChunk, event.MAC, output.StatusMissing)
+
+			case events.DirectoryCorrupted:
+				record = pathRecord(event.SnapshotID, output.CheckDirectory, event.Pathname, output.StatusCorrupted, "")
+			case events.FileCorrupted:
+				record = pathRecord(event.SnapshotID, output.CheckFile, event.Pathname, output.StatusCorrupted, "")
+			case events.ObjectCorrupted:
+				record = macRecord(event.SnapshotID, output.CheckObject, event.MAC, output.StatusCorrupted)
+			case events.ChunkCorrupted:
+				record = macRecord(event.SnapshotID, output.CheckChunk, event.MAC, output.StatusCorrupted)
+
+			case events.DirectoryOK:
+				if !quiet {
+					record = pathRecord(event.SnapshotID, output.CheckDirectory, event.Pathname, output.StatusOK, "")
+				}
+			case events.FileOK:
+				if !quiet {
+					record = pathRecord(event.SnapshotID, output.CheckFile, event.Pathname, output.StatusOK, "")
+				}
+
+			case events.DirectoryError:
+				record = pathRecord(event.SnapshotID, output.CheckDirectory, event.Pathname, output.StatusError, event.Message)
+			case events.FileError:
+				record = pathRecord(event.SnapshotID, output.CheckFile, event.Pathname, output.StatusError, event.Message)
+			}
+
+			if record != nil && !p.isClosed() {
+				if err := enc.Encode(record); err != nil {
+					ctx.GetLogger().Warn("check: %s", err)
+				}
+			}
+		}
+	}()
+	return p
+}
+
+// begin announces the check of a snapshot.
+func (p *jsonProcessor) begin(snapshotID objects.MAC) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.checking = snapshotID
+	p.pending = true
+}
+
+// finish signals the end of the pending check, if any.  The events being
+// delivered in order, the records of the check are written by then.
+func (p *jsonProcessor) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || !p.pending {
+		return
+	}
+	p.pending = false
+	p.done <- p.checking
+}
+
+// wait returns once the check of snapshotID has been signaled.
+func (p *jsonProcessor) wait(snapshotID objects.MAC) {
+	for id := range p.done {
+		if id == snapshotID {
+			return
+		}
+	}
+}
+
+// close stops writing records.  The listener can't be detached from the
+// context, it is drained until the context itself is closed.
+func (p *jsonProcessor) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+}
+
+func (p *jsonProcessor) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+func pathRecord(snapshotID objects.MAC, typ, pathname, status, message string) *output.CheckRecord {
+	return &output.CheckRecord{
+		Snapshot: snapshotID,
+		Type:     typ,
+		Path:     pathname,
+		Status:   status,
+		Message:  message,
+	}
+}
+
+func macRecord(snapshotID objects.MAC, typ string, mac objects.MAC, status string) *output.CheckRecord {
+	return &output.CheckRecord{
+		Snapshot: snapshotID,
+		Type:     typ,
+		MAC:      &mac,
+		Status:   status,
+	}
+}
@@ -31,6 +31,7 @@ import (
 	"github.com/PlakarKorp/kloset/snapshot/vfs"
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/locate"
+	"github.com/PlakarKorp/plakar/output"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/utils"
 	"github.com/alecthomas/chroma/quick"
@@ -50,6 +51,7 @@ func (cmd *Diff) Parse(ctx *appcontext.AppContext, args []string) error {
 	}
 	flags.BoolVar(&cmd.Highlight, "highlight", false, "highlight output")
 	flags.BoolVar(&cmd.Recursive, "recursive", false, "recursive diff of directories")
+	flags.BoolVar(&cmd.Summary, "summary", false, "only list the changed entries, comparing their metadata and digests")
 	flags.Parse(args)
 
 	if flags.NArg() == 1 {
@@ -71,6 +73,7 @@ type Diff struct {
 
 	Highlight bool
 	Recursive bool
+	Summary   bool
 	Path1     string
 	Path2     string
 }
@@ -123,6 +126,45 @@ func (cmd *Diff) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 		pathname2 = pathname1
 	}
 
+	if cmd.Summary {
+		emit := func(record *output.DiffRecord) error {
+			return cmd.summary_line(ctx, record)
+		}
+
+		var enc *output.Encoder
+		if cmd.OutputFormat != output.Text {
+			enc = output.NewEncoder(ctx.Stdout, cmd.OutputFormat)
+			defer enc.Close()
+			emit = func(record *output.DiffRecord) error {
+				return enc.Encode(record)
+			}
+		}
+
+		err = cmd.diff_summary(ctx, emit, id1, vfs1, pathname1, id2, vfs2, pathname2)
+		if err != nil {
+			return 1, fmt.Errorf("diff: could not diff pathnames: %w", err)
+		}
+		if enc != nil {
+			if err := enc.Close(); err != nil {
+				return 1, err
+			}
+		}
+		return 0, nil
+	}
+
+	if cmd.OutputFormat != output.Text {
+		enc := output.NewEncoder(ctx.Stdout, cmd.OutputFormat)
+		defer enc.Close()
+		err = cmd.diff_records(ctx, enc, id1, vfs1, pathname1, id2, vfs2, pathname2)
+		if err != nil {
+			return 1, fmt.Errorf("diff: could not diff pathnames: %w", err)
+		}
+		if err := enc.Close(); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+
 	diff, err = cmd.diff_pathnames(ctx, id1, vfs1, pathname1, id2, vfs2, pathname2)
 	if err != nil {
 		return 1, fmt.Errorf("diff: could not diff pathnames: %w", err)
@@ -3,11 +3,18 @@ package diff
 import (
 	"bytes"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"os"
+	"path"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
 	_ "github.com/PlakarKorp/plakar/connectors/fs/exporter"
+	"github.com/PlakarKorp/plakar/output"
 	ptesting "github.com/PlakarKorp/plakar/testing"
 	"github.com/stretchr/testify/require"
 )
@@ -112,3 +119,170 @@ func TestExecuteCmdDiffFiles(t *testing.T) {
 -hello dummy
 +hello dummy!!`)
 }
+
+func TestExecuteCmdDiffJSON(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy"),
+		ptesting.NewMockFile("subdir/removed.txt", 0644, "removed"),
+		ptesting.NewMockFile("subdir/type", 0644, "a file"),
+	})
+	snap.Close()
+
+	snap2 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy"),
+		ptesting.NewMockFile("subdir/added.txt", 0644, "added"),
+		ptesting.NewMockDir("subdir/type"),
+	})
+	snap2.Close()
+
+	indexId1 := snap.Header.GetIndexShortID()
+	indexId2 := snap2.Header.GetIndexShortID()
+	dir1 := path.Join(snap.Header.GetSource(0).Importer.Directory, "subdir")
+	dir2 := path.Join(snap2.Header.GetSource(0).Importer.Directory, "subdir")
+
+	subcommand := &Diff{}
+	err := subcommand.Parse(ctx, []string{
+		fmt.Sprintf("%s:%s", hex.EncodeToString(indexId1[:]), dir1),
+		fmt.Sprintf("%s:%s", hex.EncodeToString(indexId2[:]), dir2),
+	})
+	require.NoError(t, err)
+	subcommand.SetOutputFormat(output.JSON)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	var records []output.DiffRecord
+	require.NoError(t, json.Unmarshal(bufOut.Bytes(), &records))
+	require.Equal(t, 3, len(records))
+
+	require.Equal(t, output.DiffAdded, records[0].Change)
+	require.Equal(t, dir2+"/added.txt", records[0].Path)
+	require.Nil(t, records[0].Old)
+	require.Equal(t, int64(len("added")), records[0].New.Size())
+
+	require.Equal(t, output.DiffRemoved, records[1].Change)
+	require.Equal(t, dir1+"/removed.txt", records[1].Path)
+	require.Nil(t, records[1].New)
+
+	require.Equal(t, output.DiffTypeChanged, records[2].Change)
+	require.Equal(t, dir1+"/type", records[2].Path)
+	require.False(t, records[2].Old.IsDir())
+	require.True(t, records[2].New.IsDir())
+}
+
+func TestExecuteCmdDiffSummary(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockDir("another_subdir"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy"),
+		ptesting.NewMockFile("subdir/foo.txt", 0644, "hello foo"),
+		ptesting.NewMockFile("subdir/removed.txt", 0644, "removed"),
+		ptesting.NewMockFile("subdir/type", 0644, "a file"),
+		ptesting.NewMockFile("another_subdir/bar", 0644, "hello bar"),
+	})
+	snap.Close()
+
+	snap2 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockDir("another_subdir"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy!!"),
+		ptesting.NewMockFile("subdir/foo.txt", 0755, "hello foo"),
+		ptesting.NewMockFile("subdir/added.txt", 0644, "added"),
+		ptesting.NewMockDir("subdir/type"),
+		ptesting.NewMockFile("another_subdir/bar", 0644, "hello bar"),
+	})
+	snap2.Close()
+
+	indexId1 := snap.Header.GetIndexShortID()
+	indexId2 := snap2.Header.GetIndexShortID()
+	snapPath1 := hex.EncodeToString(indexId1[:])
+	snapPath2 := hex.EncodeToString(indexId2[:])
+
+	subcommand := &Diff{}
+	err := subcommand.Parse(ctx, []string{"-summary", "-recursive", snapPath1, snapPath2})
+	require.NoError(t, err)
+	subcommand.SetOutputFormat(output.JSON)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	var records []output.DiffRecord
+	require.NoError(t, json.Unmarshal(bufOut.Bytes(), &records))
+
+	expected := []struct{ change, path string }{
+		{output.DiffAdded, "/subdir/added.txt"},
+		{output.DiffModified, "/subdir/dummy.txt"},
+		{output.DiffPermissionChanged, "/subdir/foo.txt"},
+		{output.DiffRemoved, "/subdir/removed.txt"},
+		{output.DiffTypeChanged, "/subdir/type"},
+	}
+	require.Len(t, records, len(expected))
+	for i, exp := range expected {
+		require.Equal(t, exp.change, records[i].Change, exp.path)
+		require.Equal(t, exp.path, records[i].Path)
+		require.Empty(t, records[i].Diff)
+	}
+	require.Equal(t, os.FileMode(0644), records[2].Old.Mode().Perm())
+	require.Equal(t, os.FileMode(0755), records[2].New.Mode().Perm())
+
+	// text output, without descending in common directories
+	bufOut.Reset()
+	subcommand = &Diff{}
+	err = subcommand.Parse(ctx, []string{"-summary", snapPath1 + ":/subdir", snapPath2 + ":/subdir"})
+	require.NoError(t, err)
+
+	status, err = subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	lines := strings.Split(strings.TrimSuffix(bufOut.String(), "\n"), "\n")
+	require.Equal(t, []string{
+		"added                       -        5 B /subdir/added.txt",
+		"modified                 11 B       13 B /subdir/dummy.txt",
+		"permission_changed        9 B        9 B /subdir/foo.txt",
+		"removed                   7 B          - /subdir/removed.txt",
+		"type_changed              6 B          - /subdir/type",
+	}, lines)
+
+	bufOut.Reset()
+	subcommand = &Diff{}
+	err = subcommand.Parse(ctx, []string{"-summary", snapPath1, snapPath2})
+	require.NoError(t, err)
+
+	status, err = subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Empty(t, bufOut.String())
+}
+
+func TestSummaryRecordDigest(t *testing.T) {
+	entry := func(content byte) *vfs.Entry {
+		return &vfs.Entry{
+			FileInfo:       objects.NewFileInfo("dummy.txt", 11, 0644, time.Time{}, 0, 0, 0, 0, 1),
+			ResolvedObject: &objects.Object{ContentMAC: objects.MAC{content}},
+		}
+	}
+
+	// same size and modification time, but another content
+	record, err := summaryRecord("/dummy.txt", entry(1), entry(2))
+	require.NoError(t, err)
+	require.Equal(t, output.DiffModified, record.Change)
+
+	record, err = summaryRecord("/dummy.txt", entry(1), entry(1))
+	require.NoError(t, err)
+	require.Nil(t, record)
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package diff
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/output"
+)
+
+// diff_records is the structured counterpart of diff_pathnames, it emits
+// one output.DiffRecord per difference instead of building a text diff.
+func (cmd *Diff) diff_records(ctx *appcontext.AppContext, enc *output.Encoder, id1 string, vfs1 fs.FS, pathname1 string, id2 string, vfs2 fs.FS, pathname2 string) error {
+	if _, ok := vfs2.(*vfs.Filesystem); !ok {
+		// on non vfs.Filesystem, strip root !
+		pathname2 = strings.TrimPrefix(pathname2, "/")
+	}
+
+	st1, err := fs.Stat(vfs1, pathname1)
+	if err != nil {
+		return fmt.Errorf("could not stat path %s in snapshot %s: %w", pathname1, id1, err)
+	}
+	st2, err := fs.Stat(vfs2, pathname2)
+	if err != nil {
+		return fmt.Errorf("could not stat path %s in snapshot %s: %w", pathname2, id2, err)
+	}
+
+	if st1.IsDir() && st2.IsDir() {
+		return cmd.diff_directories_records(ctx, enc, vfs1, pathname1, vfs2, pathname2)
+	} else if st1.IsDir() || st2.IsDir() {
+		return enc.Encode(output.DiffRecord{
+			Change: output.DiffTypeChanged,
+			Path:   pathname1,
+			Old:    output.FileInfo(st1),
+			New:    output.FileInfo(st2),
+		})
+	}
+
+	fsobj1, err := vfs1.Open(pathname1)
+	if err != nil {
+		return fmt.Errorf("could not open path %s in snapshot %s: %w", pathname1, id1, err)
+	}
+	defer fsobj1.Close()
+
+	fsobj2, err := vfs2.Open(pathname2)
+	if err != nil {
+		return fmt.Errorf("could not open path %s in snapshot %s: %w", pathname2, id2, err)
+	}
+	defer fsobj2.Close()
+
+	diff, err := cmd.diff_readers(id1, pathname1, fsobj1, id2, pathname2, fsobj2)
+	if err != nil {
+		return err
+	}
+	if diff == "" {
+		return nil
+	}
+	return enc.Encode(output.DiffRecord{
+		Change: output.DiffModified,
+		Path:   pathname1,
+		Old:    output.FileInfo(st1),
+		New:    output.FileInfo(st2),
+		Diff:   diff,
+	})
+}
+
+func (cmd *Diff) diff_directories_records(ctx *appcontext.AppContext, enc *output.Encoder, fs1 fs.FS, path1 string, fs2 fs.FS, path2 string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	entries1, err := fs.ReadDir(fs1, path1)
+	if err != nil {
+		return err
+	}
+	entries2, err := fs.ReadDir(fs2, path2)
+	if err != nil {
+		return err
+	}
+
+	map1 := make(map[string]fs.DirEntry)
+	map2 := make(map[string]fs.DirEntry)
+	var names []string
+	for _, e := range entries1 {
+		map1[e.Name()] = e
+		names = append(names, e.Name())
+	}
+	for _, e := range entries2 {
+		map2[e.Name()] = e
+		if _, ok := map1[e.Name()]; !ok {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		e1, ok1 := map1[name]
+		e2, ok2 := map2[name]
+
+		full1 := path.Join(path1, name)
+		full2 := path.Join(path2, name)
+
+		var info1, info2 fs.FileInfo
+		if ok1 {
+			if info1, err = e1.Info(); err != nil {
+				return err
+			}
+		}
+		if ok2 {
+			if info2, err = e2.Info(); err != nil {
+				return err
+			}
+		}
+
+		var record *output.DiffRecord
+		switch {
+		case ok1 && !ok2:
+			record = &output.DiffRecord{Change: output.DiffRemoved, Path: full1, Old: output.FileInfo(info1)}
+		case !ok1 && ok2:
+			// non VFS have their / stripped, reintroduce it
+			if !strings.HasPrefix(full2, "/") {
+				full2 = "/" + full2
+			}
+			record = &output.DiffRecord{Change: output.DiffAdded, Path: full2, New: output.FileInfo(info2)}
+		case e1.IsDir() != e2.IsDir():
+			record = &output.DiffRecord{Change: output.DiffTypeChanged, Path: full1, Old: output.FileInfo(info1), New: output.FileInfo(info2)}
+		case e1.IsDir() && cmd.Recursive:
+			if err := cmd.diff_directories_records(ctx, enc, fs1, full1, fs2, full2); err != nil {
+				return err
+			}
+		}
+
+		if record != nil {
+			if err := enc.Encode(record); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package diff
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/output"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/dustin/go-humanize"
+)
+
+// diff_summary is the metadata-only counterpart of diff_records: entries
+// are compared by their objects.FileInfo and, within snapshots, by the
+// content digest stored in their entry, so that no chunk is ever read.
+func (cmd *Diff) diff_summary(ctx *appcontext.AppContext, emit func(*output.DiffRecord) error, id1 string, vfs1 fs.FS, pathname1 string, id2 string, vfs2 fs.FS, pathname2 string) error {
+	if _, ok := vfs2.(*vfs.Filesystem); !ok {
+		// on non vfs.Filesystem, strip root !
+		pathname2 = strings.TrimPrefix(pathname2, "/")
+	}
+
+	e1, err := lookupDirEntry(vfs1, pathname1)
+	if err != nil {
+		return fmt.Errorf("could not stat path %s in snapshot %s: %w", pathname1, id1, err)
+	}
+	e2, err := lookupDirEntry(vfs2, pathname2)
+	if err != nil {
+		return fmt.Errorf("could not stat path %s in snapshot %s: %w", pathname2, id2, err)
+	}
+
+	record, err := summaryRecord(pathname1, e1, e2)
+	if err != nil {
+		return err
+	}
+	if record != nil {
+		if err := emit(record); err != nil {
+			return err
+		}
+	}
+
+	if e1.IsDir() && e2.IsDir() {
+		return cmd.diff_directories_summary(ctx, emit, vfs1, pathname1, vfs2, pathname2)
+	}
+	return nil
+}
+
+func (cmd *Diff) diff_directories_summary(ctx *appcontext.AppContext, emit func(*output.DiffRecord) error, fs1 fs.FS, path1 string, fs2 fs.FS, path2 string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	entries1, err := readDirEntries(fs1, path1)
+	if err != nil {
+		return err
+	}
+	entries2, err := readDirEntries(fs2, path2)
+	if err != nil {
+		return err
+	}
+
+	map1 := make(map[string]fs.DirEntry)
+	map2 := make(map[string]fs.DirEntry)
+	var names []string
+	for _, e := range entries1 {
+		map1[e.Name()] = e
+		names = append(names, e.Name())
+	}
+	for _, e := range entries2 {
+		map2[e.Name()] = e
+		if _, ok := map1[e.Name()]; !ok {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		e1, ok1 := map1[name]
+		e2, ok2 := map2[name]
+
+		full1 := path.Join(path1, name)
+		full2 := path.Join(path2, name)
+
+		var record *output.DiffRecord
+		switch {
+		case ok1 && !ok2:
+			info1, err := e1.Info()
+			if err != nil {
+				return err
+			}
+			record = &output.DiffRecord{Change: output.DiffRemoved, Path: full1, Old: output.FileInfo(info1)}
+		case !ok1 && ok2:
+			info2, err := e2.Info()
+			if err != nil {
+				return err
+			}
+			// non VFS have their / stripped, reintroduce it
+			if !strings.HasPrefix(full2, "/") {
+				full2 = "/" + full2
+			}
+			record = &output.DiffRecord{Change: output.DiffAdded, Path: full2, New: output.FileInfo(info2)}
+		default:
+			record, err = summaryRecord(full1, e1, e2)
+			if err != nil {
+				return err
+			}
+		}
+
+		if record != nil {
+			if err := emit(record); err != nil {
+				return err
+			}
+		}
+
+		if ok1 && ok2 && e1.IsDir() && e2.IsDir() && cmd.Recursive {
+			if err := cmd.diff_directories_summary(ctx, emit, fs1, full1, fs2, full2); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readDirEntries is fs.ReadDir returning the *vfs.Entry of snapshots, as
+// they carry the content digest.
+func readDirEntries(fsys fs.FS, pathname string) ([]fs.DirEntry, error) {
+	fsc, ok := fsys.(*vfs.Filesystem)
+	if !ok {
+		return fs.ReadDir(fsys, pathname)
+	}
+
+	children, err := fsc.Children(pathname)
+	if err != nil {
+		return nil, err
+	}
+	var entries []fs.DirEntry
+	for child, err := range children {
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, child)
+	}
+	return entries, nil
+}
+
+// lookupDirEntry returns the entry of pathname, which is a *vfs.Entry
+// carrying the content digest for snapshots.
+func lookupDirEntry(fsys fs.FS, pathname string) (fs.DirEntry, error) {
+	if fsc, ok := fsys.(*vfs.Filesystem); ok {
+		return fsc.GetEntry(pathname)
+	}
+	st, err := fs.Stat(fsys, pathname)
+	if err != nil {
+		return nil, err
+	}
+	return fs.FileInfoToDirEntry(st), nil
+}
+
+// summaryRecord compares two entries found at the same pathname, a change
+// of type takes precedence over one of content, itself taking precedence
+// over one of permissions.  It returns nil for identical entries.
+func summaryRecord(pathname string, e1, e2 fs.DirEntry) (*output.DiffRecord, error) {
+	info1, err := e1.Info()
+	if err != nil {
+		return nil, err
+	}
+	info2, err := e2.Info()
+	if err != nil {
+		return nil, err
+	}
+
+	record := &output.DiffRecord{
+		Path: pathname,
+		Old:  output.FileInfo(info1),
+		New:  output.FileInfo(info2),
+	}
+	switch {
+	case info1.Mode().Type() != info2.Mode().Type():
+		record.Change = output.DiffTypeChanged
+	case !sameContent(e1, info1, e2, info2):
+		record.Change = output.DiffModified
+	case info1.Mode() != info2.Mode():
+		record.Change = output.DiffPermissionChanged
+	default:
+		return nil, nil
+	}
+	return record, nil
+}
+
+// sameContent compares entries of the same type without reading them:
+// snapshot entries by their content digest or symlink target, others by
+// size and modification time.  The content of directories is compared
+// when walking them.
+func sameContent(e1 fs.DirEntry, info1 fs.FileInfo, e2 fs.DirEntry, info2 fs.FileInfo) bool {
+	if info1.IsDir() {
+		return true
+	}
+	if info1.Size() != info2.Size() {
+		return false
+	}
+
+	entry1, ok1 := e1.(*vfs.Entry)
+	entry2, ok2 := e2.(*vfs.Entry)
+	if ok1 && ok2 {
+		if entry1.ResolvedObject != nil && entry2.ResolvedObject != nil {
+			return entry1.ResolvedObject.ContentMAC == entry2.ResolvedObject.ContentMAC
+		}
+		if info1.Mode().Type() == fs.ModeSymlink {
+			return entry1.SymlinkTarget == entry2.SymlinkTarget
+		}
+	}
+	return info1.ModTime().Equal(info2.ModTime())
+}
+
+func summarySize(fi fs.FileInfo) string {
+	if fi == nil || fi.IsDir() {
+		return "-"
+	}
+	return humanize.Bytes(uint64(fi.Size()))
+}
+
+func (cmd *Diff) summary_line(ctx *appcontext.AppContext, record *output.DiffRecord) error {
+	// nil *objects.FileInfo must not become non-nil fs.FileInfo
+	var before, after fs.FileInfo
+	if record.Old != nil {
+		before = record.Old
+	}
+	if record.New != nil {
+		after = record.New
+	}
+	_, err := fmt.Fprintf(ctx.Stdout, "%-18s %10s %10s %s\n",
+		record.Change,
+		summarySize(before),
+		summarySize(after),
+		utils.SanitizeText(record.Path))
+	return err
+}
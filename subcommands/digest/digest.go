@@ -29,6 +29,7 @@ import (
 	"github.com/PlakarKorp/kloset/snapshot/vfs"
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/locate"
+	"github.com/PlakarKorp/plakar/output"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/utils"
 )
@@ -74,6 +75,12 @@ type Digest struct {
 }
 
 func (cmd *Digest) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	var enc *output.Encoder
+	if cmd.OutputFormat != output.Text {
+		enc = output.NewEncoder(ctx.Stdout, cmd.OutputFormat)
+		defer enc.Close()
+	}
+
 	errors := 0
 	for _, snapshotPath := range cmd.Targets {
 		snap, pathname, err := locate.OpenSnapshotByPath(repo, snapshotPath)
@@ -89,14 +96,14 @@ func (cmd *Digest) Execute(ctx *appcontext.AppContext, repo *repository.Reposito
 			continue
 		}
 
-		cmd.displayDigests(ctx, fs, repo, snap, pathname)
+		cmd.displayDigests(ctx, enc, fs, repo, snap, pathname)
 		snap.Close()
 	}
 
 	return 0, nil
 }
 
-func (cmd *Digest) displayDigests(ctx *appcontext.AppContext, fs *vfs.Filesystem, repo *repository.Repository, snap *snapshot.Snapshot, pathname string) error {
+func (cmd *Digest) displayDigests(ctx *appcontext.AppContext, enc *output.Encoder, fs *vfs.Filesystem, repo *repository.Repository, snap *snapshot.Snapshot, pathname string) error {
 	if err := ctx.Err(); err != nil {
 		return err
 	}
@@ -112,7 +119,7 @@ func (cmd *Digest) displayDigests(ctx *appcontext.AppContext, fs *vfs.Filesystem
 			return err
 		}
 		for child := range iter {
-			if err := cmd.displayDigests(ctx, fs, repo, snap, path.Join(pathname, child.Stat().Name())); err != nil {
+			if err := cmd.displayDigests(ctx, enc, fs, repo, snap, path.Join(pathname, child.Stat().Name())); err != nil {
 				return err
 			}
 		}
@@ -134,6 +141,14 @@ func (cmd *Digest) displayDigests(ctx *appcontext.AppContext, fs *vfs.Filesystem
 		return err
 	}
 	digest := hasher.Sum(nil)
+	if enc != nil {
+		return enc.Encode(output.Digest{
+			Snapshot:  snap.Header.Identifier,
+			Path:      pathname,
+			Algorithm: algorithm,
+			Digest:    fmt.Sprintf("%x", digest),
+		})
+	}
 	fmt.Fprintf(ctx.Stdout, "%s (%s) = %x\n", algorithm, utils.SanitizeText(pathname), digest)
 	return nil
 }
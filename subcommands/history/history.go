@@ -0,0 +1,132 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package history
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/locate"
+	"github.com/PlakarKorp/plakar/output"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/dustin/go-humanize"
+)
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &History{} }, subcommands.AgentSupport, "history")
+}
+
+func (cmd *History) Parse(ctx *appcontext.AppContext, args []string) error {
+	cmd.LocateOptions = locate.NewDefaultLocateOptions()
+
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] PATH\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+
+	flags.StringVar(&cmd.LocateOptions.Name, "name", "", "filter by name")
+	flags.StringVar(&cmd.LocateOptions.Category, "category", "", "filter by category")
+	flags.StringVar(&cmd.LocateOptions.Environment, "environment", "", "filter by environment")
+	flags.StringVar(&cmd.LocateOptions.Perimeter, "perimeter", "", "filter by perimeter")
+	flags.StringVar(&cmd.LocateOptions.Job, "job", "", "filter by job")
+	flags.StringVar(&cmd.LocateOptions.Tag, "tag", "", "filter by tag")
+	flags.Var(utils.NewTimeFlag(&cmd.LocateOptions.Before), "before", "filter by date")
+	flags.Var(utils.NewTimeFlag(&cmd.LocateOptions.Since), "since", "filter by date")
+	flags.StringVar(&cmd.Source, "source", "", "only look in the given source (index or root path) of multi-path snapshots")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("a single PATH must be specified")
+	}
+
+	cmd.LocateOptions.MaxConcurrency = ctx.MaxConcurrency
+	cmd.RepositorySecret = ctx.GetSecret()
+	cmd.Path = flags.Arg(0)
+
+	return nil
+}
+
+type History struct {
+	subcommands.SubcommandBase
+
+	LocateOptions *locate.LocateOptions
+	Source        string
+	Path          string
+}
+
+func (cmd *History) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	versions, err := locate.History(repo, cmd.LocateOptions, cmd.Path, cmd.Source)
+	if err != nil {
+		return 1, fmt.Errorf("history: %w", err)
+	}
+	if len(versions) == 0 {
+		return 1, fmt.Errorf("history: no snapshot holds %s", cmd.Path)
+	}
+
+	if cmd.OutputFormat != output.Text {
+		enc := output.NewEncoder(ctx.Stdout, cmd.OutputFormat)
+		defer enc.Close()
+
+		for _, version := range versions {
+			// the chunks can be huge and tell nothing about the version
+			if version.Entry.ResolvedObject != nil {
+				version.Entry.ResolvedObject.Chunks = nil
+			}
+
+			record := output.Version{
+				Path:      version.Pathname,
+				Entry:     version.Entry,
+				First:     version.First.Identifier,
+				Last:      version.Last.Identifier,
+				Snapshots: version.Snapshots,
+			}
+			if digest := version.Digest(); digest != (objects.MAC{}) {
+				record.Digest = &digest
+			}
+			if err := enc.Encode(record); err != nil {
+				return 1, err
+			}
+		}
+		return 0, nil
+	}
+
+	for _, version := range versions {
+		sb := version.Entry.Stat()
+
+		digest := "-"
+		if mac := version.Digest(); mac != (objects.MAC{}) {
+			digest = fmt.Sprintf("%x", mac[:8])
+		}
+
+		fmt.Fprintf(ctx.Stdout, "%s %s % 8s %16s %x %x %s\n",
+			sb.ModTime().UTC().Format(time.RFC3339),
+			sb.Mode(),
+			humanize.Bytes(uint64(sb.Size())),
+			digest,
+			version.First.GetIndexShortID(),
+			version.Last.GetIndexShortID(),
+			utils.SanitizeText(version.Pathname))
+	}
+	return 0, nil
+}
@@ -0,0 +1,115 @@
+package history
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/output"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	os.Setenv("TZ", "UTC")
+}
+
+func generateSnapshots(t *testing.T) (*repository.Repository, []*snapshot.Snapshot, *appcontext.AppContext) {
+	repo, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+
+	var snaps []*snapshot.Snapshot
+	for _, content := range []string{"hello dummy", "hello dummy", "hello dummy, again"} {
+		snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+			ptesting.NewMockDir("subdir"),
+			ptesting.NewMockFile("subdir/dummy.txt", 0644, content),
+		})
+		t.Cleanup(func() { snap.Close() })
+		snaps = append(snaps, snap)
+	}
+	return repo, snaps, ctx
+}
+
+func TestExecuteCmdHistoryDefault(t *testing.T) {
+	repo, snaps, ctx := generateSnapshots(t)
+
+	bufOut := bytes.NewBuffer(nil)
+	ctx.Stdout = bufOut
+
+	subcommand := &History{}
+	err := subcommand.Parse(ctx, []string{"/subdir/dummy.txt"})
+	require.NoError(t, err)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	// output should look like this
+	// 0001-01-01T00:00:00Z -rw-r--r--     11 B 5d9d1b3a2c0e4f61 0c25583a 35c56f81 /subdir/dummy.txt
+	// 0001-01-01T00:00:00Z -rw-r--r--     18 B 7e1a0c9d8b2f3e44 4b7a9d21 4b7a9d21 /subdir/dummy.txt
+	lines := strings.Split(strings.Trim(bufOut.String(), "\n"), "\n")
+	require.Len(t, lines, 2)
+
+	first := hex.EncodeToString(snaps[0].Header.GetIndexShortID())
+	last := hex.EncodeToString(snaps[1].Header.GetIndexShortID())
+	require.Contains(t, lines[0], " 11 B ")
+	require.True(t, strings.HasSuffix(lines[0], first+" "+last+" /subdir/dummy.txt"))
+
+	latest := hex.EncodeToString(snaps[2].Header.GetIndexShortID())
+	require.Contains(t, lines[1], " 18 B ")
+	require.True(t, strings.HasSuffix(lines[1], latest+" "+latest+" /subdir/dummy.txt"))
+}
+
+func TestExecuteCmdHistoryJSON(t *testing.T) {
+	repo, snaps, ctx := generateSnapshots(t)
+
+	bufOut := bytes.NewBuffer(nil)
+	ctx.Stdout = bufOut
+
+	subcommand := &History{}
+	err := subcommand.Parse(ctx, []string{"subdir/dummy.txt"})
+	require.NoError(t, err)
+	subcommand.SetOutputFormat(output.JSON)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	var versions []struct {
+		Path      string `json:"path"`
+		Digest    string `json:"digest"`
+		First     string `json:"first"`
+		Last      string `json:"last"`
+		Snapshots int    `json:"snapshots"`
+	}
+	require.NoError(t, json.Unmarshal(bufOut.Bytes(), &versions))
+	require.Len(t, versions, 2)
+	require.Equal(t, "/subdir/dummy.txt", versions[0].Path)
+	require.Equal(t, hex.EncodeToString(snaps[0].Header.Identifier[:]), versions[0].First)
+	require.Equal(t, hex.EncodeToString(snaps[1].Header.Identifier[:]), versions[0].Last)
+	require.Equal(t, 2, versions[0].Snapshots)
+	require.Equal(t, 1, versions[1].Snapshots)
+	require.NotEmpty(t, versions[0].Digest)
+	require.NotEqual(t, versions[0].Digest, versions[1].Digest)
+}
+
+func TestExecuteCmdHistoryNotFound(t *testing.T) {
+	repo, _, ctx := generateSnapshots(t)
+
+	subcommand := &History{}
+	err := subcommand.Parse(ctx, []string{"/nonexistent"})
+	require.NoError(t, err)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.ErrorContains(t, err, "no snapshot holds /nonexistent")
+	require.Equal(t, 1, status)
+
+	subcommand = &History{}
+	err = subcommand.Parse(ctx, []string{"/subdir", "/another"})
+	require.ErrorContains(t, err, "a single PATH must be specified")
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/locate"
+	"github.com/PlakarKorp/plakar/output"
 )
 
 func (cmd *Info) executeErrors(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
@@ -25,6 +26,19 @@ func (cmd *Info) executeErrors(ctx *appcontext.AppContext, repo *repository.Repo
 		return 1, err
 	}
 
+	if cmd.OutputFormat != output.Text {
+		enc := output.NewEncoder(ctx.Stdout, cmd.OutputFormat)
+		for item := range errstream {
+			if err := enc.Encode(item); err != nil {
+				return 1, err
+			}
+		}
+		if err := enc.Close(); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+
 	for item := range errstream {
 		fmt.Fprintf(ctx.Stdout, "%s: %s\n", item.Name, item.Error)
 	}
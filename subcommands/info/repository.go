@@ -6,10 +6,28 @@ import (
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/output"
 	"github.com/dustin/go-humanize"
 )
 
 func (cmd *Info) executeRepository(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	if cmd.OutputFormat != output.Text {
+		nSnapshots, logicalSize, err := snapshot.LogicalSize(repo)
+		if err != nil {
+			return 1, fmt.Errorf("unable to calculate logical size: %w", err)
+		}
+		err = output.Write(ctx.Stdout, cmd.OutputFormat, output.Repository{
+			Location:      repo.Location(),
+			Configuration: repo.Configuration(),
+			Snapshots:     nSnapshots,
+			StorageSize:   repo.Store().Size(),
+			LogicalSize:   logicalSize,
+		})
+		if err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
 
 	fmt.Fprintln(ctx.Stdout, "Version:", repo.Configuration().Version)
 	fmt.Fprintln(ctx.Stdout, "Timestamp:", repo.Configuration().Timestamp)
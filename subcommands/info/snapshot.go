@@ -10,6 +10,7 @@ import (
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/locate"
+	"github.com/PlakarKorp/plakar/output"
 	"github.com/dustin/go-humanize"
 	"github.com/google/uuid"
 )
@@ -23,6 +24,13 @@ func (cmd *Info) executeSnapshot(ctx *appcontext.AppContext, repo *repository.Re
 
 	header := snap.Header
 
+	if cmd.OutputFormat != output.Text {
+		if err := output.Write(ctx.Stdout, cmd.OutputFormat, header); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+
 	indexID := header.GetIndexID()
 	fmt.Fprintf(ctx.Stdout, "Version: %s\n", repo.Configuration().Version)
 	fmt.Fprintf(ctx.Stdout, "SnapshotID: %s\n", hex.EncodeToString(indexID[:]))
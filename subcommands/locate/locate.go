@@ -17,15 +17,16 @@
 package locate
 
 import (
+	"errors"
 	"flag"
 	"fmt"
-	"path"
+	"strings"
 
 	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
-	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
 	plocate "github.com/PlakarKorp/plakar/locate"
+	"github.com/PlakarKorp/plakar/output"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/utils"
 )
@@ -36,6 +37,7 @@ func init() {
 
 func (cmd *Locate) Parse(ctx *appcontext.AppContext, args []string) error {
 	cmd.LocateOptions = plocate.NewDefaultLocateOptions()
+	cmd.Query = plocate.NewDefaultPathnameQuery()
 
 	flags := flag.NewFlagSet("locate", flag.ExitOnError)
 	flags.Usage = func() {
@@ -45,6 +47,14 @@ func (cmd *Locate) Parse(ctx *appcontext.AppContext, args []string) error {
 	}
 
 	flags.StringVar(&cmd.Snapshot, "snapshot", "", "snapshot to locate in")
+	flags.StringVar(&cmd.Source, "source", "", "only locate within the given source (index or root path) of multi-path snapshots")
+	flags.BoolVar(&cmd.Query.Regex, "regex", false, "patterns are regular expressions")
+	flags.BoolVar(&cmd.Query.IgnoreCase, "i", false, "ignore case when matching patterns")
+	flags.BoolVar(&cmd.Query.FullPath, "full", false, "match patterns against full pathnames rather than base names")
+	flags.Var(utils.NewSizeFlag(&cmd.Query.MinSize), "min-size", "only locate entries of at least the given size")
+	flags.Var(utils.NewSizeFlag(&cmd.Query.MaxSize), "max-size", "only locate entries of at most the given size")
+	flags.Var(utils.NewTimeFlag(&cmd.Query.Newer), "newer", "only locate entries modified since the given date")
+	flags.Var(utils.NewTimeFlag(&cmd.Query.Older), "older", "only locate entries modified before the given date")
 	cmd.LocateOptions.InstallFlags(flags)
 	flags.Parse(args)
 
@@ -55,7 +65,7 @@ func (cmd *Locate) Parse(ctx *appcontext.AppContext, args []string) error {
 	cmd.LocateOptions.MaxConcurrency = ctx.MaxConcurrency
 	cmd.LocateOptions.SortOrder = plocate.LocateSortOrderAscending
 	cmd.RepositorySecret = ctx.GetSecret()
-	cmd.Patterns = flags.Args()
+	cmd.Query.Patterns = flags.Args()
 
 	return nil
 }
@@ -64,8 +74,9 @@ type Locate struct {
 	subcommands.SubcommandBase
 
 	LocateOptions *plocate.LocateOptions
+	Query         *plocate.PathnameQuery
 	Snapshot      string
-	Patterns      []string
+	Source        string
 }
 
 func (cmd *Locate) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
@@ -81,46 +92,86 @@ func (cmd *Locate) Execute(ctx *appcontext.AppContext, repo *repository.Reposito
 		snapshots = append(snapshots, snapshotIDs...)
 	}
 
-	for _, snapshotID := range snapshots {
-		snap, err := snapshot.Load(repo, snapshotID)
-		if err != nil {
-			return 1, fmt.Errorf("locate: could not get snapshot: %w", err)
-		}
+	locations, err := cmd.search(ctx, repo, snapshots)
+	if err != nil {
+		return 1, err
+	}
 
-		fs, err := snap.Filesystem()
+	// snapshots lacking the source are skipped
+	var sourceRoots map[objects.MAC]string
+	if cmd.Source != "" {
+		headers, err := plocate.Headers(repo, ctx.MaxConcurrency)
 		if err != nil {
-			snap.Close()
-			return 1, fmt.Errorf("locate: could not get filesystem: %w", err)
+			return 1, fmt.Errorf("locate: could not fetch snapshots list: %w", err)
 		}
-		for pathname, err := range fs.Pathnames() {
-			if err != nil {
-				snap.Close()
-				return 1, fmt.Errorf("locate: could not get pathname: %w", err)
+		sourceRoots = make(map[objects.MAC]string)
+		for _, hdr := range headers {
+			if root, err := plocate.SourceRoot(hdr, cmd.Source); err == nil {
+				sourceRoots[hdr.Identifier] = root
 			}
+		}
+	}
 
-			if err := ctx.Err(); err != nil {
-				return 1, err
+	var enc *output.Encoder
+	if cmd.OutputFormat != output.Text {
+		enc = output.NewEncoder(ctx.Stdout, cmd.OutputFormat)
+		defer enc.Close()
+	}
+
+	for _, loc := range locations {
+		if err := ctx.Err(); err != nil {
+			return 1, err
+		}
+
+		if sourceRoots != nil {
+			sourceRoot, ok := sourceRoots[loc.SnapshotID]
+			if !ok {
+				continue
+			}
+			if sourceRoot != "/" && loc.Pathname != sourceRoot &&
+				!strings.HasPrefix(loc.Pathname, sourceRoot+"/") {
+				continue
 			}
+		}
 
-			for _, pattern := range cmd.Patterns {
-				matched := false
-				if path.Base(pathname) == pattern {
-					matched = true
-				}
-				if !matched {
-					matched, err := path.Match(pattern, path.Base(pathname))
-					if err != nil {
-						snap.Close()
-						return 1, fmt.Errorf("locate: could not match pattern: %w", err)
-					}
-					if !matched {
-						continue
-					}
-				}
-				fmt.Fprintf(ctx.Stdout, "%x:%s\n", snap.Header.Identifier[0:4], utils.SanitizeText(pathname))
+		if enc != nil {
+			err := enc.Encode(output.Match{
+				Snapshot: loc.SnapshotID,
+				Path:     loc.Pathname,
+			})
+			if err != nil {
+				return 1, err
 			}
+			continue
 		}
-		snap.Close()
+		fmt.Fprintf(ctx.Stdout, "%x:%s\n", loc.SnapshotID[0:4], utils.SanitizeText(loc.Pathname))
 	}
 	return 0, nil
 }
+
+// search looks the query up in the index, or walks the snapshots if the
+// index is held by another process.
+func (cmd *Locate) search(ctx *appcontext.AppContext, repo *repository.Repository, snapshots []objects.MAC) ([]plocate.Location, error) {
+	index, err := plocate.OpenIndex(repo)
+	if errors.Is(err, plocate.ErrIndexBusy) {
+		ctx.GetLogger().Info("locate: %s, walking the snapshots instead", err)
+		locations, err := plocate.WalkSearch(repo, cmd.Query, snapshots)
+		if err != nil {
+			return nil, fmt.Errorf("locate: %w", err)
+		}
+		return locations, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("locate: could not open index: %w", err)
+	}
+	defer index.Close()
+
+	if err := index.Update(repo, snapshots); err != nil {
+		return nil, fmt.Errorf("locate: could not update index: %w", err)
+	}
+
+	locations, err := index.Search(cmd.Query, snapshots)
+	if err != nil {
+		return nil, fmt.Errorf("locate: %w", err)
+	}
+	return locations, nil
+}
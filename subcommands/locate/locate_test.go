@@ -85,3 +85,29 @@ func TestExecuteCmdLocateWithSnapshotId(t *testing.T) {
 	lines := strings.Split(strings.Trim(output, "\n"), "\n")
 	require.Equal(t, 1, len(lines))
 }
+
+func TestExecuteCmdLocateQuery(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, snap, ctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	args := []string{"-regex", "-i", "-full", "-max-size", "10B", "^/SUBDIR/.*\\.TXT$"}
+
+	subcommand := &Locate{}
+	err := subcommand.Parse(ctx, args)
+	require.NoError(t, err)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	// output should look like this
+	// d92a4c73:/subdir/foo.txt
+
+	output := bufOut.String()
+	lines := strings.Split(strings.Trim(output, "\n"), "\n")
+	require.Equal(t, 1, len(lines))
+	require.True(t, strings.HasSuffix(lines[0], ":/subdir/foo.txt"))
+}
@@ -22,14 +22,15 @@ import (
 	"fmt"
 	"io/fs"
 	"os/user"
+	"strings"
 	"time"
 
 	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
-	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/kloset/snapshot/vfs"
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/locate"
+	"github.com/PlakarKorp/plakar/output"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/utils"
 	"github.com/dustin/go-humanize"
@@ -51,6 +52,8 @@ func (cmd *Ls) Parse(ctx *appcontext.AppContext, args []string) error {
 
 	flags.BoolVar(&cmd.DisplayUUID, "uuid", false, "display uuid instead of short ID")
 	flags.BoolVar(&cmd.Recursive, "recursive", false, "recursive listing")
+	flags.BoolVar(&cmd.Long, "long", false, "display the name, category, environment and perimeter of snapshots")
+	flags.StringVar(&cmd.Source, "source", "", "resolve PATH within the given source (index or root path) of a multi-path snapshot")
 	cmd.LocateOptions.InstallFlags(flags)
 
 	flags.Parse(args)
@@ -70,8 +73,10 @@ type Ls struct {
 
 	LocateOptions *locate.LocateOptions
 	Recursive     bool
+	Long          bool
 	DisplayUUID   bool
 	Path          string
+	Source        string
 }
 
 func (cmd *Ls) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
@@ -92,41 +97,56 @@ func (cmd *Ls) list_snapshots(ctx *appcontext.AppContext, repo *repository.Repos
 	cmd.LocateOptions.MaxConcurrency = ctx.MaxConcurrency
 	cmd.LocateOptions.SortOrder = locate.LocateSortOrderDescending
 
-	snapshotIDs, err := locate.LocateSnapshotIDs(repo, cmd.LocateOptions)
+	headers, err := locate.Headers(repo, ctx.MaxConcurrency)
 	if err != nil {
 		return fmt.Errorf("ls: could not fetch snapshots list: %w", err)
 	}
+	headers = locate.LocateHeaders(headers, cmd.LocateOptions)
 
-	for _, snapshotID := range snapshotIDs {
-		snap, err := snapshot.Load(repo, snapshotID)
-		if err != nil {
-			return fmt.Errorf("ls: could not fetch snapshot: %w", err)
+	var enc *output.Encoder
+	if cmd.OutputFormat != output.Text {
+		enc = output.NewEncoder(ctx.Stdout, cmd.OutputFormat)
+		defer enc.Close()
+	}
+
+	for _, hdr := range headers {
+		if enc != nil {
+			if err := enc.Encode(hdr); err != nil {
+				return err
+			}
+			continue
 		}
 
+		var id string
 		if !cmd.DisplayUUID {
-			fmt.Fprintf(ctx.Stdout, "%s %10s%10s%10s %s\n",
-				snap.Header.Timestamp.UTC().Format(time.RFC3339),
-				hex.EncodeToString(snap.Header.GetIndexShortID()),
-				humanize.Bytes(snap.Header.GetSource(0).Summary.Directory.Size+snap.Header.GetSource(0).Summary.Below.Size),
-				snap.Header.Duration.Round(time.Second),
-				utils.SanitizeText(snap.Header.GetSource(0).Importer.Directory))
+			id = fmt.Sprintf("%10s", hex.EncodeToString(hdr.GetIndexShortID()))
 		} else {
-			indexID := snap.Header.GetIndexID()
-			fmt.Fprintf(ctx.Stdout, "%s %3s%10s%10s %s\n",
-				snap.Header.Timestamp.UTC().Format(time.RFC3339),
-				hex.EncodeToString(indexID[:]),
-				humanize.Bytes(snap.Header.GetSource(0).Summary.Directory.Size+snap.Header.GetSource(0).Summary.Below.Size),
-				snap.Header.Duration.Round(time.Second),
-				utils.SanitizeText(snap.Header.GetSource(0).Importer.Directory))
+			indexID := hdr.GetIndexID()
+			id = fmt.Sprintf("%3s", hex.EncodeToString(indexID[:]))
 		}
 
-		snap.Close()
+		var metadata string
+		if cmd.Long {
+			metadata = fmt.Sprintf(" %s %s/%s/%s",
+				utils.SanitizeText(hdr.Name),
+				utils.SanitizeText(hdr.Category),
+				utils.SanitizeText(hdr.Environment),
+				utils.SanitizeText(hdr.Perimeter))
+		}
+
+		fmt.Fprintf(ctx.Stdout, "%s %s%10s%10s%s %s\n",
+			hdr.Timestamp.UTC().Format(time.RFC3339),
+			id,
+			humanize.Bytes(hdr.GetSource(0).Summary.Directory.Size+hdr.GetSource(0).Summary.Below.Size),
+			hdr.Duration.Round(time.Second),
+			metadata,
+			utils.SanitizeText(strings.Join(locate.Sources(hdr), ",")))
 	}
 	return nil
 }
 
 func (cmd *Ls) list_snapshot(ctx *appcontext.AppContext, repo *repository.Repository, snapshotPath string, recursive bool) error {
-	snap, pathname, err := locate.OpenSnapshotByPath(repo, snapshotPath)
+	snap, pathname, err := locate.OpenSnapshotByPathInSource(repo, snapshotPath, cmd.Source)
 	if err != nil {
 		return err
 	}
@@ -137,6 +157,12 @@ func (cmd *Ls) list_snapshot(ctx *appcontext.AppContext, repo *repository.Reposi
 		return err
 	}
 
+	var enc *output.Encoder
+	if cmd.OutputFormat != output.Text {
+		enc = output.NewEncoder(ctx.Stdout, cmd.OutputFormat)
+		defer enc.Close()
+	}
+
 	resolved := false
 	return pvfs.WalkDir(pathname, func(path string, d *vfs.Entry, err error) error {
 		if err != nil {
@@ -157,6 +183,21 @@ func (cmd *Ls) list_snapshot(ctx *appcontext.AppContext, repo *repository.Reposi
 			return nil
 		}
 
+		if enc != nil {
+			err := enc.Encode(output.Entry{
+				Snapshot: snap.Header.Identifier,
+				Path:     path,
+				Entry:    d,
+			})
+			if err != nil {
+				return err
+			}
+			if !recursive && pathname != path && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
 		sb, err := d.Info()
 		if err != nil {
 			return err
@@ -3,15 +3,19 @@ package ls
 import (
 	"bytes"
 	"encoding/hex"
+	"encoding/json"
 	"io"
 	"os"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/header"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/output"
 	ptesting "github.com/PlakarKorp/plakar/testing"
 	"github.com/stretchr/testify/require"
 )
@@ -145,3 +149,56 @@ func TestExecuteCmdLsFilterUuid(t *testing.T) {
 	require.Equal(t, hex.EncodeToString(indexId[:]), fields[1])
 	require.Equal(t, snap.Header.GetSource(0).Importer.Directory, fields[len(fields)-1])
 }
+
+func TestExecuteCmdLsJSON(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy"),
+	})
+	defer snap.Close()
+
+	subcommand := &Ls{}
+	err := subcommand.Parse(ctx, []string{})
+	require.NoError(t, err)
+	subcommand.SetOutputFormat(output.JSON)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	var headers []header.Header
+	require.NoError(t, json.Unmarshal(bufOut.Bytes(), &headers))
+	require.Equal(t, 1, len(headers))
+	require.Equal(t, snap.Header.Identifier, headers[0].Identifier)
+	require.Equal(t, snap.Header.GetSource(0).Importer.Directory, headers[0].GetSource(0).Importer.Directory)
+
+	bufOut.Reset()
+	subcommand = &Ls{}
+	err = subcommand.Parse(ctx, []string{"-recursive", hex.EncodeToString(snap.Header.GetIndexShortID())})
+	require.NoError(t, err)
+	subcommand.SetOutputFormat(output.NDJSON)
+
+	status, err = subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	lines := strings.Split(strings.Trim(bufOut.String(), "\n"), "\n")
+	require.Equal(t, 2, len(lines))
+
+	var entry struct {
+		Snapshot objects.MAC `json:"snapshot"`
+		Path     string      `json:"path"`
+		Entry    struct {
+			FileInfo objects.FileInfo `json:"file_info"`
+		} `json:"entry"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	require.Equal(t, snap.Header.Identifier, entry.Snapshot)
+	require.Equal(t, "/subdir/dummy.txt", entry.Path)
+	require.Equal(t, "dummy.txt", entry.Entry.FileInfo.Lname)
+	require.Equal(t, int64(len("hello dummy")), entry.Entry.FileInfo.Lsize)
+}
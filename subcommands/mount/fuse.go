@@ -30,6 +30,21 @@ import (
 )
 
 func (cmd *Mount) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	fsys := plakarfs.NewFS(repo, cmd.Mountpoint)
+	if cmd.CacheSize != 0 {
+		fsys.SetChunkCacheSize(int64(cmd.CacheSize))
+	}
+	if cmd.LocateOptions != nil && !cmd.LocateOptions.Empty() {
+		fsys.SetFilter(cmd.LocateOptions)
+	}
+	if cmd.Snapshot != "" {
+		fsys.SetSnapshotPath(cmd.Snapshot)
+	}
+	if _, err := fsys.Root(); err != nil {
+		return 1, fmt.Errorf("mount: %w", err)
+	}
+	defer fsys.Close()
+
 	c, err := fuse.Mount(
 		cmd.Mountpoint,
 		fuse.FSName("plakar"),
@@ -46,7 +61,7 @@ func (cmd *Mount) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 		fuse.Unmount(cmd.Mountpoint)
 	}()
 
-	err = fs.Serve(c, plakarfs.NewFS(repo, cmd.Mountpoint))
+	err = fs.Serve(c, fsys)
 	if err != nil {
 		return 1, err
 	}
@@ -21,7 +21,9 @@ import (
 	"fmt"
 
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/locate"
 	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/dustin/go-humanize"
 )
 
 func init() {
@@ -29,16 +31,35 @@ func init() {
 }
 
 func (cmd *Mount) Parse(ctx *appcontext.AppContext, args []string) error {
+	var opt_cachesize string
+
+	cmd.LocateOptions = locate.NewDefaultLocateOptions()
+
 	flags := flag.NewFlagSet("mount", flag.ExitOnError)
 	flags.Usage = func() {
-		fmt.Fprintf(flags.Output(), "Usage: %s PATH\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] PATH\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
 	}
+	flags.StringVar(&opt_cachesize, "cache-size", "64MiB", "maximum amount of file data cached in memory")
+	flags.StringVar(&cmd.Snapshot, "snapshot", "", "mount a single snapshot, or a directory of it given as SNAPSHOT:PATH")
+	cmd.LocateOptions.InstallFlags(flags)
 	flags.Parse(args)
 
 	if flags.NArg() != 1 {
 		return fmt.Errorf("need mountpoint")
 	}
 
+	if cmd.Snapshot != "" && !cmd.LocateOptions.Empty() {
+		return fmt.Errorf("-snapshot can't be combined with snapshot filters")
+	}
+
+	cacheSize, err := humanize.ParseBytes(opt_cachesize)
+	if err != nil {
+		return fmt.Errorf("invalid cache size %q: %w", opt_cachesize, err)
+	}
+	cmd.CacheSize = cacheSize
+
 	cmd.RepositorySecret = ctx.GetSecret()
 	cmd.Mountpoint = flags.Arg(0)
 
@@ -48,5 +69,8 @@ func (cmd *Mount) Parse(ctx *appcontext.AppContext, args []string) error {
 type Mount struct {
 	subcommands.SubcommandBase
 
-	Mountpoint string
+	Mountpoint    string
+	CacheSize     uint64
+	Snapshot      string
+	LocateOptions *locate.LocateOptions
 }
@@ -68,7 +68,7 @@ func TestExecuteCmdMountDefault(t *testing.T) {
 	snapshotPath := fmt.Sprintf("%s", hex.EncodeToString(indexId[:]))
 	backupDir := snap.Header.GetSource(0).Importer.Directory
 
-	dummyMountedPath := fmt.Sprintf("%s/%s/%s/subdir/dummy.txt", tmpMountPoint, snapshotPath, backupDir)
+	dummyMountedPath := fmt.Sprintf("%s/snapshots/%s/%s/subdir/dummy.txt", tmpMountPoint, snapshotPath, backupDir)
 	file, err = os.Stat(dummyMountedPath)
 	require.NoError(t, err)
 	require.NotNil(t, file)
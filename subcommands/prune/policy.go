@@ -0,0 +1,157 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package prune
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/snapshot/header"
+)
+
+// Policy is a generational retention policy: the Last most recent snapshots
+// are kept, then the most recent snapshot of each of the last Hourly hours,
+// Daily days, and so on.  A zero value disables the corresponding rule.
+type Policy struct {
+	Last    int
+	Hourly  int
+	Daily   int
+	Weekly  int
+	Monthly int
+	Yearly  int
+}
+
+func (p Policy) Empty() bool {
+	return p == Policy{}
+}
+
+func (p Policy) String() string {
+	var rules []string
+	for _, rule := range p.rules() {
+		if rule.count != 0 {
+			rules = append(rules, fmt.Sprintf("%s=%d", rule.name, rule.count))
+		}
+	}
+	return strings.Join(rules, ",")
+}
+
+type rule struct {
+	name   string
+	count  int
+	bucket func(time.Time) string
+}
+
+func (p Policy) rules() []rule {
+	return []rule{
+		{"last", p.Last, nil},
+		{"hourly", p.Hourly, func(t time.Time) string { return t.Format("2006-01-02 15") }},
+		{"daily", p.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{"weekly", p.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%04d-W%02d", year, week)
+		}},
+		{"monthly", p.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{"yearly", p.Yearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+}
+
+// Decision tells whether a snapshot is kept, and which rules keep it.
+type Decision struct {
+	Header  *header.Header
+	Keep    bool
+	Reasons []string
+}
+
+// Apply sorts the snapshots of a group from the most recent to the oldest,
+// and decides for each of them whether the policy keeps it.
+func (p Policy) Apply(headers []*header.Header) []Decision {
+	sorted := make([]*header.Header, len(headers))
+	copy(sorted, headers)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp.After(sorted[j].Timestamp)
+	})
+
+	decisions := make([]Decision, len(sorted))
+	for i, hdr := range sorted {
+		decisions[i].Header = hdr
+	}
+
+	for _, rule := range p.rules() {
+		remaining := rule.count
+		lastBucket := ""
+		for i := range decisions {
+			if remaining == 0 {
+				break
+			}
+			reason := rule.name
+			if rule.bucket != nil {
+				bucket := rule.bucket(decisions[i].Header.Timestamp.Local())
+				if bucket == lastBucket {
+					continue
+				}
+				lastBucket = bucket
+				reason = fmt.Sprintf("%s %s", rule.name, bucket)
+			}
+			remaining--
+
+			decisions[i].Keep = true
+			decisions[i].Reasons = append(decisions[i].Reasons, reason)
+		}
+	}
+	return decisions
+}
+
+var groupKeys = map[string]func(*header.Header) string{
+	"name":        func(h *header.Header) string { return h.Name },
+	"category":    func(h *header.Header) string { return h.Category },
+	"environment": func(h *header.Header) string { return h.Environment },
+	"perimeter":   func(h *header.Header) string { return h.Perimeter },
+	"job":         func(h *header.Header) string { return h.Job },
+	"tag": func(h *header.Header) string {
+		tags := make([]string, len(h.Tags))
+		copy(tags, h.Tags)
+		sort.Strings(tags)
+		return strings.Join(tags, ",")
+	},
+}
+
+// ParseGroupBy validates a comma-separated list of header fields.
+func ParseGroupBy(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var keys []string
+	for _, key := range strings.Split(s, ",") {
+		key = strings.TrimSpace(key)
+		if _, ok := groupKeys[key]; !ok {
+			return nil, fmt.Errorf("invalid group-by key %q", key)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// GroupKey returns the identifier of the group the snapshot belongs to.
+func GroupKey(hdr *header.Header, groupBy []string) string {
+	var parts []string
+	for _, key := range groupBy {
+		parts = append(parts, fmt.Sprintf("%s=%s", key, groupKeys[key](hdr)))
+	}
+	return strings.Join(parts, " ")
+}
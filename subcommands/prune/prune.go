@@ -0,0 +1,190 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package prune
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/header"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/locate"
+	"github.com/PlakarKorp/plakar/subcommands"
+)
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &Prune{} }, subcommands.AgentSupport, "prune")
+}
+
+func (cmd *Prune) Parse(ctx *appcontext.AppContext, args []string) error {
+	var opt_groupby string
+
+	cmd.LocateOptions = locate.NewDefaultLocateOptions()
+
+	flags := flag.NewFlagSet("prune", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS]\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+
+	flags.IntVar(&cmd.Policy.Last, "keep-last", 0, "keep the last N snapshots")
+	flags.IntVar(&cmd.Policy.Hourly, "keep-hourly", 0, "keep the last snapshot of each of the last N hours")
+	flags.IntVar(&cmd.Policy.Daily, "keep-daily", 0, "keep the last snapshot of each of the last N days")
+	flags.IntVar(&cmd.Policy.Weekly, "keep-weekly", 0, "keep the last snapshot of each of the last N weeks")
+	flags.IntVar(&cmd.Policy.Monthly, "keep-monthly", 0, "keep the last snapshot of each of the last N months")
+	flags.IntVar(&cmd.Policy.Yearly, "keep-yearly", 0, "keep the last snapshot of each of the last N years")
+	flags.StringVar(&opt_groupby, "group-by", "name,job", "comma-separated list of fields to group snapshots by (name, category, environment, perimeter, job, tag)")
+	flags.BoolVar(&cmd.DryRun, "dry-run", false, "only list the snapshots that would be kept or removed")
+	cmd.LocateOptions.InstallFlags(flags)
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		return fmt.Errorf("too many arguments")
+	}
+
+	for _, n := range []int{cmd.Policy.Last, cmd.Policy.Hourly, cmd.Policy.Daily,
+		cmd.Policy.Weekly, cmd.Policy.Monthly, cmd.Policy.Yearly} {
+		if n < 0 {
+			return fmt.Errorf("retention counts must be positive")
+		}
+	}
+	if cmd.Policy.Empty() {
+		return fmt.Errorf("no retention policy specified, not going to remove everything")
+	}
+
+	groupBy, err := ParseGroupBy(opt_groupby)
+	if err != nil {
+		return err
+	}
+
+	cmd.RepositorySecret = ctx.GetSecret()
+	cmd.GroupBy = groupBy
+
+	return nil
+}
+
+type Prune struct {
+	subcommands.SubcommandBase
+
+	LocateOptions *locate.LocateOptions
+	Policy        Policy
+	GroupBy       []string
+	DryRun        bool
+}
+
+func (cmd *Prune) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	if cmd.Policy.Empty() {
+		return 1, fmt.Errorf("no retention policy specified, not going to remove everything")
+	}
+
+	if cmd.LocateOptions == nil {
+		cmd.LocateOptions = locate.NewDefaultLocateOptions()
+	}
+	cmd.LocateOptions.MaxConcurrency = ctx.MaxConcurrency
+
+	snapshotIDs, err := locate.LocateSnapshotIDs(repo, cmd.LocateOptions)
+	if err != nil {
+		return 1, fmt.Errorf("prune: could not fetch snapshots list: %w", err)
+	}
+
+	groups := make(map[string][]*header.Header)
+	for _, snapshotID := range snapshotIDs {
+		snap, err := snapshot.Load(repo, snapshotID)
+		if err != nil {
+			return 1, fmt.Errorf("prune: could not fetch snapshot %x: %w", snapshotID[:4], err)
+		}
+		key := GroupKey(snap.Header, cmd.GroupBy)
+		groups[key] = append(groups[key], snap.Header)
+		snap.Close()
+	}
+
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var toRemove []objects.MAC
+	for _, key := range keys {
+		decisions := cmd.Policy.Apply(groups[key])
+
+		if cmd.DryRun {
+			if key != "" {
+				fmt.Fprintf(ctx.Stdout, "%s\n", key)
+			}
+		}
+
+		for _, decision := range decisions {
+			hdr := decision.Header
+			if !decision.Keep {
+				toRemove = append(toRemove, hdr.Identifier)
+			}
+			if !cmd.DryRun {
+				continue
+			}
+
+			action := "remove"
+			if decision.Keep {
+				action = "keep"
+			}
+			fmt.Fprintf(ctx.Stdout, "  %-6s %s %10s %s\n",
+				action,
+				hdr.Timestamp.UTC().Format(time.RFC3339),
+				hex.EncodeToString(hdr.GetIndexShortID()),
+				strings.Join(decision.Reasons, ", "))
+		}
+	}
+
+	if cmd.DryRun {
+		fmt.Fprintf(ctx.Stdout, "%d snapshots would be removed, %d kept\n",
+			len(toRemove), len(snapshotIDs)-len(toRemove))
+		return 0, nil
+	}
+
+	var errors int
+	var mu sync.Mutex
+	wg := sync.WaitGroup{}
+	for _, snapshotID := range toRemove {
+		wg.Add(1)
+		go func(snapshotID objects.MAC) {
+			defer wg.Done()
+			if err := repo.DeleteSnapshot(snapshotID); err != nil {
+				ctx.GetLogger().Error("%s", err)
+				mu.Lock()
+				errors++
+				mu.Unlock()
+				return
+			}
+			ctx.GetLogger().Info("prune: removal of %x completed successfully", snapshotID[:4])
+		}(snapshotID)
+	}
+	wg.Wait()
+
+	if errors != 0 {
+		return 1, fmt.Errorf("failed to remove %d snapshots", errors)
+	}
+	return 0, nil
+}
@@ -0,0 +1,151 @@
+package prune
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/snapshot/header"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	os.Setenv("TZ", "UTC")
+}
+
+func mkHeader(id byte, ts string, job string) *header.Header {
+	t, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		panic(err)
+	}
+	hdr := header.NewHeader("default", objects.MAC{id})
+	hdr.Timestamp = t
+	hdr.Job = job
+	return hdr
+}
+
+func kept(decisions []Decision) []byte {
+	var ids []byte
+	for _, d := range decisions {
+		if d.Keep {
+			ids = append(ids, d.Header.Identifier[0])
+		}
+	}
+	return ids
+}
+
+func TestPolicyApply(t *testing.T) {
+	headers := []*header.Header{
+		mkHeader(1, "2026-10-17T02:30:00Z", "web"),
+		mkHeader(2, "2026-10-16T22:00:00Z", "web"),
+		mkHeader(3, "2026-10-16T02:30:00Z", "web"),
+		mkHeader(4, "2026-10-15T02:30:00Z", "web"),
+		mkHeader(5, "2026-10-11T02:30:00Z", "web"),
+		mkHeader(6, "2026-10-04T02:30:00Z", "web"),
+		mkHeader(7, "2026-09-30T02:30:00Z", "web"),
+		mkHeader(8, "2025-12-31T02:30:00Z", "web"),
+	}
+
+	decisions := Policy{Last: 2}.Apply(headers)
+	require.Len(t, decisions, len(headers))
+	require.Equal(t, []byte{1, 2}, kept(decisions))
+
+	// 2 is on the same day as 3 but more recent
+	decisions = Policy{Daily: 3}.Apply(headers)
+	require.Equal(t, []byte{1, 2, 4}, kept(decisions))
+	require.Equal(t, []string{"daily 2026-10-16"}, decisions[1].Reasons)
+
+	decisions = Policy{Weekly: 3}.Apply(headers)
+	require.Equal(t, []byte{1, 5, 6}, kept(decisions))
+
+	decisions = Policy{Monthly: 2, Yearly: 2}.Apply(headers)
+	require.Equal(t, []byte{1, 7, 8}, kept(decisions))
+	require.Equal(t, []string{"monthly 2026-10", "yearly 2026"}, decisions[0].Reasons)
+
+	require.True(t, Policy{}.Empty())
+	require.Equal(t, "last=1,weekly=4", Policy{Last: 1, Weekly: 4}.String())
+}
+
+func TestGroupKey(t *testing.T) {
+	groupBy, err := ParseGroupBy("name,job,tag")
+	require.NoError(t, err)
+
+	hdr := mkHeader(1, "2026-10-17T02:30:00Z", "web")
+	hdr.Tags = []string{"prod", "db"}
+	require.Equal(t, "name=default job=web tag=db,prod", GroupKey(hdr, groupBy))
+
+	_, err = ParseGroupBy("name,host")
+	require.Error(t, err)
+}
+
+func TestExecuteCmdPruneDryRun(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap1 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("dummy.txt", 0644, "hello dummy"),
+	})
+	defer snap1.Close()
+	snap2 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("dummy.txt", 0644, "hello again"),
+	})
+	defer snap2.Close()
+
+	subcommand := &Prune{}
+	err := subcommand.Parse(ctx, []string{"-keep-last", "1", "-dry-run"})
+	require.NoError(t, err)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	output := bufOut.String()
+	require.Contains(t, output, "1 snapshots would be removed, 1 kept")
+	require.Contains(t, output, hex.EncodeToString(snap1.Header.GetIndexShortID()))
+	require.Contains(t, output, hex.EncodeToString(snap2.Header.GetIndexShortID()))
+	require.NotContains(t, output, "removal of")
+}
+
+func TestExecuteCmdPrune(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap1 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("dummy.txt", 0644, "hello dummy"),
+	})
+	defer snap1.Close()
+	snap2 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("dummy.txt", 0644, "hello again"),
+	})
+	defer snap2.Close()
+
+	subcommand := &Prune{}
+	err := subcommand.Parse(ctx, []string{"-keep-last", "1"})
+	require.NoError(t, err)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	output := bufOut.String()
+	require.Contains(t, output, fmt.Sprintf("info: prune: removal of %s completed successfully", hex.EncodeToString(snap1.Header.GetIndexShortID())))
+	require.NotContains(t, output, fmt.Sprintf("removal of %s", hex.EncodeToString(snap2.Header.GetIndexShortID())))
+}
+
+func TestParseCmdPruneNoPolicy(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	_, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+
+	subcommand := &Prune{}
+	err := subcommand.Parse(ctx, []string{"-job", "web"})
+	require.Error(t, err)
+}
@@ -0,0 +1,155 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package restore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+)
+
+// ConflictPolicy tells what to do with files that already exist at the
+// destination.
+type ConflictPolicy int
+
+const (
+	ConflictOverwrite ConflictPolicy = iota
+	ConflictSkip
+	ConflictNewer
+	ConflictKeepBoth
+	ConflictFail
+)
+
+var conflictPolicies = map[string]ConflictPolicy{
+	"overwrite": ConflictOverwrite,
+	"skip":      ConflictSkip,
+	"newer":     ConflictNewer,
+	"keep-both": ConflictKeepBoth,
+	"fail":      ConflictFail,
+}
+
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	policy, ok := conflictPolicies[s]
+	if !ok {
+		return ConflictOverwrite, fmt.Errorf("invalid conflict policy %q, expected one of overwrite, skip, newer, keep-both or fail", s)
+	}
+	return policy, nil
+}
+
+func (p ConflictPolicy) String() string {
+	for name, policy := range conflictPolicies {
+		if policy == p {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+var ErrConflict = errors.New("file already exists")
+
+// Inspector is implemented by exporters that can look at the files
+// already present at the destination, which conflict policies other than
+// overwrite and resuming need.
+type Inspector interface {
+	Stat(pathname string) (fs.FileInfo, error)
+	Open(pathname string) (io.ReadCloser, error)
+}
+
+type conflictAction int
+
+const (
+	actionRestore conflictAction = iota
+	actionSkip
+	actionUpToDate
+)
+
+// resolveConflict tells what to do with an entry to restore at dest, and
+// where to restore it.
+func resolveConflict(repo *repository.Repository, snap *snapshot.Snapshot, inspector Inspector, e *vfs.Entry, dest string, opts *RestoreOptions) (conflictAction, string, error) {
+	if inspector == nil {
+		return actionRestore, dest, nil
+	}
+
+	info, err := inspector.Stat(dest)
+	if errors.Is(err, fs.ErrNotExist) {
+		return actionRestore, dest, nil
+	} else if err != nil {
+		return actionRestore, dest, err
+	}
+
+	if opts.Resume && info.Mode().IsRegular() && info.Size() == e.Size() {
+		same, err := sameContent(repo, snap, inspector, e, dest)
+		if err != nil {
+			return actionRestore, dest, err
+		}
+		if same {
+			return actionUpToDate, dest, nil
+		}
+	}
+
+	switch opts.Conflict {
+	case ConflictSkip:
+		return actionSkip, dest, nil
+	case ConflictNewer:
+		if !e.Stat().ModTime().After(info.ModTime()) {
+			return actionSkip, dest, nil
+		}
+	case ConflictKeepBoth:
+		for n := 1; ; n++ {
+			candidate := fmt.Sprintf("%s.~%d~", dest, n)
+			if _, err := inspector.Stat(candidate); errors.Is(err, fs.ErrNotExist) {
+				return actionRestore, candidate, nil
+			} else if err != nil {
+				return actionRestore, dest, err
+			}
+		}
+	case ConflictFail:
+		return actionRestore, dest, fmt.Errorf("%w: %s", ErrConflict, dest)
+	}
+	return actionRestore, dest, nil
+}
+
+// sameContent tells whether the file at dest has the content of e, by
+// comparing its MAC with the one recorded in the snapshot.
+func sameContent(repo *repository.Repository, snap *snapshot.Snapshot, inspector Inspector, e *vfs.Entry, dest string) (bool, error) {
+	if !e.HasObject() {
+		return e.Size() == 0, nil
+	}
+
+	object, err := snap.LookupObject(e.Object)
+	if err != nil {
+		return false, err
+	}
+
+	rd, err := inspector.Open(dest)
+	if err != nil {
+		return false, err
+	}
+	defer rd.Close()
+
+	hasher := repo.GetMACHasher()
+	if _, err := io.Copy(hasher, rd); err != nil {
+		return false, err
+	}
+	return bytes.Equal(hasher.Sum(nil), object.ContentMAC[:]), nil
+}
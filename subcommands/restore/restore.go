@@ -26,20 +26,37 @@ import (
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/kloset/snapshot/exporter"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/exclude"
 	"github.com/PlakarKorp/plakar/locate"
 	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/utils"
 )
 
 func init() {
 	subcommands.Register(func() subcommands.Subcommand { return &Restore{} }, subcommands.AgentSupport, "restore")
 }
 
+type patternFlags []string
+
+func (p *patternFlags) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patternFlags) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
 func (cmd *Restore) Parse(ctx *appcontext.AppContext, args []string) error {
 	var pullPath string
+	var opt_include patternFlags
+	var opt_exclude patternFlags
+	var opt_conflict string
 
 	flags := flag.NewFlagSet("restore", flag.ExitOnError)
 	flags.Usage = func() {
 		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] [SNAPSHOT[:PATH]]...\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "       %s [OPTIONS] -at TIME PATH\n", flags.Name())
 		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
 		flags.PrintDefaults()
 	}
@@ -53,16 +70,35 @@ func (cmd *Restore) Parse(ctx *appcontext.AppContext, args []string) error {
 	flags.StringVar(&cmd.OptTag, "tag", "", "filter by tag")
 
 	flags.StringVar(&pullPath, "to", "", "base directory where pull will restore")
+	flags.StringVar(&cmd.Source, "source", "", "restore only the given source (index or root path) of a multi-path snapshot")
+	flags.Var(&opt_include, "include", ".gitignore-style pattern of the files to restore, can be specified multiple times")
+	flags.Var(&opt_exclude, "exclude", ".gitignore-style pattern of the files not to restore, can be specified multiple times")
+	flags.StringVar(&opt_conflict, "conflict", "overwrite", "what to do with existing files: overwrite, skip, newer, keep-both or fail")
+	flags.BoolVar(&cmd.Resume, "resume", false, "skip existing files with the size and content of the snapshot version")
+	flags.Var(utils.NewTimeFlag(&cmd.At), "at", "restore PATH from the most recent snapshot holding it at the given time")
 	flags.BoolVar(&cmd.Quiet, "quiet", false, "do not print progress")
 	flags.BoolVar(&cmd.Silent, "silent", false, "do not print ANY progress")
 	flags.Parse(args)
 
-	if flags.NArg() != 0 {
+	if !cmd.At.IsZero() {
+		if flags.NArg() != 1 {
+			return fmt.Errorf("a single PATH must be specified with -at")
+		}
+	} else if flags.NArg() != 0 {
 		if cmd.OptName != "" || cmd.OptCategory != "" || cmd.OptEnvironment != "" || cmd.OptPerimeter != "" || cmd.OptJob != "" || cmd.OptTag != "" {
 			ctx.GetLogger().Warn("snapshot specified, filters will be ignored")
 		}
-	} else if flags.NArg() > 1 {
-		return fmt.Errorf("multiple restore paths specified, please specify only one")
+	}
+
+	for _, pattern := range append(opt_include, opt_exclude...) {
+		if err := exclude.Validate(pattern); err != nil {
+			return fmt.Errorf("failed to compile pattern: %w", err)
+		}
+	}
+
+	conflict, err := ParseConflictPolicy(opt_conflict)
+	if err != nil {
+		return err
 	}
 
 	if pullPath == "" {
@@ -72,6 +108,9 @@ func (cmd *Restore) Parse(ctx *appcontext.AppContext, args []string) error {
 	cmd.RepositorySecret = ctx.GetSecret()
 	cmd.Target = pullPath
 	cmd.Snapshots = flags.Args()
+	cmd.Includes = opt_include
+	cmd.Excludes = opt_exclude
+	cmd.Conflict = conflict
 
 	return nil
 }
@@ -88,10 +127,16 @@ type Restore struct {
 
 	Target      string
 	Strip       string
+	Source      string
 	Concurrency uint64
 	Quiet       bool
 	Silent      bool
 	Snapshots   []string
+	Includes    []string
+	Excludes    []string
+	Conflict    ConflictPolicy
+	Resume      bool
+	At          time.Time
 }
 
 func (cmd *Restore) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
@@ -99,7 +144,13 @@ func (cmd *Restore) Execute(ctx *appcontext.AppContext, repo *repository.Reposit
 		go eventsProcessorStdio(ctx, cmd.Quiet)
 	}
 	var snapshots []string
-	if len(cmd.Snapshots) == 0 {
+	if !cmd.At.IsZero() {
+		version, err := cmd.locateVersion(ctx, repo)
+		if err != nil {
+			return 1, err
+		}
+		snapshots = append(snapshots, fmt.Sprintf("%x:%s", version.Snapshot.Identifier, version.Pathname))
+	} else if len(cmd.Snapshots) == 0 {
 		locateOptions := locate.NewDefaultLocateOptions()
 		locateOptions.MaxConcurrency = ctx.MaxConcurrency
 		locateOptions.SortOrder = locate.LocateSortOrderAscending
@@ -122,6 +173,14 @@ func (cmd *Restore) Execute(ctx *appcontext.AppContext, repo *repository.Reposit
 	} else {
 		for _, snapshotPath := range cmd.Snapshots {
 			prefix, path := locate.ParseSnapshotPath(snapshotPath)
+			if locate.IsReference(prefix) {
+				snapshotID, err := locate.LocateSnapshot(repo, prefix)
+				if err != nil {
+					return 1, err
+				}
+				snapshots = append(snapshots, fmt.Sprintf("%x:%s", snapshotID, path))
+				continue
+			}
 
 			locateOptions := locate.NewDefaultLocateOptions()
 			locateOptions.MaxConcurrency = ctx.MaxConcurrency
@@ -148,8 +207,19 @@ func (cmd *Restore) Execute(ctx *appcontext.AppContext, repo *repository.Reposit
 
 	if len(snapshots) == 0 {
 		return 1, fmt.Errorf("no snapshots found")
-	} else if len(snapshots) > 1 {
-		return 1, fmt.Errorf("multiple snapshots found, please specify one")
+	}
+
+	// several paths may be restored, but all from the same snapshot
+	snapshotID, _ := locate.ParseSnapshotPath(snapshots[0])
+	for _, snapPath := range snapshots[1:] {
+		if prefix, _ := locate.ParseSnapshotPath(snapPath); prefix != snapshotID {
+			return 1, fmt.Errorf("multiple snapshots found, please specify one")
+		}
+	}
+
+	filter, err := NewFilter(cmd.Includes, cmd.Excludes)
+	if err != nil {
+		return 1, err
 	}
 
 	exporterConfig := map[string]string{
@@ -167,35 +237,88 @@ func (cmd *Restore) Execute(ctx *appcontext.AppContext, repo *repository.Reposit
 		}
 	}
 
-	var exporterInstance exporter.Exporter
-	var err error
-	exporterInstance, err = exporter.NewExporter(ctx.GetInner(), exporterConfig)
+	exporterInstance, err := exporter.NewExporter(ctx.GetInner(), exporterConfig)
 	if err != nil {
 		return 1, err
 	}
 	defer exporterInstance.Close()
 
-	opts := &snapshot.RestoreOptions{
-		MaxConcurrency: cmd.Concurrency,
+	opts := &RestoreOptions{
+		RestoreOptions: snapshot.RestoreOptions{
+			MaxConcurrency: cmd.Concurrency,
+		},
+		Filter:   filter,
+		Conflict: cmd.Conflict,
+		Resume:   cmd.Resume,
 	}
 
+	var snap *snapshot.Snapshot
+	var pathnames []string
 	for _, snapPath := range snapshots {
-		snap, pathname, err := locate.OpenSnapshotByPath(repo, snapPath)
+		s, pathname, err := locate.OpenSnapshotByPathInSource(repo, snapPath, cmd.Source)
 		if err != nil {
+			if snap != nil {
+				snap.Close()
+			}
 			return 1, err
 		}
-		opts.Strip = snap.Header.GetSource(0).Importer.Directory
+		if snap == nil {
+			snap = s
+		} else {
+			s.Close()
+		}
+		pathnames = append(pathnames, pathname)
+	}
+	defer snap.Close()
 
-		err = snap.Restore(exporterInstance, exporterInstance.Root(), pathname, opts)
+	opts.Strip = snap.Header.GetSource(0).Importer.Directory
+	if cmd.Source != "" {
+		opts.Strip, _ = locate.SourceRoot(snap.Header, cmd.Source)
+	}
 
-		if err != nil {
+	for _, pathname := range topmostPathnames(pathnames) {
+		if err := restore(repo, snap, exporterInstance, exporterInstance.Root(), pathname, opts); err != nil {
 			return 1, err
 		}
 		ctx.GetLogger().Info("restore: restoration of %x:%s at %s completed successfully",
 			snap.Header.GetIndexShortID(),
 			pathname,
 			cmd.Target)
-		snap.Close()
 	}
 	return 0, nil
 }
+
+// pathnameLocateOptions returns the options locating the snapshots that
+// may hold the PATH of -at, most recent first.
+func (cmd *Restore) pathnameLocateOptions(ctx *appcontext.AppContext) *locate.LocateOptions {
+	locateOptions := locate.NewDefaultLocateOptions()
+	locateOptions.MaxConcurrency = ctx.MaxConcurrency
+	locateOptions.SortOrder = locate.LocateSortOrderDescending
+	locateOptions.Before = cmd.At
+
+	locateOptions.Name = cmd.OptName
+	locateOptions.Category = cmd.OptCategory
+	locateOptions.Environment = cmd.OptEnvironment
+	locateOptions.Perimeter = cmd.OptPerimeter
+	locateOptions.Job = cmd.OptJob
+	locateOptions.Tag = cmd.OptTag
+	return locateOptions
+}
+
+// locateVersion finds the version of PATH as it was at the time of -at,
+// that is the one in the most recent snapshot holding it by then.
+func (cmd *Restore) locateVersion(ctx *appcontext.AppContext, repo *repository.Repository) (*locate.PathnameVersion, error) {
+	pathname := cmd.Snapshots[0]
+	for version, err := range locate.LocatePathname(repo, cmd.pathnameLocateOptions(ctx), pathname, cmd.Source) {
+		if err != nil {
+			return nil, err
+		}
+		ctx.GetLogger().Info("restore: %s as of %s is in snapshot %x taken at %s",
+			version.Pathname,
+			cmd.At.UTC().Format(time.RFC3339),
+			version.Snapshot.GetIndexShortID(),
+			version.Snapshot.Timestamp.UTC().Format(time.RFC3339))
+		return version, nil
+	}
+	return nil, fmt.Errorf("no snapshot holds %s as of %s", pathname, cmd.At.UTC().Format(time.RFC3339))
+}
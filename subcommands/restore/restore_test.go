@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
@@ -107,3 +108,210 @@ func TestExecuteCmdRestoreSpecificSnapshot(t *testing.T) {
 
 	checkRestored(t, tmpToRestoreDir)
 }
+
+func TestExecuteCmdRestoreFilter(t *testing.T) {
+	repo, snap, ctx := generateSnapshot(t)
+	defer snap.Close()
+
+	snapshotID := hex.EncodeToString(snap.Header.GetIndexShortID())
+
+	for _, test := range []struct {
+		args     []string
+		expected []string
+	}{
+		{
+			args:     []string{"-exclude", "foo.txt", snapshotID + ":/subdir", snapshotID + ":/another_subdir"},
+			expected: []string{"subdir/dummy.txt", "another_subdir/bar.txt"},
+		},
+		{
+			args:     []string{"-include", "*.txt", "-exclude", "another_subdir/", snapshotID},
+			expected: []string{"subdir/dummy.txt", "subdir/foo.txt"},
+		},
+		{
+			args:     []string{"-include", "/another_subdir", snapshotID + ":/subdir/foo.txt", snapshotID},
+			expected: []string{"another_subdir/bar.txt"},
+		},
+	} {
+		tmpToRestoreDir := t.TempDir()
+
+		args := append([]string{"-to", tmpToRestoreDir}, test.args...)
+
+		subcommand := &Restore{}
+		err := subcommand.Parse(ctx, args)
+		require.NoError(t, err)
+
+		status, err := subcommand.Execute(ctx, repo)
+		require.NoError(t, err)
+		require.Equal(t, 0, status)
+
+		var restored []string
+		err = filepath.WalkDir(tmpToRestoreDir, func(pathname string, d os.DirEntry, err error) error {
+			require.NoError(t, err)
+			if !d.IsDir() {
+				rel, err := filepath.Rel(tmpToRestoreDir, pathname)
+				require.NoError(t, err)
+				restored = append(restored, filepath.ToSlash(rel))
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		require.ElementsMatch(t, test.expected, restored, args)
+	}
+}
+
+func TestExecuteCmdRestoreMultipleSnapshots(t *testing.T) {
+	repo, snap, ctx := generateSnapshot(t)
+	defer snap.Close()
+	other := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+	})
+	defer other.Close()
+
+	args := []string{"-to", t.TempDir(),
+		hex.EncodeToString(snap.Header.GetIndexShortID()),
+		hex.EncodeToString(other.Header.GetIndexShortID())}
+
+	subcommand := &Restore{}
+	err := subcommand.Parse(ctx, args)
+	require.NoError(t, err)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.ErrorContains(t, err, "multiple snapshots found")
+	require.Equal(t, 1, status)
+}
+
+func TestExecuteCmdRestoreConflict(t *testing.T) {
+	repo, snap, ctx := generateSnapshot(t)
+	defer snap.Close()
+
+	snapshotID := hex.EncodeToString(snap.Header.GetIndexShortID())
+
+	for _, test := range []struct {
+		args     []string
+		existing string
+		expected map[string]string
+		err      error
+	}{
+		{
+			args:     nil,
+			existing: "local",
+			expected: map[string]string{"dummy.txt": "hello dummy"},
+		},
+		{
+			args:     []string{"-conflict", "skip"},
+			existing: "local",
+			expected: map[string]string{"dummy.txt": "local"},
+		},
+		{
+			// the snapshot version is older
+			args:     []string{"-conflict", "newer"},
+			existing: "local",
+			expected: map[string]string{"dummy.txt": "local"},
+		},
+		{
+			args:     []string{"-conflict", "keep-both"},
+			existing: "local",
+			expected: map[string]string{"dummy.txt": "local", "dummy.txt.~1~": "hello dummy"},
+		},
+		{
+			args:     []string{"-conflict", "fail"},
+			existing: "local",
+			err:      ErrConflict,
+		},
+		{
+			args:     []string{"-conflict", "fail", "-resume"},
+			existing: "hello dummy",
+			expected: map[string]string{"dummy.txt": "hello dummy"},
+		},
+	} {
+		tmpToRestoreDir := t.TempDir()
+		subdir := filepath.Join(tmpToRestoreDir, "subdir")
+		require.NoError(t, os.MkdirAll(subdir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(subdir, "dummy.txt"), []byte(test.existing), 0600))
+
+		args := append([]string{"-to", tmpToRestoreDir}, test.args...)
+		args = append(args, snapshotID+":/subdir/dummy.txt")
+
+		subcommand := &Restore{}
+		err := subcommand.Parse(ctx, args)
+		require.NoError(t, err)
+
+		status, err := subcommand.Execute(ctx, repo)
+		if test.err != nil {
+			require.ErrorIs(t, err, test.err, args)
+			require.Equal(t, 1, status)
+			continue
+		}
+		require.NoError(t, err, args)
+		require.Equal(t, 0, status)
+
+		entries, err := os.ReadDir(subdir)
+		require.NoError(t, err)
+		require.Len(t, entries, len(test.expected), args)
+		for name, expected := range test.expected {
+			content, err := os.ReadFile(filepath.Join(subdir, name))
+			require.NoError(t, err)
+			require.Equal(t, expected, string(content), args)
+		}
+
+		if test.existing == "hello dummy" {
+			// resumed files still get their permissions
+			info, err := os.Stat(filepath.Join(subdir, "dummy.txt"))
+			require.NoError(t, err)
+			require.Equal(t, os.FileMode(0644), info.Mode().Perm())
+		}
+	}
+
+	subcommand := &Restore{}
+	err := subcommand.Parse(ctx, []string{"-conflict", "ask", snapshotID})
+	require.ErrorContains(t, err, "invalid conflict policy")
+}
+
+func TestExecuteCmdRestoreAt(t *testing.T) {
+	repo, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+	old := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy"),
+	})
+	defer old.Close()
+	removed := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+	})
+	defer removed.Close()
+	recent := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy, again"),
+	})
+	defer recent.Close()
+
+	for at, expected := range map[time.Time]string{
+		removed.Header.Timestamp: "hello dummy",
+		recent.Header.Timestamp:  "hello dummy, again",
+	} {
+		restoreDir := t.TempDir()
+		args := []string{"-to", restoreDir, "-at", at.Format(time.RFC3339Nano), "/subdir/dummy.txt"}
+
+		subcommand := &Restore{}
+		err := subcommand.Parse(ctx, args)
+		require.NoError(t, err)
+
+		status, err := subcommand.Execute(ctx, repo)
+		require.NoError(t, err)
+		require.Equal(t, 0, status)
+
+		content, err := os.ReadFile(filepath.Join(restoreDir, "subdir", "dummy.txt"))
+		require.NoError(t, err)
+		require.Equal(t, expected, string(content))
+	}
+
+	args := []string{"-to", t.TempDir(), "-at", old.Header.Timestamp.Add(-time.Hour).Format(time.RFC3339Nano), "/subdir/dummy.txt"}
+	subcommand := &Restore{}
+	require.NoError(t, subcommand.Parse(ctx, args))
+	status, err := subcommand.Execute(ctx, repo)
+	require.ErrorContains(t, err, "no snapshot holds /subdir/dummy.txt")
+	require.Equal(t, 1, status)
+
+	subcommand = &Restore{}
+	err = subcommand.Parse(ctx, []string{"-at", "1h", "/subdir/dummy.txt", "/subdir"})
+	require.ErrorContains(t, err, "a single PATH must be specified")
+}
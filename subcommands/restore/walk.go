@@ -0,0 +1,286 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package restore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/PlakarKorp/kloset/events"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/exporter"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/exclude"
+	"golang.org/x/sync/errgroup"
+)
+
+// Filter selects the entries to restore with .gitignore-style patterns,
+// relative to the root of the snapshot.  An entry is restored if it or
+// one of its parent directories matches an include pattern, if any, and
+// it is not excluded.  Excluded directories are not walked at all.
+type Filter struct {
+	includes *exclude.Ruleset
+	excludes *exclude.Ruleset
+}
+
+func NewFilter(includes []string, excludes []string) (*Filter, error) {
+	f := &Filter{
+		includes: exclude.NewRuleset(),
+		excludes: exclude.NewRuleset(),
+	}
+	if err := f.includes.Add("/", includes); err != nil {
+		return nil, err
+	}
+	if err := f.excludes.Add("/", excludes); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Excluded tells whether an entry, and everything below it for a
+// directory, must be skipped.  Parent directories are not considered as
+// they are never walked when excluded.
+func (f *Filter) Excluded(pathname string, isDir bool) bool {
+	return f.excludes.Match(pathname, isDir)
+}
+
+// Included tells whether an entry that is not excluded must be restored.
+// Directories that are not included are still walked, as they may hold
+// included entries.
+func (f *Filter) Included(pathname string, isDir bool) bool {
+	if f.includes.Empty() {
+		return true
+	}
+	return f.includes.Excluded(pathname, isDir)
+}
+
+// RestoreOptions extends snapshot.RestoreOptions, which has no way to
+// select what is restored below the pathname nor to deal with existing
+// files.
+type RestoreOptions struct {
+	snapshot.RestoreOptions
+
+	Filter *Filter
+
+	// Conflict is the policy for files already at the destination
+	Conflict ConflictPolicy
+
+	// Resume skips the files already at the destination with the size
+	// and content of the snapshot version
+	Resume bool
+}
+
+type restoreContext struct {
+	ctx            context.Context
+	repo           *repository.Repository
+	inspector      Inspector
+	hardlinks      map[string]string
+	hardlinksMutex sync.Mutex
+}
+
+func restorePath(snap *snapshot.Snapshot, exp exporter.Exporter, target string, opts *RestoreOptions, restoreContext *restoreContext, wg *errgroup.Group) vfs.WalkDirFunc {
+	return func(entrypath string, e *vfs.Entry, err error) error {
+		if err != nil {
+			snap.Event(events.PathErrorEvent(snap.Header.Identifier, entrypath, err.Error()))
+			return err
+		}
+
+		if err := restoreContext.ctx.Err(); err != nil {
+			return err
+		}
+
+		if opts.Filter != nil {
+			if opts.Filter.Excluded(entrypath, e.IsDir()) {
+				if e.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if !opts.Filter.Included(entrypath, e.IsDir()) {
+				// parents of included entries are created on demand
+				return nil
+			}
+		}
+
+		snap.Event(events.PathEvent(snap.Header.Identifier, entrypath))
+
+		dest := path.Join(target, strings.TrimPrefix(entrypath, opts.Strip))
+
+		if e.IsDir() {
+			snap.Event(events.DirectoryEvent(snap.Header.Identifier, entrypath))
+			if entrypath != "/" {
+				if err := exp.CreateDirectory(dest); err != nil {
+					err := fmt.Errorf("failed to create directory %q: %w", dest, err)
+					snap.Event(events.DirectoryErrorEvent(snap.Header.Identifier, entrypath, err.Error()))
+					return err
+				}
+				if err := exp.SetPermissions(dest, e.Stat()); err != nil {
+					err := fmt.Errorf("failed to set permissions on directory %q: %w", dest, err)
+					snap.Event(events.DirectoryErrorEvent(snap.Header.Identifier, entrypath, err.Error()))
+					return err
+				}
+			}
+			snap.Event(events.DirectoryOKEvent(snap.Header.Identifier, entrypath))
+			return nil
+		}
+
+		if !e.Stat().Mode().IsRegular() {
+			snap.Event(events.FileErrorEvent(snap.Header.Identifier, entrypath, "unexpected vfs entry type"))
+			return nil
+		}
+
+		snap.Event(events.FileEvent(snap.Header.Identifier, entrypath))
+		wg.Go(func() error {
+			action, dest, err := resolveConflict(restoreContext.repo, snap, restoreContext.inspector, e, dest, opts)
+			if err != nil {
+				snap.Event(events.FileErrorEvent(snap.Header.Identifier, entrypath, err.Error()))
+				if errors.Is(err, ErrConflict) {
+					return err
+				}
+				return nil
+			}
+			switch action {
+			case actionSkip:
+				snap.Logger().Info("restore: skipping existing file %s", dest)
+				return nil
+			case actionUpToDate:
+				if err := exp.SetPermissions(dest, e.Stat()); err != nil {
+					err := fmt.Errorf("failed to set permissions on file %q: %w", entrypath, err)
+					snap.Event(events.FileErrorEvent(snap.Header.Identifier, entrypath, err.Error()))
+				} else {
+					snap.Event(events.FileOKEvent(snap.Header.Identifier, entrypath, e.Size()))
+				}
+				return nil
+			}
+
+			if e.Stat().Nlink() > 1 {
+				key := fmt.Sprintf("%d:%d", e.Stat().Dev(), e.Stat().Ino())
+				restoreContext.hardlinksMutex.Lock()
+				v, ok := restoreContext.hardlinks[key]
+				if !ok {
+					restoreContext.hardlinks[key] = dest
+				}
+				restoreContext.hardlinksMutex.Unlock()
+				if ok {
+					if err := os.Link(v, dest); err != nil {
+						snap.Event(events.FileErrorEvent(snap.Header.Identifier, entrypath, err.Error()))
+					}
+					return nil
+				}
+			}
+
+			rd, err := snap.NewReader(entrypath)
+			if err != nil {
+				err := fmt.Errorf("failed to open file in the snapshot %q: %w", entrypath, err)
+				snap.Event(events.FileErrorEvent(snap.Header.Identifier, entrypath, err.Error()))
+				return nil
+			}
+			defer rd.Close()
+
+			if err := exp.CreateDirectory(path.Dir(dest)); err != nil {
+				err := fmt.Errorf("failed to create directory %q: %w", dest, err)
+				snap.Event(events.FileErrorEvent(snap.Header.Identifier, entrypath, err.Error()))
+			}
+
+			if err := exp.StoreFile(dest, rd, e.Size()); err != nil {
+				err := fmt.Errorf("failed to write file %q at %q: %w", entrypath, dest, err)
+				snap.Event(events.FileErrorEvent(snap.Header.Identifier, entrypath, err.Error()))
+			} else if err := exp.SetPermissions(dest, e.Stat()); err != nil {
+				err := fmt.Errorf("failed to set permissions on file %q: %w", entrypath, err)
+				snap.Event(events.FileErrorEvent(snap.Header.Identifier, entrypath, err.Error()))
+			} else {
+				snap.Event(events.FileOKEvent(snap.Header.Identifier, entrypath, e.Size()))
+			}
+			return nil
+		})
+		return nil
+	}
+}
+
+// restore is snap.Restore with the entries selected by opts.Filter and
+// existing files handled according to opts.Conflict and opts.Resume.
+func restore(repo *repository.Repository, snap *snapshot.Snapshot, exp exporter.Exporter, base string, pathname string, opts *RestoreOptions) error {
+	inspector, _ := exp.(Inspector)
+	if inspector == nil && (opts.Conflict != ConflictOverwrite || opts.Resume) {
+		return fmt.Errorf("exporter cannot check existing files, only the overwrite conflict policy is supported")
+	}
+
+	snap.Event(events.StartEvent())
+	defer snap.Event(events.DoneEvent())
+
+	pvfs, err := snap.Filesystem()
+	if err != nil {
+		return err
+	}
+
+	maxConcurrency := opts.MaxConcurrency
+	if maxConcurrency == 0 {
+		maxConcurrency = uint64(snap.AppContext().MaxConcurrency)
+	}
+
+	wg, ctx := errgroup.WithContext(snap.AppContext())
+	wg.SetLimit(int(maxConcurrency))
+
+	restoreContext := &restoreContext{
+		ctx:       ctx,
+		repo:      repo,
+		hardlinks: make(map[string]string),
+	}
+	// overwriting needs no look at the destination
+	if opts.Conflict != ConflictOverwrite || opts.Resume {
+		restoreContext.inspector = inspector
+	}
+
+	base = path.Clean(base)
+	if base != "/" && !strings.HasSuffix(base, "/") {
+		base = base + "/"
+	}
+
+	err = pvfs.WalkDir(pathname, restorePath(snap, exp, base, opts, restoreContext, wg))
+	// a failed file stops the walk, it is the error to report
+	if werr := wg.Wait(); werr != nil {
+		return werr
+	}
+	return err
+}
+
+// topmostPathnames returns the pathnames that are not within another one
+// of the list, sorted.
+func topmostPathnames(pathnames []string) []string {
+	sorted := append([]string(nil), pathnames...)
+	slices.Sort(sorted)
+
+	var topmost []string
+	for _, pathname := range sorted {
+		if len(topmost) != 0 {
+			last := topmost[len(topmost)-1]
+			if pathname == last || last == "/" || strings.HasPrefix(pathname, last+"/") {
+				continue
+			}
+		}
+		topmost = append(topmost, pathname)
+	}
+	return topmost
+}
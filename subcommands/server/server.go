@@ -17,8 +17,10 @@
 package server
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"net/http"
 
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/plakar/appcontext"
@@ -32,6 +34,7 @@ func init() {
 
 func (cmd *Server) Parse(ctx *appcontext.AppContext, args []string) error {
 	var opt_allowdelete bool
+	var opt_role string
 	flags := flag.NewFlagSet("server", flag.ExitOnError)
 	flags.Usage = func() {
 		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS]\n", flags.Name())
@@ -41,15 +44,37 @@ func (cmd *Server) Parse(ctx *appcontext.AppContext, args []string) error {
 
 	flags.StringVar(&cmd.ListenAddr, "listen", "127.0.0.1:9876", "address to listen on")
 	flags.BoolVar(&opt_allowdelete, "allow-delete", false, "enable delete operations")
+	flags.StringVar(&opt_role, "role", "read-write", "access granted to clients: read-only, append-only or read-write")
+	flags.StringVar(&cmd.TokenFile, "token-file", "", "require clients to present one of the bearer tokens listed in `file`")
+	flags.StringVar(&cmd.TLSCert, "tls-cert", "", "serve over TLS with the certificate in `file`")
+	flags.StringVar(&cmd.TLSKey, "tls-key", "", "private key of the TLS certificate")
+	flags.StringVar(&cmd.TLSClientCA, "tls-client-ca", "", "require client certificates signed by the CA in `file`")
 	flags.Parse(args)
 
+	if flags.NArg() != 0 {
+		return fmt.Errorf("too many arguments")
+	}
+
 	noDelete := true
 	if opt_allowdelete {
 		noDelete = false
 	}
 
+	role, err := httpd.ParseRole(opt_role)
+	if err != nil {
+		return err
+	}
+
+	if (cmd.TLSCert == "") != (cmd.TLSKey == "") {
+		return fmt.Errorf("-tls-cert and -tls-key must be given together")
+	}
+	if cmd.TLSClientCA != "" && cmd.TLSCert == "" {
+		return fmt.Errorf("-tls-client-ca requires -tls-cert and -tls-key")
+	}
+
 	cmd.RepositorySecret = ctx.GetSecret()
 	cmd.NoDelete = noDelete
+	cmd.Role = role
 
 	return nil
 }
@@ -57,11 +82,30 @@ func (cmd *Server) Parse(ctx *appcontext.AppContext, args []string) error {
 type Server struct {
 	subcommands.SubcommandBase
 
-	ListenAddr string
-	NoDelete   bool
+	ListenAddr  string
+	NoDelete    bool
+	Role        httpd.Role
+	TokenFile   string
+	TLSCert     string
+	TLSKey      string
+	TLSClientCA string
 }
 
 func (cmd *Server) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
-	httpd.Server(ctx, repo, cmd.ListenAddr, cmd.NoDelete)
+	if cmd.TokenFile != "" && cmd.TLSCert == "" {
+		ctx.GetLogger().Warn("server: bearer tokens are sent in clear without TLS")
+	}
+
+	err := httpd.Server(ctx, repo, cmd.ListenAddr, &httpd.Options{
+		NoDelete:    cmd.NoDelete,
+		Role:        cmd.Role,
+		TokenFile:   cmd.TokenFile,
+		TLSCert:     cmd.TLSCert,
+		TLSKey:      cmd.TLSKey,
+		TLSClientCA: cmd.TLSClientCA,
+	})
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return 1, fmt.Errorf("server: %w", err)
+	}
 	return 0, nil
 }
@@ -35,6 +35,9 @@ type Subcommand interface {
 	SetLogInfo(bool)
 	GetLogTraces() string
 	SetLogTraces(string)
+
+	GetOutputFormat() string
+	SetOutputFormat(string)
 }
 
 type SubcommandBase struct {
@@ -46,6 +49,10 @@ type SubcommandBase struct {
 	// XXX - rework that post-release
 	LogInfo   bool
 	LogTraces string
+
+	// output.Text, output.JSON or output.NDJSON, carried along with the
+	// command so that it applies when executed by the agent
+	OutputFormat string
 }
 
 func (cmd *SubcommandBase) setFlags(flags CommandFlags) {
@@ -88,6 +95,14 @@ func (cmd *SubcommandBase) SetLogTraces(traces string) {
 	cmd.LogTraces = traces
 }
 
+func (cmd *SubcommandBase) GetOutputFormat() string {
+	return cmd.OutputFormat
+}
+
+func (cmd *SubcommandBase) SetOutputFormat(format string) {
+	cmd.OutputFormat = format
+}
+
 func (cmd *SubcommandBase) GetRepositorySecret() []byte {
 	return cmd.RepositorySecret
 }
@@ -76,6 +76,8 @@ func (m *MockFile) ScanResult() *importer.ScanResult {
 
 type testingOptions struct {
 	name string
+	job  string
+	tags []string
 	gen  func(chan<- *importer.ScanResult)
 }
 
@@ -93,6 +95,18 @@ func WithName(name string) TestingOptions {
 	}
 }
 
+func WithJob(job string) TestingOptions {
+	return func(o *testingOptions) {
+		o.job = job
+	}
+}
+
+func WithTags(tags ...string) TestingOptions {
+	return func(o *testingOptions) {
+		o.tags = tags
+	}
+}
+
 func GenerateFiles(t *testing.T, files []MockFile) string {
 	tmpBackupDir, err := os.MkdirTemp("", "tmp_to_backup")
 	require.NoError(t, err)
@@ -149,7 +163,8 @@ func GenerateSnapshot(t *testing.T, repo *repository.Repository, files []MockFil
 		imp.(*MockImporter).SetFiles(files)
 	}
 
-	builder.Backup(imp, &snapshot.BackupOptions{Name: o.name, MaxConcurrency: 1})
+	builder.Header.Job = o.job
+	builder.Backup(imp, &snapshot.BackupOptions{Name: o.name, Tags: o.tags, MaxConcurrency: 1})
 
 	err = builder.Repository().RebuildState()
 	require.NoError(t, err)
@@ -0,0 +1,53 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package utils
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/dustin/go-humanize"
+)
+
+// SizeFlag implements flag.Value interface for sizes such as "10MB" or
+// "1.5GiB"
+type SizeFlag struct {
+	dest *int64
+}
+
+func NewSizeFlag(dest *int64) *SizeFlag {
+	return &SizeFlag{dest}
+}
+
+func (s *SizeFlag) String() string {
+	if s.dest == nil || *s.dest < 0 {
+		return ""
+	}
+	return humanize.Bytes(uint64(*s.dest))
+}
+
+func (s *SizeFlag) Set(input string) error {
+	size, err := humanize.ParseBytes(input)
+	if err != nil {
+		return fmt.Errorf("invalid size: %q", input)
+	}
+	if size > math.MaxInt64 {
+		return fmt.Errorf("invalid size: %q", input)
+	}
+	*s.dest = int64(size)
+	return nil
+}
@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSizeFlag(t *testing.T) {
+	size := int64(-1)
+	flag := NewSizeFlag(&size)
+	require.Equal(t, "", flag.String())
+
+	require.NoError(t, flag.Set("10"))
+	require.Equal(t, int64(10), size)
+
+	require.NoError(t, flag.Set("1.5 kB"))
+	require.Equal(t, int64(1500), size)
+
+	require.NoError(t, flag.Set("2MiB"))
+	require.Equal(t, int64(2*1024*1024), size)
+	require.Equal(t, "2.1 MB", flag.String())
+
+	require.Error(t, flag.Set("lots"))
+	require.Equal(t, int64(2*1024*1024), size)
+}